@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AffectedTargets are the modules, examples and test targets affected by a change.
+type AffectedTargets struct {
+	// Modules are the names of the affected modules, e.g. "default".
+	Modules []string `json:"modules"`
+	// Examples are the directories of the affected examples, e.g. "examples/default/basic".
+	Examples []string `json:"examples"`
+	// TestTargets are the directories of the affected test targets, e.g. "tests/modules/default/target/basic".
+	TestTargets []string `json:"test_targets"`
+}
+
+// getChangedFiles returns the files changed between two revisions of the git repository of the
+// source directory, relative to its root. Renames are reported as a deletion and an addition,
+// so both paths are listed.
+func (m *Infra) getChangedFiles(ctx context.Context, baseRef, headRef string) ([]string, error) {
+	if headRef == "" {
+		headRef = "HEAD"
+	}
+
+	output, err := m.Ctr.
+		WithWorkdir(defaultMntPath).
+		WithExec([]string{
+			"git", "-c", "safe.directory=*", "diff", "--name-only", "--no-renames", baseRef + "..." + headRef, "--",
+		}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to diff %s...%s, the source directory must include .git and both revisions "+
+			"(e.g. fetch-depth: 0 in actions/checkout)", baseRef, headRef)
+	}
+
+	var files []string
+
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+
+	return files, nil
+}
+
+// getChangedTargetDirs maps changed files to the directories of the modules, examples and test
+// targets that hold them. A change elsewhere in the tests of a module (e.g. a unit test) affects
+// every test target of the module.
+func getChangedTargetDirs(changedFiles []string, targetDirs []string) []string {
+	var changed []string
+
+	for _, changedFile := range changedFiles {
+		for _, dir := range targetDirs {
+			affected := strings.HasPrefix(changedFile, dir+"/")
+
+			parts := strings.Split(dir, "/")
+			if !affected && strings.HasPrefix(dir, configTestTargetsRootPath+"/") && len(parts) > 3 {
+				moduleTestsDir := strings.Join(parts[:3], "/") + "/"
+				affected = strings.HasPrefix(changedFile, moduleTestsDir) &&
+					!strings.HasPrefix(changedFile, moduleTestsDir+"target/")
+			}
+
+			if affected && !contains(changed, dir) {
+				changed = append(changed, dir)
+			}
+		}
+	}
+
+	return changed
+}
+
+// getAffectedTargetDirs returns the changed directories and, transitively, the directories that
+// call them with a local source.
+func getAffectedTargetDirs(changed []string, targets []*terraformVersionsTarget) []string {
+	callers := map[string][]string{}
+
+	for _, target := range targets {
+		for _, call := range target.calls {
+			callers[call] = append(callers[call], target.dir)
+		}
+	}
+
+	affected := append([]string{}, changed...)
+
+	for pending := changed; len(pending) > 0; {
+		dir := pending[0]
+		pending = pending[1:]
+
+		for _, caller := range callers[dir] {
+			if !contains(affected, caller) {
+				affected = append(affected, caller)
+				pending = append(pending, caller)
+			}
+		}
+	}
+
+	sort.Strings(affected)
+
+	return affected
+}
+
+// getAffectedTargets returns the modules, examples and test targets affected by the changes
+// between two revisions.
+func (m *Infra) getAffectedTargets(ctx context.Context, baseRef, headRef string) (*AffectedTargets, error) {
+	if m.Src == nil {
+		return nil, NewError("failed to select the affected modules, the source directory is nil")
+	}
+
+	if baseRef == "" {
+		return nil, NewError("failed to select the affected modules, the base revision is empty")
+	}
+
+	changedFiles, err := m.getChangedFiles(ctx, baseRef, headRef)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := m.getTerraformVersionsTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	targetDirs := make([]string, len(targets))
+	for i, target := range targets {
+		targetDirs[i] = target.dir
+	}
+
+	affected := &AffectedTargets{Modules: []string{}, Examples: []string{}, TestTargets: []string{}}
+
+	for _, dir := range getAffectedTargetDirs(getChangedTargetDirs(changedFiles, targetDirs), targets) {
+		switch {
+		case strings.HasPrefix(dir, configTerraformModulesRootPath+"/"):
+			affected.Modules = append(affected.Modules, filepath.Base(dir))
+		case strings.HasPrefix(dir, configExamplesRootPath+"/"):
+			affected.Examples = append(affected.Examples, dir)
+		default:
+			affected.TestTargets = append(affected.TestTargets, dir)
+		}
+	}
+
+	return affected, nil
+}
+
+// ActionTerraformAffectedModules returns the modules, examples and test targets affected by the
+// changes between two revisions, as JSON, e.g. for the matrix of a CI workflow.
+//
+// Changed files are mapped to the module (modules/<module>), example (examples/<module>/<example>)
+// or test target (tests/modules/<module>/target/<target>) that holds them, then local module
+// sources (source = "../../modules/x") are followed in reverse, so the examples, test targets and
+// modules calling an affected module are affected too. The source directory must include the
+// .git directory, with both revisions.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - baseRef: The base revision, e.g. "origin/main"
+//   - headRef: The head revision (defaults to "HEAD")
+//
+// Returns:
+//   - string: The affected targets, as {"modules": [...], "examples": [...], "test_targets": [...]}
+//   - error: An error if the revisions cannot be compared, or a module cannot be parsed
+func (m *Infra) ActionTerraformAffectedModules(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// baseRef is the base revision, e.g. "origin/main".
+	baseRef string,
+	// headRef is the head revision, e.g. a commit SHA. HEAD when empty.
+	// +optional
+	headRef string,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	affected, err := m.getAffectedTargets(ctx, baseRef, headRef)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(affected)
+	if err != nil {
+		return "", WrapError(err, "failed to encode the affected modules")
+	}
+
+	return string(encoded), nil
+}
+
+// WithAffectedModules limits the actions to the modules, examples and test targets affected by the
+// changes between two revisions (see ActionTerraformAffectedModules):
+//   - ActionTerraformProvidersLock and ActionTerraformProvidersMirror fan out over the affected
+//     modules only, unless modules are given explicitly;
+//   - ActionTerraformVersionsCheck checks and reports the affected modules, examples and test
+//     targets only;
+//   - the actions on a single module (ActionTerraformPlanMatrix, ActionTerraformProviderVersionMatrix,
+//     and the static analysis, version compatibility, lint and docs actions) report an unaffected
+//     module as skipped instead of running.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - baseRef: The base revision, e.g. "origin/main"
+//   - headRef: The head revision (defaults to "HEAD")
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the affected targets selected
+//   - error: An error if the revisions cannot be compared, or a module cannot be parsed
+func (m *Infra) WithAffectedModules(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// baseRef is the base revision, e.g. "origin/main".
+	baseRef string,
+	// headRef is the head revision, e.g. a commit SHA. HEAD when empty.
+	// +optional
+	headRef string,
+) (*Infra, error) {
+	affected, err := m.getAffectedTargets(ctx, baseRef, headRef)
+	if err != nil {
+		return nil, err
+	}
+
+	m.AffectedTargets = affected
+
+	return m, nil
+}
+
+// getSelectedModuleNames returns the modules an action fans out over by default: the affected
+// modules when WithAffectedModules was called, every module otherwise.
+func (m *Infra) getSelectedModuleNames(ctx context.Context) ([]string, error) {
+	if m.AffectedTargets != nil {
+		return m.AffectedTargets.Modules, nil
+	}
+
+	return m.getTerraformModuleNames(ctx)
+}
+
+// getDirs returns the directories of the affected modules, examples and test targets.
+func (a *AffectedTargets) getDirs() []string {
+	dirs := make([]string, 0, len(a.Modules)+len(a.Examples)+len(a.TestTargets))
+
+	for _, module := range a.Modules {
+		dirs = append(dirs, getTerraformModulesExecutionPath(module))
+	}
+
+	dirs = append(dirs, a.Examples...)
+
+	return append(dirs, a.TestTargets...)
+}
+
+// isModuleAffected reports whether an action on a module must run: always, unless
+// WithAffectedModules was called and the module is not affected.
+func (m *Infra) isModuleAffected(tfModulePath string) bool {
+	if m.AffectedTargets == nil {
+		return true
+	}
+
+	return contains(m.AffectedTargets.Modules, filepath.Base(getTerraformModulesExecutionPath(tfModulePath)))
+}
+
+// getNotAffectedReport returns the report of an action skipped because its module is not affected.
+func getNotAffectedReport(tfModulePath, action string) (string, error) {
+	return ProcessActionSyncResults([]JobResult{{
+		WorkDir: tfModulePath + "." + action,
+		Output:  "skipped: the module is not affected by the selected changes (see WithAffectedModules)\n",
+	}})
+}
+
+// isFindingAffected reports whether a finding names one of the directories, as a whole path.
+func isFindingAffected(finding string, dirs []string) bool {
+	for _, dir := range dirs {
+		for offset := 0; ; {
+			index := strings.Index(finding[offset:], dir)
+			if index < 0 {
+				break
+			}
+
+			start, end := offset+index, offset+index+len(dir)
+			before := start == 0 || strings.ContainsRune(" (", rune(finding[start-1]))
+			after := end == len(finding) || strings.ContainsRune(":,) ", rune(finding[end]))
+
+			if before && after {
+				return true
+			}
+
+			offset = end
+		}
+	}
+
+	return false
+}
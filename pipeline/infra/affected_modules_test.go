@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testTargetDirs are the modules, examples and test targets of a repository with two modules,
+// where "network" is called by "cluster".
+var testTargetDirs = []string{
+	"modules/network",
+	"modules/cluster",
+	"examples/network/basic",
+	"examples/cluster/basic",
+	"tests/modules/network/target/basic",
+	"tests/modules/cluster/target/basic",
+	"tests/modules/cluster/target/ha",
+}
+
+// TestGetChangedTargetDirs verifies that changed files are mapped to the target that holds them,
+// and that a change to the tests of a module affects all of its test targets.
+func TestGetChangedTargetDirs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		changedFiles []string
+		want         []string
+	}{
+		{
+			name:         "module file",
+			changedFiles: []string{"modules/network/main.tf", "modules/network/variables.tf"},
+			want:         []string{"modules/network"},
+		},
+		{
+			name:         "example and test target",
+			changedFiles: []string{"examples/cluster/basic/main.tf", "tests/modules/cluster/target/ha/main.tf"},
+			want:         []string{"examples/cluster/basic", "tests/modules/cluster/target/ha"},
+		},
+		{
+			name:         "unit test of a module",
+			changedFiles: []string{"tests/modules/cluster/unit/basic_test.go"},
+			want:         []string{"tests/modules/cluster/target/basic", "tests/modules/cluster/target/ha"},
+		},
+		{
+			name:         "prefix of another module",
+			changedFiles: []string{"modules/network-legacy/main.tf"},
+		},
+		{
+			name:         "unrelated files",
+			changedFiles: []string{"README.md", ".github/workflows/ci.yml", "tests/pkg/helper/helper.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := getChangedTargetDirs(tt.changedFiles, testTargetDirs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getChangedTargetDirs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetAffectedTargetDirs verifies that the callers of a changed directory are affected,
+// transitively.
+func TestGetAffectedTargetDirs(t *testing.T) {
+	t.Parallel()
+
+	targets := []*terraformVersionsTarget{
+		{dir: "modules/network"},
+		{dir: "modules/cluster", calls: []string{"modules/network"}},
+		{dir: "examples/network/basic", calls: []string{"modules/network"}},
+		{dir: "examples/cluster/basic", calls: []string{"modules/cluster"}},
+		{dir: "tests/modules/cluster/target/basic", calls: []string{"modules/cluster"}},
+	}
+
+	tests := []struct {
+		name    string
+		changed []string
+		want    []string
+	}{
+		{
+			name:    "called module",
+			changed: []string{"modules/network"},
+			want: []string{
+				"examples/cluster/basic",
+				"examples/network/basic",
+				"modules/cluster",
+				"modules/network",
+				"tests/modules/cluster/target/basic",
+			},
+		},
+		{
+			name:    "calling module",
+			changed: []string{"modules/cluster"},
+			want:    []string{"examples/cluster/basic", "modules/cluster", "tests/modules/cluster/target/basic"},
+		},
+		{
+			name:    "example only",
+			changed: []string{"examples/network/basic"},
+			want:    []string{"examples/network/basic"},
+		},
+		{
+			name:    "nothing changed",
+			changed: nil,
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := getAffectedTargetDirs(tt.changed, targets); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getAffectedTargetDirs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestIsFindingAffected verifies that findings are matched on whole directory paths.
+func TestIsFindingAffected(t *testing.T) {
+	t.Parallel()
+
+	dirs := []string{"modules/network", "examples/network/basic"}
+
+	tests := []struct {
+		finding string
+		want    bool
+	}{
+		{finding: `modules/network: aws: ">= 9.0, < 1.0" allows no version`, want: true},
+		{finding: `provider "aws" has conflicting sources: hashicorp/aws (modules/network, modules/cluster)`, want: true},
+		{finding: "the aws constraints of modules/cluster, modules/network cannot be satisfied together", want: true},
+		{finding: `examples/network/basic: aws: "~> 5.1" is narrower than "~> 5.0" of modules/network`, want: true},
+		{finding: `modules/network-legacy: aws: ">= 9.0, < 1.0" allows no version`, want: false},
+		{finding: `modules/cluster: terraform: ">= 1.5" differs from the policy ">= 1.6"`, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isFindingAffected(tt.finding, dirs); got != tt.want {
+			t.Errorf("isFindingAffected(%q) = %v, want %v", tt.finding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// configAWSWebIdentityTokenDir is the directory the OIDC token is mounted in, as a secret file.
+	configAWSWebIdentityTokenDir = "/run/secrets/aws"
+	// defaultAWSRoleSessionNamePrefix prefixes the generated role session names.
+	defaultAWSRoleSessionNamePrefix = "infra-pipeline"
+	// Bounds of the AWS role session duration
+	minAWSSessionDurationSeconds = 900
+	maxAWSSessionDurationSeconds = 43200
+	// maxAWSChainedSessionDurationSeconds is the maximum duration of a session obtained by role chaining.
+	maxAWSChainedSessionDurationSeconds = 3600
+	// STS client and stand-in server
+	stsClientName      = "stsclient"
+	stsStandInName     = "stsstandin"
+	stsStandInHostname = "sts"
+	stsStandInPort     = 8080
+	// configSTSCredentialsPath is where the STS client writes the temporary credentials.
+	configSTSCredentialsPath = "/run/sts/credentials.json"
+)
+
+//go:embed services/stsclient/main.go
+var stsClientSource string
+
+//go:embed services/stsstandin/main.go
+var stsStandInSource string
+
+var (
+	awsRoleARNPattern        = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+	awsRoleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+)
+
+// AWSWebIdentity is the web identity (OIDC) configuration set by WithAWSOIDC.
+type AWSWebIdentity struct {
+	// RoleARN is the role assumed with the OIDC token.
+	RoleARN string
+	// ChainedRoleARN is the role assumed with the credentials of RoleARN, if any.
+	ChainedRoleARN string
+	// SessionName is the role session name.
+	SessionName string
+	// DurationSeconds is the duration of the final session. 0 uses the STS default.
+	DurationSeconds int
+	// SessionTags are the session tags of the chained session, as "key=value".
+	SessionTags []string
+	// Region is the AWS region.
+	Region string
+	// STSEndpoint is the STS endpoint the token is exchanged with.
+	STSEndpoint string
+	// TokenPath is the absolute path of the mounted OIDC token.
+	TokenPath string
+}
+
+// usesFullFlow reports whether the configuration needs features the SDKs don't support through
+// the web identity environment variables: role chaining, session duration or session tags.
+func (w *AWSWebIdentity) usesFullFlow() bool {
+	return w.ChainedRoleARN != "" || w.DurationSeconds > 0 || len(w.SessionTags) > 0
+}
+
+// validate checks the configuration before any request is sent to STS.
+func (w *AWSWebIdentity) validate() error {
+	if !awsRoleARNPattern.MatchString(w.RoleARN) {
+		return Errorf("invalid role ARN %q, expected 'arn:aws:iam::<account-id>:role/<name>'", w.RoleARN)
+	}
+
+	if w.ChainedRoleARN != "" && !awsRoleARNPattern.MatchString(w.ChainedRoleARN) {
+		return Errorf("invalid chained role ARN %q, expected 'arn:aws:iam::<account-id>:role/<name>'", w.ChainedRoleARN)
+	}
+
+	if !awsRoleSessionNameRegexp.MatchString(w.SessionName) {
+		return Errorf("invalid role session name %q, expected 2 to 64 characters among [A-Za-z0-9_+=,.@-]", w.SessionName)
+	}
+
+	if w.DurationSeconds != 0 && (w.DurationSeconds < minAWSSessionDurationSeconds || w.DurationSeconds > maxAWSSessionDurationSeconds) {
+		return Errorf("session duration must be between %d and %d seconds, got %d",
+			minAWSSessionDurationSeconds, maxAWSSessionDurationSeconds, w.DurationSeconds)
+	}
+
+	// STS rejects longer sessions for role chaining.
+	if w.ChainedRoleARN != "" && w.DurationSeconds > maxAWSChainedSessionDurationSeconds {
+		return Errorf("session duration must be at most %d seconds with a chained role, got %d",
+			maxAWSChainedSessionDurationSeconds, w.DurationSeconds)
+	}
+
+	if len(w.SessionTags) > 0 && w.ChainedRoleARN == "" {
+		return Errorf("session tags require a chained role, AssumeRoleWithWebIdentity takes its tags from the token")
+	}
+
+	for _, tag := range w.SessionTags {
+		if key, _, found := strings.Cut(tag, "="); !found || key == "" || strings.Contains(tag, ",") {
+			return Errorf("invalid session tag %q, expected 'key=value'", tag)
+		}
+	}
+
+	return nil
+}
+
+// getSTSEndpoint returns the regional STS endpoint, unless an endpoint was configured.
+func getSTSEndpoint(stsEndpoint, region string) string {
+	if stsEndpoint != "" {
+		return strings.TrimSuffix(stsEndpoint, "/")
+	}
+
+	return fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+}
+
+// WithAWSOIDC sets the AWS OIDC (web identity) credentials in the container.
+//
+// The OIDC token is mounted as a secret file under /run/secrets/aws, and referenced by
+// AWS_WEB_IDENTITY_TOKEN_FILE together with AWS_ROLE_ARN and AWS_ROLE_SESSION_NAME, so the
+// SDKs exchange it themselves. Static AWS keys are removed.
+//
+// Role chaining, session duration and session tags are not supported by the SDKs through these
+// variables. When one of them is requested, the token is exchanged up front (AssumeRoleWithWebIdentity,
+// then AssumeRole on the chained role) and the resulting temporary credentials are injected as
+// secrets instead. This exchange also acts as the pre-flight check.
+//
+// Parameters:
+//   - ctx: The context for the Dagger container
+//   - roleARN: The ARN of the IAM role to assume with the OIDC token
+//   - oidcToken: The OIDC JWT token (e.g. from GitLab or GitHub Actions)
+//   - oidcTokenName: The file name of the mounted token (optional, defaults to AWS_OIDC_TOKEN)
+//   - awsRegion: The AWS region (optional)
+//   - awsRoleSessionName: The role session name (optional, defaults to a generated one)
+//   - chainedRoleArn: A second role assumed with the credentials of the first one (optional)
+//   - sessionDurationSeconds: The duration of the final session, in seconds (optional)
+//   - sessionTags: The session tags of the chained session, as "key=value" (optional)
+//   - stsEndpoint: The STS endpoint (optional, defaults to the regional endpoint)
+//   - stsService: An STS service bound as "sts", e.g. STSStandInService (optional)
+//   - preflight: Whether to check the token against STS before any Terraform command runs
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the AWS credentials set
+//   - error: An error if the configuration is invalid, or if the pre-flight check fails
+func (m *Infra) WithAWSOIDC(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+	// roleARN is the ARN of the IAM role to assume.
+	roleARN string,
+	// oidcToken is the Dagger Secret containing the OIDC JWT token from GitLab.
+	oidcToken *dagger.Secret,
+	// oidcTokenName is the file name of the mounted OIDC token.
+	// +optional
+	oidcTokenName string,
+	// awsRegion is the AWS region.
+	// +optional
+	awsRegion string,
+	// awsRoleSessionName is an optional name for the assumed role session.
+	// +optional
+	awsRoleSessionName string,
+	// chainedRoleArn is a second role assumed with the credentials of the first one.
+	// +optional
+	chainedRoleArn string,
+	// sessionDurationSeconds is the duration of the final session, in seconds.
+	// +optional
+	sessionDurationSeconds int,
+	// sessionTags are the session tags of the chained session, as "key=value".
+	// +optional
+	sessionTags []string,
+	// stsEndpoint is the STS endpoint, e.g. "http://sts:8080" for the stand-in.
+	// +optional
+	stsEndpoint string,
+	// stsService is an STS service bound to the containers as "sts".
+	// +optional
+	stsService *dagger.Service,
+	// preflight runs a pre-flight check of the token against STS.
+	// +optional
+	preflight bool,
+) (*Infra, error) {
+	if oidcToken == nil {
+		return nil, Errorf("aws OIDC token must not be empty")
+	}
+
+	if oidcTokenName == "" {
+		oidcTokenName = defaultAWSOidcTokenSecretName
+	}
+
+	if strings.ContainsAny(oidcTokenName, "/ ") {
+		return nil, Errorf("invalid OIDC token name %q, expected a file name", oidcTokenName)
+	}
+
+	if awsRoleSessionName == "" {
+		awsRoleSessionName = fmt.Sprintf("%s-%s", defaultAWSRoleSessionNamePrefix, uuid.New().String())
+	}
+
+	if stsService != nil {
+		if stsEndpoint == "" {
+			stsEndpoint = fmt.Sprintf("http://%s:%d", stsStandInHostname, stsStandInPort)
+		}
+
+		m.Ctr = m.Ctr.WithServiceBinding(stsStandInHostname, stsService)
+	}
+
+	awsRegion = getDefaultAWSRegionIfNotSet(awsRegion)
+
+	webIdentity := &AWSWebIdentity{
+		RoleARN:         roleARN,
+		ChainedRoleARN:  chainedRoleArn,
+		SessionName:     awsRoleSessionName,
+		DurationSeconds: sessionDurationSeconds,
+		SessionTags:     sessionTags,
+		Region:          awsRegion,
+		STSEndpoint:     getSTSEndpoint(stsEndpoint, awsRegion),
+		TokenPath:       configAWSWebIdentityTokenDir + "/" + oidcTokenName,
+	}
+
+	if err := webIdentity.validate(); err != nil {
+		return nil, WrapErrorf(err, "invalid AWS web identity configuration")
+	}
+
+	m.AWSWebIdentity = webIdentity
+
+	if webIdentity.usesFullFlow() {
+		provider, err := m.exchangeAWSWebIdentity(ctx, oidcToken, stsService)
+		if err != nil {
+			return nil, err
+		}
+
+		return m.applyCredentialProviders(provider)
+	}
+
+	if preflight {
+		if _, err := m.runSTSClient(ctx, oidcToken, stsService, "check"); err != nil {
+			return nil, err
+		}
+	}
+
+	envVars := []credentialEnvVar{
+		{name: "AWS_REGION", value: awsRegion},
+		{name: "AWS_ROLE_ARN", value: roleARN, required: true},
+		{name: "AWS_ROLE_SESSION_NAME", value: awsRoleSessionName, required: true},
+	}
+
+	if stsEndpoint != "" {
+		envVars = append(envVars, credentialEnvVar{name: "AWS_ENDPOINT_URL_STS", value: webIdentity.STSEndpoint})
+	}
+
+	if _, err := m.applyCredentialProviders(&webIdentityCredentialProvider{
+		name:            "aws-web-identity",
+		token:           oidcToken,
+		tokenPath:       webIdentity.TokenPath,
+		tokenPathEnvVar: "AWS_WEB_IDENTITY_TOKEN_FILE",
+		envVars:         envVars,
+		// Static keys would take precedence over the web identity.
+		unset: []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"},
+	}); err != nil {
+		return nil, err
+	}
+
+	// The static keys were removed by the provider.
+	m.forgetCredentialUsage("aws-static-keys")
+
+	return m, nil
+}
+
+// runSTSClient runs the STS client against the configured endpoint, with the OIDC token mounted
+// as a secret file, and returns its container. In "credentials" mode the temporary credentials
+// are written to configSTSCredentialsPath in the container; the client output never holds them.
+// Failures are reported with the message returned by STS.
+func (m *Infra) runSTSClient(ctx context.Context, oidcToken *dagger.Secret, stsService *dagger.Service, mode string) (*dagger.Container, error) {
+	webIdentity := m.AWSWebIdentity
+
+	stsClient := buildGoServiceContainer(stsClientName, stsClientSource).
+		WithMountedSecret(webIdentity.TokenPath, oidcToken, dagger.ContainerWithMountedSecretOpts{
+			Mode: credentialFileMode,
+		}).
+		WithEnvVariable("STS_ENDPOINT", webIdentity.STSEndpoint).
+		WithEnvVariable("AWS_REGION", webIdentity.Region).
+		WithEnvVariable("ROLE_ARN", webIdentity.RoleARN).
+		WithEnvVariable("WEB_IDENTITY_TOKEN_FILE", webIdentity.TokenPath).
+		WithEnvVariable("ROLE_SESSION_NAME", webIdentity.SessionName).
+		WithEnvVariable("CHAINED_ROLE_ARN", webIdentity.ChainedRoleARN).
+		WithEnvVariable("SESSION_TAGS", strings.Join(webIdentity.SessionTags, ",")).
+		WithEnvVariable("CREDENTIALS_FILE", configSTSCredentialsPath).
+		WithExec([]string{"mkdir", "-p", path.Dir(configSTSCredentialsPath)}).
+		// Every exchange must reach STS, the result of a previous one must never be reused.
+		WithEnvVariable("STS_REQUEST_ID", uuid.New().String())
+
+	if webIdentity.DurationSeconds > 0 {
+		stsClient = stsClient.WithEnvVariable("DURATION_SECONDS", strconv.Itoa(webIdentity.DurationSeconds))
+	}
+
+	if stsService != nil {
+		stsClient = stsClient.WithServiceBinding(stsStandInHostname, stsService)
+	}
+
+	stsClient, err := stsClient.
+		WithExec([]string{"/usr/local/bin/" + stsClientName, mode}).
+		Sync(ctx)
+	if err != nil {
+		_, details := getExecFailureDetails(err)
+		if details == "" {
+			details = err.Error()
+		}
+
+		return nil, m.redactError(ctx, Errorf("AWS web identity pre-flight check against %s failed: %s",
+			webIdentity.STSEndpoint, strings.TrimPrefix(details, "sts: ")))
+	}
+
+	return stsClient, nil
+}
+
+// exchangeAWSWebIdentity exchanges the OIDC token for temporary credentials, assuming the chained
+// role if any, and returns a provider that injects them as secrets.
+func (m *Infra) exchangeAWSWebIdentity(ctx context.Context, oidcToken *dagger.Secret, stsService *dagger.Service) (CredentialProvider, error) {
+	stsClient, err := m.runSTSClient(ctx, oidcToken, stsService, "credentials")
+	if err != nil {
+		return nil, err
+	}
+
+	// The credentials are read from the file the client wrote, never from an exec output.
+	contents, err := stsClient.File(configSTSCredentialsPath).Contents(ctx)
+	if err != nil {
+		return nil, WrapError(err, "failed to read the temporary credentials written by the STS client")
+	}
+
+	var session struct {
+		AccessKeyID     string `json:"accessKeyId"`
+		SecretAccessKey string `json:"secretAccessKey"`
+		SessionToken    string `json:"sessionToken"`
+	}
+
+	if err := json.Unmarshal([]byte(contents), &session); err != nil {
+		return nil, Errorf("the STS client returned an invalid response")
+	}
+
+	return &staticKeysCredentialProvider{
+		name: "aws-web-identity-session",
+		envVars: []credentialEnvVar{
+			{name: "AWS_REGION", value: m.AWSWebIdentity.Region},
+			{name: "AWS_ACCESS_KEY_ID", secret: newDigestNamedSecret("aws-access-key-id", session.AccessKeyID), required: true},
+			{name: "AWS_SECRET_ACCESS_KEY", secret: newDigestNamedSecret("aws-secret-access-key", session.SecretAccessKey), required: true},
+			{name: "AWS_SESSION_TOKEN", secret: newDigestNamedSecret("aws-session-token", session.SessionToken), required: true},
+		},
+	}, nil
+}
+
+// STSStandInService returns a local stand-in for the AWS STS API.
+//
+// It supports AssumeRoleWithWebIdentity and AssumeRole (role chaining), validates role ARNs,
+// session names, durations, tags and SigV4 signatures, and accepts only 'expectedToken' as web
+// identity token when it is set. Bind it with WithAWSOIDC's 'stsService' to test the web
+// identity flow without AWS.
+//
+// Parameters:
+//   - expectedToken: The only web identity token accepted (optional, any non-empty token otherwise)
+//
+// Returns:
+//   - *dagger.Service: The stand-in as a Dagger service, listening on port 8080
+func (m *Infra) STSStandInService(
+	// expectedToken is the only web identity token accepted by the stand-in.
+	// +optional
+	expectedToken string,
+) *dagger.Service {
+	return buildGoServiceContainer(stsStandInName, stsStandInSource).
+		WithEnvVariable("STS_EXPECTED_TOKEN", expectedToken).
+		WithEnvVariable("PORT", strconv.Itoa(stsStandInPort)).
+		WithExposedPort(stsStandInPort).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true})
+}
+
+// ActionAWSWebIdentityVerification verifies the AWS web identity flow against the STS stand-in.
+//
+// It checks that an invalid token fails early with the message returned by STS, then exchanges
+// a valid token for a chained session with a duration and tags, and verifies that the temporary
+// credentials are injected into the container.
+func (m *Infra) ActionAWSWebIdentityVerification(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	const (
+		validToken     = "stand-in-oidc-token"
+		roleARN        = "arn:aws:iam::123456789012:role/ci"
+		chainedRoleARN = "arn:aws:iam::210987654321:role/deploy"
+	)
+
+	// The stand-in is started explicitly so the credentials it issues survive across requests.
+	stsService, err := m.STSStandInService(validToken).Start(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to start the STS stand-in")
+	}
+
+	defer func() {
+		_, _ = stsService.Stop(ctx)
+	}()
+
+	// An invalid token must fail early, with the error returned by STS.
+	invalidToken := dag.SetSecret("stand-in-invalid-oidc-token", "not-the-expected-token")
+
+	_, preflightErr := (&Infra{Ctr: m.Ctr}).WithAWSOIDC(ctx, roleARN, invalidToken, "", "", "", "", 0, nil, "", stsService, true)
+	if preflightErr == nil {
+		return "", Errorf("the pre-flight check accepted an invalid token")
+	}
+
+	if !strings.Contains(preflightErr.Error(), "InvalidIdentityToken") {
+		return "", WrapErrorf(preflightErr, "the pre-flight check failed without the STS error")
+	}
+
+	validSecret := dag.SetSecret("stand-in-oidc-token", validToken)
+
+	mWithOIDC, err := m.WithAWSOIDC(ctx, roleARN, validSecret, "", "", "", chainedRoleARN, minAWSSessionDurationSeconds,
+		[]string{"pipeline=infra"}, "", stsService, true)
+	if err != nil {
+		return "", WrapErrorf(err, "the web identity flow failed against the STS stand-in")
+	}
+
+	verification := mWithOIDC.Ctr.
+		WithExec([]string{"sh", "-c",
+			`test -n "$AWS_ACCESS_KEY_ID" && test -n "$AWS_SESSION_TOKEN" && echo "temporary credentials injected for the chained role"`})
+
+	return m.processActionResult(ctx, "aws-web-identity.verification", verification)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestAWSWebIdentityValidate verifies the checks run before any request is sent to STS: role ARNs,
+// session name, session duration bounds, including the shorter maximum of role chaining, and
+// session tags.
+func TestAWSWebIdentityValidate(t *testing.T) {
+	t.Parallel()
+
+	const (
+		roleARN        = "arn:aws:iam::123456789012:role/terraform"
+		chainedRoleARN = "arn:aws:iam::210987654321:role/deployer"
+	)
+
+	tests := []struct {
+		name    string
+		update  func(w *AWSWebIdentity)
+		wantErr string
+	}{
+		{name: "web identity only", update: func(*AWSWebIdentity) {}},
+		{
+			name:   "role with a path in another partition",
+			update: func(w *AWSWebIdentity) { w.RoleARN = "arn:aws-us-gov:iam::123456789012:role/ci/terraform" },
+		},
+		{
+			name:    "invalid role ARN",
+			update:  func(w *AWSWebIdentity) { w.RoleARN = "arn:aws:iam::1234:role/terraform" },
+			wantErr: "invalid role ARN",
+		},
+		{
+			name:    "user ARN",
+			update:  func(w *AWSWebIdentity) { w.RoleARN = "arn:aws:iam::123456789012:user/terraform" },
+			wantErr: "invalid role ARN",
+		},
+		{
+			name:    "invalid chained role ARN",
+			update:  func(w *AWSWebIdentity) { w.ChainedRoleARN = "deployer" },
+			wantErr: "invalid chained role ARN",
+		},
+		{
+			name:    "session name too short",
+			update:  func(w *AWSWebIdentity) { w.SessionName = "x" },
+			wantErr: "invalid role session name",
+		},
+		{
+			name:    "session name too long",
+			update:  func(w *AWSWebIdentity) { w.SessionName = strings.Repeat("x", 65) },
+			wantErr: "invalid role session name",
+		},
+		{
+			name:    "session name with a space",
+			update:  func(w *AWSWebIdentity) { w.SessionName = "infra pipeline" },
+			wantErr: "invalid role session name",
+		},
+		{name: "minimum duration", update: func(w *AWSWebIdentity) { w.DurationSeconds = 900 }},
+		{name: "maximum duration", update: func(w *AWSWebIdentity) { w.DurationSeconds = 43200 }},
+		{
+			name:    "duration too short",
+			update:  func(w *AWSWebIdentity) { w.DurationSeconds = 899 },
+			wantErr: "session duration must be between 900 and 43200 seconds",
+		},
+		{
+			name:    "duration too long",
+			update:  func(w *AWSWebIdentity) { w.DurationSeconds = 43201 },
+			wantErr: "session duration must be between 900 and 43200 seconds",
+		},
+		{
+			name: "maximum chained duration",
+			update: func(w *AWSWebIdentity) {
+				w.ChainedRoleARN = chainedRoleARN
+				w.DurationSeconds = 3600
+			},
+		},
+		{
+			name: "chained duration too long",
+			update: func(w *AWSWebIdentity) {
+				w.ChainedRoleARN = chainedRoleARN
+				w.DurationSeconds = 7200
+			},
+			wantErr: "at most 3600 seconds with a chained role",
+		},
+		{
+			name: "session tags with a chained role",
+			update: func(w *AWSWebIdentity) {
+				w.ChainedRoleARN = chainedRoleARN
+				w.SessionTags = []string{"team=platform", "env="}
+			},
+		},
+		{
+			name:    "session tags without a chained role",
+			update:  func(w *AWSWebIdentity) { w.SessionTags = []string{"team=platform"} },
+			wantErr: "session tags require a chained role",
+		},
+		{
+			name: "session tag without a value separator",
+			update: func(w *AWSWebIdentity) {
+				w.ChainedRoleARN = chainedRoleARN
+				w.SessionTags = []string{"team"}
+			},
+			wantErr: `invalid session tag "team"`,
+		},
+		{
+			name: "session tag without a key",
+			update: func(w *AWSWebIdentity) {
+				w.ChainedRoleARN = chainedRoleARN
+				w.SessionTags = []string{"=platform"}
+			},
+			wantErr: `invalid session tag "=platform"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			webIdentity := &AWSWebIdentity{RoleARN: roleARN, SessionName: "infra-pipeline-1", Region: "eu-west-1"}
+			tt.update(webIdentity)
+
+			err := webIdentity.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() error = %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
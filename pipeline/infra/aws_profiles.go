@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"dagger/infra/internal/dagger"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// Paths of the AWS shared config and credentials files, mounted as secrets
+	configAWSConfigFilePath            = "/run/secrets/aws/config"
+	configAWSSharedCredentialsFilePath = "/run/secrets/aws/credentials"
+	// defaultAWSProfileName is the profile used by the SDKs when AWS_PROFILE is not set.
+	defaultAWSProfileName = "default"
+)
+
+// awsAccountIDPattern extracts the account ID from an IAM role ARN.
+var awsAccountIDPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}):`)
+
+// AWSProfile is a named profile found in the AWS shared config or credentials file.
+type AWSProfile struct {
+	// Name is the profile name.
+	Name string
+	// AccountID is the account the profile authenticates to, when the files tell it
+	// (role_arn, sso_account_id or aws_account_id). It is empty otherwise.
+	AccountID string
+	// Region is the default region of the profile, if any.
+	Region string
+}
+
+// parseAWSProfileFile parses the sections of an AWS shared config or credentials file. In the
+// config file, named profiles are declared as "[profile <name>]"; in the credentials file, as
+// "[<name>]". It returns the keys of every profile, by profile name.
+func parseAWSProfileFile(contents string, isConfigFile bool) map[string]map[string]string {
+	profiles := map[string]map[string]string{}
+
+	var current map[string]string
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+
+			if isConfigFile && name != defaultAWSProfileName {
+				if !strings.HasPrefix(name, "profile ") {
+					// Other sections ('sso-session', 'services') are not profiles.
+					current = nil
+
+					continue
+				}
+
+				name = strings.TrimSpace(strings.TrimPrefix(name, "profile "))
+			}
+
+			if profiles[name] == nil {
+				profiles[name] = map[string]string{}
+			}
+
+			current = profiles[name]
+
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if found && current != nil {
+			current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return profiles
+}
+
+// getAWSProfiles merges the profiles of the shared config and credentials files, and resolves
+// their account ID and default region.
+func getAWSProfiles(configContents, credentialsContents string) []*AWSProfile {
+	merged := parseAWSProfileFile(configContents, true)
+
+	for name, keys := range parseAWSProfileFile(credentialsContents, false) {
+		if merged[name] == nil {
+			merged[name] = map[string]string{}
+		}
+
+		for key, value := range keys {
+			if _, exists := merged[name][key]; !exists {
+				merged[name][key] = value
+			}
+		}
+	}
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	profiles := make([]*AWSProfile, 0, len(names))
+
+	for _, name := range names {
+		keys := merged[name]
+		profile := &AWSProfile{Name: name, Region: keys["region"]}
+
+		switch {
+		case awsAccountIDPattern.MatchString(keys["role_arn"]):
+			profile.AccountID = awsAccountIDPattern.FindStringSubmatch(keys["role_arn"])[1]
+		case keys["sso_account_id"] != "":
+			profile.AccountID = keys["sso_account_id"]
+		case keys["aws_account_id"] != "":
+			profile.AccountID = keys["aws_account_id"]
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles
+}
+
+// getAWSProfile returns a profile found in the shared config, or nil.
+func (m *Infra) getAWSProfile(name string) *AWSProfile {
+	for _, profile := range m.AWSProfiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+
+	return nil
+}
+
+// WithAWSSharedConfig mounts an AWS shared config and/or credentials file, with named profiles.
+//
+// Both files are mounted as secrets and referenced by AWS_CONFIG_FILE and
+// AWS_SHARED_CREDENTIALS_FILE. Their profiles are listed so actions can select one, and so
+// ActionTerraformPlanMatrix can key its results by account. Static AWS keys are removed, as they
+// would take precedence over the profiles.
+//
+// Parameters:
+//   - ctx: The context for the Dagger container
+//   - configFile: The AWS shared config file (optional)
+//   - credentialsFile: The AWS shared credentials file (optional)
+//   - profile: The profile selected by default (optional, defaults to the "default" profile)
+//   - awsRegion: The AWS region (optional, defaults to the region of the profile)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the shared config mounted
+//   - error: An error if no file is given, a file cannot be read, or the profile does not exist
+func (m *Infra) WithAWSSharedConfig(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+	// configFile is the AWS shared config file, e.g. file:~/.aws/config.
+	// +optional
+	configFile *dagger.Secret,
+	// credentialsFile is the AWS shared credentials file, e.g. file:~/.aws/credentials.
+	// +optional
+	credentialsFile *dagger.Secret,
+	// profile is the profile selected by default.
+	// +optional
+	profile string,
+	// awsRegion is the AWS region.
+	// +optional
+	awsRegion string,
+) (*Infra, error) {
+	if configFile == nil && credentialsFile == nil {
+		return nil, Errorf("an AWS shared config file or credentials file is required")
+	}
+
+	provider := &configFileCredentialProvider{
+		name:  "aws-shared-config",
+		unset: []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"},
+	}
+
+	var configContents, credentialsContents string
+
+	if configFile != nil {
+		contents, err := configFile.Plaintext(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the AWS shared config file")
+		}
+
+		configContents = contents
+		provider.files = append(provider.files,
+			credentialFile{path: configAWSConfigFilePath, secret: configFile, envVar: "AWS_CONFIG_FILE"})
+	}
+
+	if credentialsFile != nil {
+		contents, err := credentialsFile.Plaintext(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the AWS shared credentials file")
+		}
+
+		credentialsContents = contents
+		provider.files = append(provider.files,
+			credentialFile{path: configAWSSharedCredentialsFilePath, secret: credentialsFile, envVar: "AWS_SHARED_CREDENTIALS_FILE"})
+	}
+
+	m.AWSProfiles = getAWSProfiles(configContents, credentialsContents)
+
+	if len(m.AWSProfiles) == 0 {
+		return nil, Errorf("no profile found in the AWS shared config and credentials files")
+	}
+
+	if _, err := m.applyCredentialProviders(provider); err != nil {
+		return nil, err
+	}
+
+	// The static keys were removed by the provider.
+	m.forgetCredentialUsage("aws-static-keys")
+
+	if profile == "" {
+		profile = defaultAWSProfileName
+
+		if m.getAWSProfile(profile) == nil {
+			return m, nil
+		}
+	}
+
+	return m.WithAWSProfile(profile, awsRegion)
+}
+
+// WithAWSProfile selects a profile of the shared config mounted with WithAWSSharedConfig.
+//
+// Parameters:
+//   - profile: The profile name
+//   - awsRegion: The AWS region (optional, defaults to the region of the profile)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with AWS_PROFILE set
+//   - error: An error if no shared config is mounted, the profile does not exist, or static AWS keys are set
+func (m *Infra) WithAWSProfile(
+	// profile is the profile name.
+	profile string,
+	// awsRegion is the AWS region.
+	// +optional
+	awsRegion string,
+) (*Infra, error) {
+	if m.isCredentialInUse("aws-static-keys") {
+		return nil, Errorf("an AWS profile and static AWS keys cannot be used together")
+	}
+
+	awsProfile := m.getAWSProfile(profile)
+	if awsProfile == nil {
+		return nil, Errorf("AWS profile %q not found, available profiles: %s", profile, strings.Join(m.getAWSProfileNames(), ", "))
+	}
+
+	if awsRegion == "" {
+		awsRegion = awsProfile.Region
+	}
+
+	m.Ctr = m.Ctr.
+		WithEnvVariable("AWS_PROFILE", profile).
+		WithEnvVariable("AWS_REGION", getDefaultAWSRegionIfNotSet(awsRegion))
+
+	return m, nil
+}
+
+// getAWSProfileNames returns the names of the profiles found in the shared config.
+func (m *Infra) getAWSProfileNames() []string {
+	names := make([]string, 0, len(m.AWSProfiles))
+	for _, profile := range m.AWSProfiles {
+		names = append(names, profile.Name)
+	}
+
+	return names
+}
+
+// getAWSMatrixKey returns the key of a (profile, region) pair in the plan matrix: the account ID
+// when the shared config tells it, the profile name otherwise.
+func getAWSMatrixKey(profile *AWSProfile, region string) string {
+	account := profile.AccountID
+	if account == "" {
+		account = "profile-" + profile.Name
+	}
+
+	return account + "/" + region
+}
+
+// ActionTerraformPlanMatrix runs 'terraform plan' on a module for every (profile, region) pair,
+// concurrently, using the shared config mounted with WithAWSSharedConfig.
+//
+// Results are keyed by account and region ("<module>@<account>/<region>.plan"). The account is
+// resolved from the profile (role_arn, sso_account_id or aws_account_id), or named after the
+// profile when the files don't tell it. A pair planning the same account and region as an earlier
+// one (e.g. two profiles of the same account) is reported as skipped. After WithAffectedModules,
+// an unaffected module is reported as skipped.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - tfModulePath: The path to the Terraform module
+//   - profiles: The profiles to plan with (optional, defaults to every profile)
+//   - regions: The regions to plan in (optional, defaults to the region of each profile)
+//   - loadDotEnvFile: Whether to source .env files from the local directory
+//   - example: The example whose .env files are loaded last (optional)
+//   - envVars: Environment variables to set in the container
+//
+// Returns:
+//   - string: The report of every plan, keyed by account and region
+//   - error: The errors of the failed plans, keyed by account and region
+func (m *Infra) ActionTerraformPlanMatrix(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// tfModulePath is the path to the Terraform modules.
+	tfModulePath string,
+	// profiles are the AWS profiles to plan with. Defaults to every profile of the shared config.
+	// +optional
+	profiles []string,
+	// regions are the AWS regions to plan in. Defaults to the region of each profile.
+	// +optional
+	regions []string,
+	// loadDotEnvFile is a flag to enable source .env files from the local directory.
+	// +optional
+	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
+	// envVars are the environment variables to set in the container.
+	// +optional
+	envVars []string,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	if !m.isModuleAffected(tfModulePath) {
+		return getNotAffectedReport(tfModulePath, "plan-matrix")
+	}
+
+	if len(m.AWSProfiles) == 0 {
+		return "", Errorf("no AWS profile available, use WithAWSSharedConfig first")
+	}
+
+	if len(profiles) == 0 {
+		profiles = m.getAWSProfileNames()
+	}
+
+	baseContainer, err := m.JobTerraform(
+		ctx,
+		tfModulePath,
+		nil,
+		nil,
+		nil,
+		loadDotEnvFile,
+		example,
+		false,
+		envVars,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		nil,
+		"",
+		"",
+	)
+	if err != nil {
+		return "", m.redactError(ctx, WrapErrorf(err, "failed to create base Terraform container"))
+	}
+
+	type matrixEntry struct {
+		profile string
+		region  string
+		key     string
+	}
+
+	var entries []matrixEntry
+
+	// Pairs planning the same account and region as an earlier one are reported as skipped.
+	var skipped []JobResult
+
+	seen := map[string]string{}
+
+	for _, profileName := range profiles {
+		profile := m.getAWSProfile(profileName)
+		if profile == nil {
+			return "", Errorf("AWS profile %q not found, available profiles: %s", profileName, strings.Join(m.getAWSProfileNames(), ", "))
+		}
+
+		profileRegions := regions
+		if len(profileRegions) == 0 {
+			profileRegions = []string{getDefaultAWSRegionIfNotSet(profile.Region)}
+		}
+
+		for _, region := range profileRegions {
+			key := getAWSMatrixKey(profile, region)
+			if plannedBy, ok := seen[key]; ok {
+				// Two profiles of the same account plan the same thing.
+				skipped = append(skipped, JobResult{
+					WorkDir: fmt.Sprintf("%s@%s.plan (profile %s)", tfModulePath, key, profile.Name),
+					Output: fmt.Sprintf("skipped: profile %q in %s plans the same account and region as profile %q\n",
+						profile.Name, region, plannedBy),
+				})
+
+				continue
+			}
+
+			seen[key] = profile.Name
+			entries = append(entries, matrixEntry{profile: profile.Name, region: region, key: key})
+		}
+	}
+
+	// Plans use the module's own backend, or the one configured with WithBackend.
+	initCmd := DaggerCMD{"terraform", "init", "-input=false"}
+	if m.Backend != nil {
+		baseContainer = m.withBackendOverride(baseContainer)
+		initCmd = m.getTerraformInitCmd(true)
+	}
+
+	commands := [][]string{
+		initCmd,
+		{"terraform", "plan", "-input=false", "-lock=false", "-no-color"},
+	}
+
+	resultChan := make(chan JobResult, len(entries))
+
+	for _, entry := range entries {
+		matrixContainer := baseContainer.
+			WithEnvVariable("AWS_PROFILE", entry.profile).
+			WithEnvVariable("AWS_REGION", entry.region)
+
+		go m.executeDaggerCtrAsync(ctx, resultChan, matrixContainer,
+			fmt.Sprintf("%s@%s.plan", tfModulePath, entry.key), commands)
+	}
+
+	results := make([]JobResult, 0, len(entries)+len(skipped))
+	for range entries {
+		results = append(results, <-resultChan)
+	}
+
+	results = append(results, skipped...)
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].WorkDir < results[j].WorkDir
+	})
+
+	report, err := ProcessActionSyncResults(results)
+
+	return m.redact(ctx, report), m.redactError(ctx, err)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"dagger/infra/internal/dagger"
+)
+
+const (
+	// Paths of the Azure credentials mounted as secret files
+	configAzureClientCertificatePath = "/run/secrets/azure/client-certificate.pfx"
+	configAzureOIDCTokenPath         = "/run/secrets/azure/oidc-token"
+)
+
+// azureCredentialProviderNames are the names of the Azure credential providers, of which only one
+// can be applied.
+var azureCredentialProviderNames = []string{"azure-client-secret", "azure-client-certificate", "azure-oidc"}
+
+// applyAzureCredentialProvider applies an Azure credential provider, unless another Azure
+// authentication method is already configured: the azurerm provider would silently pick one of them.
+func (m *Infra) applyAzureCredentialProvider(provider CredentialProvider) (*Infra, error) {
+	for _, name := range azureCredentialProviderNames {
+		if name != provider.Name() && m.isCredentialInUse(name) {
+			return nil, Errorf("Azure credentials are already configured with %s, use only one of "+
+				"WithAzureServicePrincipal, WithAzureClientCertificate and WithAzureOIDC", name)
+		}
+	}
+
+	return m.applyCredentialProviders(provider)
+}
+
+// newAzureCredentialProvider returns the Azure credential provider selected by the Azure inputs
+// of JobTerraform: a client secret, a client certificate or an OIDC token. It returns nil when none
+// is given, and an error when more than one is.
+func newAzureCredentialProvider(
+	clientID, tenantID, subscriptionID string,
+	clientSecret, certificate, certificatePassword, oidcToken *dagger.Secret,
+) (CredentialProvider, error) {
+	var providers []CredentialProvider
+
+	if clientSecret != nil {
+		providers = append(providers, newAzureServicePrincipalProvider(clientID, tenantID, subscriptionID, clientSecret))
+	}
+
+	if certificate != nil {
+		providers = append(providers,
+			newAzureClientCertificateProvider(clientID, tenantID, subscriptionID, certificate, certificatePassword))
+	}
+
+	if oidcToken != nil {
+		providers = append(providers, newAzureOIDCProvider(clientID, tenantID, subscriptionID, oidcToken))
+	}
+
+	switch len(providers) {
+	case 0:
+		if clientID != "" || tenantID != "" {
+			return nil, Errorf("an Azure client secret, client certificate or OIDC token is required with the Azure client and tenant IDs")
+		}
+
+		return nil, nil
+	case 1:
+		return providers[0], nil
+	default:
+		return nil, Errorf("use only one of the Azure client secret, client certificate and OIDC token")
+	}
+}
+
+// getAzureIdentityEnvVars returns the identity shared by every Azure authentication method: the
+// client (application) ID, the tenant ID and, optionally, the subscription ID.
+func getAzureIdentityEnvVars(clientID, tenantID, subscriptionID string) []credentialEnvVar {
+	return []credentialEnvVar{
+		{name: "ARM_CLIENT_ID", value: clientID, required: true},
+		{name: "ARM_TENANT_ID", value: tenantID, required: true},
+		{name: "ARM_SUBSCRIPTION_ID", value: subscriptionID},
+	}
+}
+
+// WithAzureServicePrincipal authenticates the azurerm provider with a service principal and a
+// client secret.
+//
+// It sets ARM_CLIENT_ID, ARM_TENANT_ID and ARM_SUBSCRIPTION_ID, and ARM_CLIENT_SECRET as a
+// secret variable.
+//
+// Parameters:
+//   - clientID: The client (application) ID of the service principal
+//   - tenantID: The Azure AD tenant ID
+//   - subscriptionID: The Azure subscription ID (optional)
+//   - clientSecret: The client secret of the service principal
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the Azure credentials set
+//   - error: An error if a required value is missing, or another Azure method is configured
+func (m *Infra) WithAzureServicePrincipal(
+	// clientID is the client (application) ID of the service principal.
+	clientID string,
+	// tenantID is the Azure AD tenant ID.
+	tenantID string,
+	// subscriptionID is the Azure subscription ID.
+	// +optional
+	subscriptionID string,
+	// clientSecret is the client secret of the service principal.
+	clientSecret *dagger.Secret,
+) (*Infra, error) {
+	return m.applyAzureCredentialProvider(newAzureServicePrincipalProvider(clientID, tenantID, subscriptionID, clientSecret))
+}
+
+// newAzureServicePrincipalProvider returns the provider behind WithAzureServicePrincipal.
+func newAzureServicePrincipalProvider(clientID, tenantID, subscriptionID string, clientSecret *dagger.Secret) CredentialProvider {
+	return &staticKeysCredentialProvider{
+		name: "azure-client-secret",
+		envVars: append(getAzureIdentityEnvVars(clientID, tenantID, subscriptionID),
+			credentialEnvVar{name: "ARM_CLIENT_SECRET", secret: clientSecret, required: true}),
+	}
+}
+
+// WithAzureClientCertificate authenticates the azurerm provider with a service principal and a
+// client certificate.
+//
+// The certificate (PKCS#12, e.g. loaded with 'file:./cert.pfx') is mounted as a secret file and
+// referenced by ARM_CLIENT_CERTIFICATE_PATH, so it never enters the layer cache.
+//
+// Parameters:
+//   - clientID: The client (application) ID of the service principal
+//   - tenantID: The Azure AD tenant ID
+//   - subscriptionID: The Azure subscription ID (optional)
+//   - certificate: The client certificate, in PKCS#12 format
+//   - certificatePassword: The password of the client certificate (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the Azure credentials set
+//   - error: An error if a required value is missing, or another Azure method is configured
+func (m *Infra) WithAzureClientCertificate(
+	// clientID is the client (application) ID of the service principal.
+	clientID string,
+	// tenantID is the Azure AD tenant ID.
+	tenantID string,
+	// subscriptionID is the Azure subscription ID.
+	// +optional
+	subscriptionID string,
+	// certificate is the client certificate, in PKCS#12 format.
+	certificate *dagger.Secret,
+	// certificatePassword is the password of the client certificate.
+	// +optional
+	certificatePassword *dagger.Secret,
+) (*Infra, error) {
+	return m.applyAzureCredentialProvider(
+		newAzureClientCertificateProvider(clientID, tenantID, subscriptionID, certificate, certificatePassword))
+}
+
+// newAzureClientCertificateProvider returns the provider behind WithAzureClientCertificate.
+func newAzureClientCertificateProvider(
+	clientID, tenantID, subscriptionID string,
+	certificate, certificatePassword *dagger.Secret,
+) CredentialProvider {
+	return &configFileCredentialProvider{
+		name: "azure-client-certificate",
+		files: []credentialFile{
+			{path: configAzureClientCertificatePath, secret: certificate, envVar: "ARM_CLIENT_CERTIFICATE_PATH"},
+		},
+		envVars: append(getAzureIdentityEnvVars(clientID, tenantID, subscriptionID),
+			credentialEnvVar{name: "ARM_CLIENT_CERTIFICATE_PASSWORD", secret: certificatePassword}),
+	}
+}
+
+// WithAzureOIDC authenticates the azurerm provider with workload identity federation (OIDC).
+//
+// The OIDC token (e.g. issued by GitHub Actions) is mounted as a secret file and referenced by
+// ARM_OIDC_TOKEN_FILE_PATH, and ARM_USE_OIDC is enabled.
+//
+// Parameters:
+//   - clientID: The client (application) ID of the federated identity
+//   - tenantID: The Azure AD tenant ID
+//   - subscriptionID: The Azure subscription ID (optional)
+//   - oidcToken: The OIDC token exchanged for an Azure access token
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the Azure credentials set
+//   - error: An error if a required value is missing, or another Azure method is configured
+func (m *Infra) WithAzureOIDC(
+	// clientID is the client (application) ID of the federated identity.
+	clientID string,
+	// tenantID is the Azure AD tenant ID.
+	tenantID string,
+	// subscriptionID is the Azure subscription ID.
+	// +optional
+	subscriptionID string,
+	// oidcToken is the OIDC token exchanged for an Azure access token.
+	oidcToken *dagger.Secret,
+) (*Infra, error) {
+	return m.applyAzureCredentialProvider(newAzureOIDCProvider(clientID, tenantID, subscriptionID, oidcToken))
+}
+
+// newAzureOIDCProvider returns the provider behind WithAzureOIDC.
+func newAzureOIDCProvider(clientID, tenantID, subscriptionID string, oidcToken *dagger.Secret) CredentialProvider {
+	return &webIdentityCredentialProvider{
+		name:            "azure-oidc",
+		token:           oidcToken,
+		tokenPath:       configAzureOIDCTokenPath,
+		tokenPathEnvVar: "ARM_OIDC_TOKEN_FILE_PATH",
+		envVars: append(getAzureIdentityEnvVars(clientID, tenantID, subscriptionID),
+			credentialEnvVar{name: "ARM_USE_OIDC", value: "true"}),
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// configBackendConfigPath is where the backend configuration files are mounted.
+	configBackendConfigPath = "/run/secrets/backend"
+	// backendOverrideFileName is the override file declaring the backend type. Terraform merges
+	// '*_override.tf' files last, so its backend block replaces the module's one.
+	backendOverrideFileName = "pipeline_backend_override.tf"
+	// MinIO stand-in for S3 backends
+	minioImage             = "minio/minio:latest"
+	minioClientImage       = "minio/mc:latest"
+	minioHostname          = "minio"
+	minioPort              = 9000
+	minioVerificationUser  = "pipeline"
+	minioVerificationKey   = "pipeline-verification"
+	backendStateBucketName = "tfstate"
+)
+
+// backendTypePattern matches valid Terraform backend type names.
+var backendTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// TerraformBackend is the backend configuration used by the actions that run against remote state.
+type TerraformBackend struct {
+	// Type is the backend type. When set, a backend block of this type is generated in an override
+	// file, so modules without a backend block can use it.
+	Type string
+	// Config are the partial configuration values, as "key=value", passed with -backend-config.
+	Config []string
+	// ConfigFiles are the paths of the mounted backend configuration files, passed with -backend-config.
+	ConfigFiles []string
+}
+
+// renderBackendConfigFile renders backend configuration values as an HCL backend config file.
+// Values are quoted, with template sequences escaped.
+func renderBackendConfigFile(keys, values []string) string {
+	var builder strings.Builder
+
+	for i, key := range keys {
+		value := strconv.Quote(values[i])
+		value = strings.ReplaceAll(value, "${", "$${")
+		value = strings.ReplaceAll(value, "%{", "%%{")
+
+		builder.WriteString(fmt.Sprintf("%s = %s\n", key, value))
+	}
+
+	return builder.String()
+}
+
+// WithBackend configures the backend used by the actions that run against remote state.
+//
+// The configuration is passed to 'terraform init' as partial configuration: key=value pairs,
+// plain files, secret files, and secret key/value pairs (rendered into a file mounted as a secret).
+// Calls are cumulative, so values can be split across several calls.
+//
+// Parameters:
+//   - ctx: The context for the Dagger operations
+//   - backendType: The backend type, e.g. "s3" or "http" (optional, for modules without a backend block)
+//   - config: Partial configuration values, as "key=value" (optional)
+//   - configFiles: Plain backend configuration files (optional)
+//   - secretConfigFiles: Backend configuration files holding secrets (optional)
+//   - secretConfigKeys: Keys of secret configuration values, e.g. "password" (optional)
+//   - secretConfigValues: Secret configuration values, in the same order as secretConfigKeys (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the backend configured
+//   - error: An error if the backend type, a value or a key is invalid
+func (m *Infra) WithBackend(
+	// ctx is the context for the Dagger operations.
+	// +optional
+	ctx context.Context,
+	// backendType is the backend type, e.g. "s3" or "http".
+	// +optional
+	backendType string,
+	// config are partial configuration values, as "key=value", e.g. "bucket=my-state".
+	// +optional
+	config []string,
+	// configFiles are plain backend configuration files, e.g. ./prod.s3.tfbackend.
+	// +optional
+	configFiles []*dagger.File,
+	// secretConfigFiles are backend configuration files holding secrets, e.g. file:./prod.s3.tfbackend.
+	// +optional
+	secretConfigFiles []*dagger.Secret,
+	// secretConfigKeys are the keys of secret configuration values, e.g. "password".
+	// +optional
+	secretConfigKeys []string,
+	// secretConfigValues are the secret configuration values, in the same order as secretConfigKeys.
+	// +optional
+	secretConfigValues []*dagger.Secret,
+) (*Infra, error) {
+	if backendType != "" && !backendTypePattern.MatchString(backendType) {
+		return nil, Errorf("invalid backend type %q", backendType)
+	}
+
+	for _, value := range config {
+		key, _, found := strings.Cut(value, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, Errorf("backend configuration values must be in the format key=value: %q", value)
+		}
+	}
+
+	if len(secretConfigKeys) != len(secretConfigValues) {
+		return nil, Errorf("got %d secret backend configuration keys but %d values", len(secretConfigKeys), len(secretConfigValues))
+	}
+
+	backend := m.Backend
+	if backend == nil {
+		backend = &TerraformBackend{}
+	}
+
+	if backendType != "" {
+		backend.Type = backendType
+	}
+
+	backend.Config = append(backend.Config, config...)
+
+	nextConfigFilePath := func(kind string) string {
+		return filepath.Join(configBackendConfigPath, fmt.Sprintf("%02d-%s.tfbackend", len(backend.ConfigFiles), kind))
+	}
+
+	for _, file := range configFiles {
+		path := nextConfigFilePath("plain")
+		m.Ctr = m.Ctr.WithMountedFile(path, file)
+		backend.ConfigFiles = append(backend.ConfigFiles, path)
+	}
+
+	for _, secret := range secretConfigFiles {
+		path := nextConfigFilePath("secret")
+		m.Ctr = m.Ctr.WithMountedSecret(path, secret, dagger.ContainerWithMountedSecretOpts{Mode: credentialFileMode})
+		backend.ConfigFiles = append(backend.ConfigFiles, path)
+		m.registerSecret(secret)
+	}
+
+	if len(secretConfigKeys) > 0 {
+		values := make([]string, len(secretConfigValues))
+
+		for i, secret := range secretConfigValues {
+			if !envVarNamePattern.MatchString(secretConfigKeys[i]) {
+				return nil, Errorf("invalid backend configuration key %q", secretConfigKeys[i])
+			}
+
+			value, err := secret.Plaintext(ctx)
+			if err != nil {
+				return nil, WrapErrorf(err, "failed to read the secret backend configuration value %q", secretConfigKeys[i])
+			}
+
+			values[i] = value
+			m.registerSecret(secret)
+		}
+
+		path := nextConfigFilePath("values")
+		m.Ctr = m.Ctr.WithMountedSecret(path,
+			newDigestNamedSecret("backend-config", renderBackendConfigFile(secretConfigKeys, values)),
+			dagger.ContainerWithMountedSecretOpts{Mode: credentialFileMode})
+		backend.ConfigFiles = append(backend.ConfigFiles, path)
+	}
+
+	m.Backend = backend
+
+	return m, nil
+}
+
+// getBackendInitArgs returns the -backend-config arguments of 'terraform init'. Files come first,
+// so key=value pairs override them, as on the command line.
+func (m *Infra) getBackendInitArgs() []string {
+	if m.Backend == nil {
+		return nil
+	}
+
+	args := []string{"-input=false", "-reconfigure"}
+
+	for _, path := range m.Backend.ConfigFiles {
+		args = append(args, "-backend-config="+path)
+	}
+
+	for _, value := range m.Backend.Config {
+		args = append(args, "-backend-config="+value)
+	}
+
+	return args
+}
+
+// getTerraformInitCmd returns the 'terraform init' command of an action. Actions that need state
+// (plans) ask for the backend; it is used when one is configured. Otherwise, the backend is
+// disabled, which is enough for validation.
+func (m *Infra) getTerraformInitCmd(useBackend bool) DaggerCMD {
+	if !useBackend || m.Backend == nil {
+		return DaggerCMD{"terraform", "init", "-backend=false"}
+	}
+
+	return append(DaggerCMD{"terraform", "init"}, m.getBackendInitArgs()...)
+}
+
+// withBackendOverride writes the backend override file into the working directory, when a
+// backend type is configured.
+func (m *Infra) withBackendOverride(ctr *dagger.Container) *dagger.Container {
+	if m.Backend == nil || m.Backend.Type == "" {
+		return ctr
+	}
+
+	override := fmt.Sprintf("terraform {\n  backend %q {}\n}\n", m.Backend.Type)
+
+	return ctr.WithNewFile(backendOverrideFileName, override)
+}
+
+// MinIOService returns an S3-compatible MinIO server, to test S3 backends locally.
+//
+// Parameters:
+//   - rootUser: The MinIO root user, used as the AWS access key ID
+//   - rootPassword: The MinIO root password, used as the AWS secret access key
+//
+// Returns:
+//   - *dagger.Service: The MinIO server, listening on port 9000
+func (m *Infra) MinIOService(
+	// rootUser is the MinIO root user.
+	rootUser *dagger.Secret,
+	// rootPassword is the MinIO root password.
+	rootPassword *dagger.Secret,
+) *dagger.Service {
+	return dag.Container().
+		From(minioImage).
+		WithSecretVariable("MINIO_ROOT_USER", rootUser).
+		WithSecretVariable("MINIO_ROOT_PASSWORD", rootPassword).
+		WithExposedPort(minioPort).
+		AsService(dagger.ContainerAsServiceOpts{
+			Args: []string{"minio", "server", "/data", "--address", fmt.Sprintf(":%d", minioPort)},
+		})
+}
+
+// ActionTerraformBackendVerification verifies the backend support against a local MinIO server.
+//
+// It creates a state bucket, then runs 'terraform init' and 'terraform apply' on a configuration
+// without a backend block and without providers, using an S3 backend set with WithBackend (type,
+// plain file and secret values). It succeeds when the state object is found in the bucket.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//
+// Returns:
+//   - string: The report of the verification
+//   - error: An error if a step fails or the state is not written to the bucket
+func (m *Infra) ActionTerraformBackendVerification(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	rootUser := dag.SetSecret("minio-verification-user", minioVerificationUser)
+	rootPassword := dag.SetSecret("minio-verification-password", minioVerificationKey)
+
+	minio, err := m.MinIOService(rootUser, rootPassword).Start(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to start the MinIO server")
+	}
+
+	defer func() {
+		_, _ = minio.Stop(ctx)
+	}()
+
+	minioEndpoint := fmt.Sprintf("http://%s:%d", minioHostname, minioPort)
+
+	mc := dag.Container().
+		From(minioClientImage).
+		WithServiceBinding(minioHostname, minio).
+		WithSecretVariable("MINIO_ROOT_USER", rootUser).
+		WithSecretVariable("MINIO_ROOT_PASSWORD", rootPassword).
+		WithEntrypoint([]string{"/bin/sh", "-c"})
+
+	mcAlias := fmt.Sprintf(`mc alias set local %s "$MINIO_ROOT_USER" "$MINIO_ROOT_PASSWORD" >/dev/null && `, minioEndpoint)
+
+	if _, err := mc.WithExec([]string{mcAlias + "mc mb --ignore-existing local/" + backendStateBucketName},
+		dagger.ContainerWithExecOpts{UseEntrypoint: true}).Sync(ctx); err != nil {
+		return "", WrapErrorf(err, "failed to create the state bucket")
+	}
+
+	backendConfigFile := dag.Directory().WithNewFile("minio.s3.tfbackend", fmt.Sprintf(`bucket                      = %q
+key                         = "verification/terraform.tfstate"
+region                      = "us-east-1"
+endpoints                   = { s3 = %q }
+use_path_style              = true
+skip_credentials_validation = true
+skip_region_validation      = true
+skip_requesting_account_id  = true
+skip_metadata_api_check     = true
+skip_s3_checksum            = true
+`, backendStateBucketName, minioEndpoint)).File("minio.s3.tfbackend")
+
+	// The verification replaces any backend configured before.
+	m.Backend = nil
+
+	verification, err := m.WithBackend(ctx, "s3", nil, []*dagger.File{backendConfigFile}, nil,
+		[]string{"access_key", "secret_key"}, []*dagger.Secret{rootUser, rootPassword})
+	if err != nil {
+		return "", WrapErrorf(err, "failed to configure the backend")
+	}
+
+	probeDir := "/tmp/backend-verification"
+
+	probeCtr := verification.withBackendOverride(verification.Ctr.
+		WithServiceBinding(minioHostname, minio).
+		WithNewFile(probeDir+"/main.tf", "resource \"terraform_data\" \"verification\" {\n  input = \"backend\"\n}\n").
+		WithWorkdir(probeDir))
+
+	probeCtr, err = verification.runActionCMDs(ctx, probeCtr,
+		verification.getTerraformInitCmd(true),
+		DaggerCMD{"terraform", "apply", "-input=false", "-auto-approve"},
+	)
+	if err != nil {
+		return "", WrapErrorf(err, "backend verification failed")
+	}
+
+	if _, err := mc.WithExec([]string{mcAlias + "mc stat local/" + backendStateBucketName + "/verification/terraform.tfstate"},
+		dagger.ContainerWithExecOpts{UseEntrypoint: true}).Sync(ctx); err != nil {
+		return "", WrapErrorf(err, "the state was not written to the backend bucket")
+	}
+
+	return verification.processActionResult(ctx, "backend.apply", probeCtr)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestWithBackend verifies the validation of the backend configuration, and the -backend-config
+// arguments rendered from the key=value pairs of cumulative calls.
+func TestWithBackend(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		backendType        string
+		config             [][]string
+		secretConfigKeys   []string
+		secretConfigValues []*dagger.Secret
+		wantType           string
+		wantArgs           []string
+		wantErr            string
+	}{
+		{
+			name:        "key=value pairs",
+			backendType: "s3",
+			config:      [][]string{{"bucket=my-state", "key=default/terraform.tfstate", "region=eu-west-1"}},
+			wantType:    "s3",
+			wantArgs: []string{
+				"-input=false", "-reconfigure",
+				"-backend-config=bucket=my-state",
+				"-backend-config=key=default/terraform.tfstate",
+				"-backend-config=region=eu-west-1",
+			},
+		},
+		{
+			name:     "value holding an equals sign",
+			config:   [][]string{{"address=https://state.example.com/state?a=b"}},
+			wantArgs: []string{"-input=false", "-reconfigure", "-backend-config=address=https://state.example.com/state?a=b"},
+		},
+		{
+			name:     "empty value",
+			config:   [][]string{{"workspace_key_prefix="}},
+			wantArgs: []string{"-input=false", "-reconfigure", "-backend-config=workspace_key_prefix="},
+		},
+		{
+			name:        "cumulative calls",
+			backendType: "http",
+			config:      [][]string{{"address=http://backend:8080/state/app"}, {"lock_method=LOCK"}},
+			wantType:    "http",
+			wantArgs: []string{
+				"-input=false", "-reconfigure",
+				"-backend-config=address=http://backend:8080/state/app",
+				"-backend-config=lock_method=LOCK",
+			},
+		},
+		{
+			name:    "pair without a separator",
+			config:  [][]string{{"bucket"}},
+			wantErr: `must be in the format key=value: "bucket"`,
+		},
+		{
+			name:    "pair without a key",
+			config:  [][]string{{" =my-state"}},
+			wantErr: `must be in the format key=value: " =my-state"`,
+		},
+		{
+			name:        "invalid backend type",
+			backendType: "S3 backend",
+			wantErr:     `invalid backend type "S3 backend"`,
+		},
+		{
+			name:             "secret keys without values",
+			secretConfigKeys: []string{"password"},
+			wantErr:          "got 1 secret backend configuration keys but 0 values",
+		},
+		{
+			name:               "invalid secret key",
+			secretConfigKeys:   []string{"pass-word"},
+			secretConfigValues: []*dagger.Secret{{}},
+			wantErr:            `invalid backend configuration key "pass-word"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := &Infra{}
+			calls := tt.config
+
+			if len(calls) == 0 {
+				calls = [][]string{nil}
+			}
+
+			var err error
+
+			for i, config := range calls {
+				backendType := ""
+				if i == 0 {
+					backendType = tt.backendType
+				}
+
+				if _, err = m.WithBackend(context.Background(), backendType, config, nil, nil,
+					tt.secretConfigKeys, tt.secretConfigValues); err != nil {
+					break
+				}
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("WithBackend() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("WithBackend() error = %v", err)
+			}
+
+			if m.Backend.Type != tt.wantType {
+				t.Errorf("Backend.Type = %q, want %q", m.Backend.Type, tt.wantType)
+			}
+
+			if got := m.getBackendInitArgs(); !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("getBackendInitArgs() = %v, want %v", got, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// TestGetBackendInitArgs verifies that configuration files come before the key=value pairs, so
+// the pairs override them.
+func TestGetBackendInitArgs(t *testing.T) {
+	t.Parallel()
+
+	if got := (&Infra{}).getBackendInitArgs(); got != nil {
+		t.Errorf("getBackendInitArgs() without a backend = %v, want nil", got)
+	}
+
+	m := &Infra{Backend: &TerraformBackend{
+		Config:      []string{"key=override.tfstate"},
+		ConfigFiles: []string{"/run/secrets/backend/00-secret.tfbackend", "/run/secrets/backend/01-values.tfbackend"},
+	}}
+
+	want := []string{
+		"-input=false", "-reconfigure",
+		"-backend-config=/run/secrets/backend/00-secret.tfbackend",
+		"-backend-config=/run/secrets/backend/01-values.tfbackend",
+		"-backend-config=key=override.tfstate",
+	}
+
+	if got := m.getBackendInitArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("getBackendInitArgs() = %v, want %v", got, want)
+	}
+}
+
+// TestRenderBackendConfigFile verifies that secret configuration values are quoted, with
+// template sequences escaped.
+func TestRenderBackendConfigFile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		keys   []string
+		values []string
+		want   string
+	}{
+		{name: "no values"},
+		{
+			name:   "plain values",
+			keys:   []string{"username", "password"},
+			values: []string{"ci-bot", "p@ss word"},
+			want:   "username = \"ci-bot\"\npassword = \"p@ss word\"\n",
+		},
+		{
+			name:   "quotes and backslashes",
+			keys:   []string{"password"},
+			values: []string{`a"b\c`},
+			want:   "password = \"a\\\"b\\\\c\"\n",
+		},
+		{
+			name:   "template sequences",
+			keys:   []string{"password"},
+			values: []string{"${var.x}%{if}"},
+			want:   "password = \"$${var.x}%%{if}\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := renderBackendConfigFile(tt.keys, tt.values); got != tt.want {
+				t.Errorf("renderBackendConfigFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
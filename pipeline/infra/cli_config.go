@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	// configTerraformCLIConfigPath is the path of the generated CLI configuration file.
+	configTerraformCLIConfigPath = "/root/.terraform.d/pipeline.tfrc"
+	// configTerraformCLICredentialsPath is where Terraform reads the credentials of the CLI
+	// configuration from, besides the CLI configuration file.
+	configTerraformCLICredentialsPath = "/root/.terraform.d/credentials.tfrc.json"
+	// Provider installation methods
+	providerInstallationDirect           = "direct"
+	providerInstallationFilesystemMirror = "filesystem_mirror"
+	providerInstallationNetworkMirror    = "network_mirror"
+)
+
+// TerraformCLIConfig is the CLI configuration generated by the WithTerraformCLIConfig* functions.
+// Credentials are not part of it: they are mounted as a secret, from the registry tokens.
+type TerraformCLIConfig struct {
+	// PluginCacheDir is the plugin_cache_dir setting.
+	PluginCacheDir string
+	// PluginCacheMayBreakDependencyLockFile is the plugin_cache_may_break_dependency_lock_file setting.
+	PluginCacheMayBreakDependencyLockFile bool
+	// ProviderInstallation are the methods of the provider_installation block, in order.
+	ProviderInstallation []*ProviderInstallationMethod
+	// Hosts are the host blocks, overriding the service discovery of a host.
+	Hosts []*TerraformCLIConfigHost
+}
+
+// ProviderInstallationMethod is a method of the provider_installation block.
+type ProviderInstallationMethod struct {
+	// Method is "direct", "filesystem_mirror" or "network_mirror".
+	Method string
+	// Location is the path of a filesystem mirror, or the URL of a network mirror.
+	Location string
+	// Include are the provider address patterns installed with this method.
+	Include []string
+	// Exclude are the provider address patterns never installed with this method.
+	Exclude []string
+}
+
+// TerraformCLIConfigHost is a host block of the CLI configuration.
+type TerraformCLIConfigHost struct {
+	// Host is the hostname, in its normalized form.
+	Host string
+	// ServiceIDs are the service identifiers, e.g. "modules.v1".
+	ServiceIDs []string
+	// ServiceURLs are the service URLs, in the same order as ServiceIDs.
+	ServiceURLs []string
+}
+
+// render renders the CLI configuration file.
+func (c *TerraformCLIConfig) render() string {
+	file := hclwrite.NewEmptyFile()
+	body := file.Body()
+
+	if c.PluginCacheDir != "" {
+		body.SetAttributeValue("plugin_cache_dir", cty.StringVal(c.PluginCacheDir))
+	}
+
+	if c.PluginCacheMayBreakDependencyLockFile {
+		body.SetAttributeValue("plugin_cache_may_break_dependency_lock_file", cty.True)
+	}
+
+	for _, host := range c.Hosts {
+		services := make(map[string]cty.Value, len(host.ServiceIDs))
+		for i, serviceID := range host.ServiceIDs {
+			services[serviceID] = cty.StringVal(host.ServiceURLs[i])
+		}
+
+		body.AppendNewline()
+		hostBody := body.AppendNewBlock("host", []string{host.Host}).Body()
+		hostBody.SetAttributeValue("services", cty.ObjectVal(services))
+	}
+
+	if len(c.ProviderInstallation) > 0 {
+		body.AppendNewline()
+		installationBody := body.AppendNewBlock("provider_installation", nil).Body()
+
+		for _, method := range c.ProviderInstallation {
+			methodBody := installationBody.AppendNewBlock(method.Method, nil).Body()
+
+			switch method.Method {
+			case providerInstallationFilesystemMirror:
+				methodBody.SetAttributeValue("path", cty.StringVal(method.Location))
+			case providerInstallationNetworkMirror:
+				methodBody.SetAttributeValue("url", cty.StringVal(method.Location))
+			}
+
+			if len(method.Include) > 0 {
+				methodBody.SetAttributeValue("include", cty.ListVal(stringValues(method.Include)))
+			}
+
+			if len(method.Exclude) > 0 {
+				methodBody.SetAttributeValue("exclude", cty.ListVal(stringValues(method.Exclude)))
+			}
+		}
+	}
+
+	return string(hclwrite.Format(file.Bytes()))
+}
+
+// stringValues converts strings to cty values.
+func stringValues(values []string) []cty.Value {
+	converted := make([]cty.Value, len(values))
+	for i, value := range values {
+		converted[i] = cty.StringVal(value)
+	}
+
+	return converted
+}
+
+// getTerraformCLIConfig returns the CLI configuration being built, creating it on first use.
+func (m *Infra) getTerraformCLIConfig() *TerraformCLIConfig {
+	if m.TerraformCLIConfig == nil {
+		m.TerraformCLIConfig = &TerraformCLIConfig{}
+	}
+
+	return m.TerraformCLIConfig
+}
+
+// writeTerraformCLIConfig writes the CLI configuration file into the container, and points
+// TF_CLI_CONFIG_FILE to it. Every builder calls it, so the file is always up to date.
+func (m *Infra) writeTerraformCLIConfig() *Infra {
+	m.Ctr = m.Ctr.
+		WithNewFile(configTerraformCLIConfigPath, m.getTerraformCLIConfig().render()).
+		WithEnvVariable("TF_CLI_CONFIG_FILE", configTerraformCLIConfigPath)
+
+	return m
+}
+
+// withTerraformCLICredentials mounts the credentials of every registry token as the
+// credentials.tfrc.json file of the CLI configuration. Tokens are resolved in memory only, and
+// the file is mounted as a secret, so they never enter the layer cache.
+func (m *Infra) withTerraformCLICredentials(ctx context.Context) (*Infra, error) {
+	credentials := make(map[string]map[string]string, len(m.RegistryTokens))
+
+	for _, registryToken := range m.RegistryTokens {
+		token, err := registryToken.Token.Plaintext(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to resolve the registry token for host %q", registryToken.Host)
+		}
+
+		credentials[registryToken.Host] = map[string]string{"token": token}
+	}
+
+	rendered, err := json.MarshalIndent(map[string]any{"credentials": credentials}, "", "  ")
+	if err != nil {
+		return nil, Errorf("failed to render the CLI credentials: %v", err)
+	}
+
+	m.Ctr = m.Ctr.WithMountedSecret(configTerraformCLICredentialsPath,
+		newDigestNamedSecret("tfrc-credentials", string(rendered)),
+		dagger.ContainerWithMountedSecretOpts{Mode: credentialFileMode})
+
+	return m, nil
+}
+
+// WithTerraformCLIConfigPluginCache sets the plugin cache settings of the generated CLI configuration.
+//
+// Parameters:
+//   - pluginCacheDir: The plugin cache directory (defaults to the terraform-plugin-cache volume path)
+//   - mayBreakDependencyLockFile: Whether to use cached plugins not recorded in the lock file
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the CLI configuration written
+func (m *Infra) WithTerraformCLIConfigPluginCache(
+	// pluginCacheDir is the plugin cache directory.
+	// +optional
+	pluginCacheDir string,
+	// mayBreakDependencyLockFile sets plugin_cache_may_break_dependency_lock_file.
+	// +optional
+	mayBreakDependencyLockFile bool,
+) *Infra {
+	if pluginCacheDir == "" {
+		pluginCacheDir = configTerraformPluginCachePath
+	}
+
+	cliConfig := m.getTerraformCLIConfig()
+	cliConfig.PluginCacheDir = pluginCacheDir
+	cliConfig.PluginCacheMayBreakDependencyLockFile = mayBreakDependencyLockFile
+
+	return m.writeTerraformCLIConfig()
+}
+
+// WithTerraformCLIConfigProviderInstallation adds a method to the provider_installation block of
+// the generated CLI configuration. Methods are tried in the order they are added.
+//
+// Parameters:
+//   - method: "direct", "filesystem_mirror" or "network_mirror"
+//   - location: The mirror path (filesystem_mirror) or https:// URL (network_mirror)
+//   - include: The provider address patterns installed with this method, e.g. "hashicorp/*" (optional)
+//   - exclude: The provider address patterns never installed with this method (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the CLI configuration written
+//   - error: An error if the method, the location or a pattern is invalid
+func (m *Infra) WithTerraformCLIConfigProviderInstallation(
+	// method is the installation method: "direct", "filesystem_mirror" or "network_mirror".
+	method string,
+	// location is the mirror path (filesystem_mirror) or https:// URL (network_mirror).
+	// +optional
+	location string,
+	// include are the provider address patterns installed with this method, e.g. "registry.terraform.io/hashicorp/*".
+	// +optional
+	include []string,
+	// exclude are the provider address patterns never installed with this method.
+	// +optional
+	exclude []string,
+) (*Infra, error) {
+	cliConfig := m.getTerraformCLIConfig()
+
+	switch method {
+	case providerInstallationDirect:
+		if location != "" {
+			return nil, Errorf("the direct installation method takes no location")
+		}
+
+		for _, existing := range cliConfig.ProviderInstallation {
+			if existing.Method == providerInstallationDirect {
+				return nil, Errorf("the direct installation method can only be used once")
+			}
+		}
+	case providerInstallationFilesystemMirror:
+		if !strings.HasPrefix(location, "/") {
+			return nil, Errorf("the filesystem mirror location must be an absolute path, got %q", location)
+		}
+	case providerInstallationNetworkMirror:
+		parsed, err := url.Parse(location)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return nil, Errorf("invalid network mirror URL %q, Terraform requires an https:// URL", location)
+		}
+
+		if !strings.HasSuffix(location, "/") {
+			location += "/"
+		}
+	default:
+		return nil, Errorf("invalid provider installation method %q, expected direct, filesystem_mirror or network_mirror", method)
+	}
+
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		parts := strings.Split(pattern, "/")
+		if len(parts) < 2 || len(parts) > 3 || contains(parts, "") {
+			return nil, Errorf("invalid provider address pattern %q, expected [hostname/]namespace/type", pattern)
+		}
+	}
+
+	cliConfig.ProviderInstallation = append(cliConfig.ProviderInstallation, &ProviderInstallationMethod{
+		Method:   method,
+		Location: location,
+		Include:  include,
+		Exclude:  exclude,
+	})
+
+	return m.writeTerraformCLIConfig(), nil
+}
+
+// WithTerraformCLIConfigHost adds a host block to the generated CLI configuration, overriding the
+// service discovery of a host, e.g. to reach a registry that does not serve /.well-known/terraform.json.
+//
+// Parameters:
+//   - host: The hostname, e.g. "registry.example.com"
+//   - serviceIDs: The service identifiers, e.g. "modules.v1"
+//   - serviceURLs: The service URLs, in the same order as serviceIDs
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the CLI configuration written
+//   - error: An error if the host is invalid, or the services do not pair up
+func (m *Infra) WithTerraformCLIConfigHost(
+	// host is the hostname, e.g. "registry.example.com".
+	host string,
+	// serviceIDs are the service identifiers, e.g. "modules.v1", "providers.v1".
+	serviceIDs []string,
+	// serviceURLs are the service URLs, in the same order as serviceIDs.
+	serviceURLs []string,
+) (*Infra, error) {
+	normalized, err := normalizeRegistryHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(serviceIDs) == 0 || len(serviceIDs) != len(serviceURLs) {
+		return nil, Errorf("got %d service identifiers but %d URLs for host %q, each service needs exactly one URL",
+			len(serviceIDs), len(serviceURLs), host)
+	}
+
+	cliConfig := m.getTerraformCLIConfig()
+
+	hosts := make([]*TerraformCLIConfigHost, 0, len(cliConfig.Hosts)+1)
+	for _, existing := range cliConfig.Hosts {
+		if existing.Host != normalized {
+			hosts = append(hosts, existing)
+		}
+	}
+
+	hosts = append(hosts, &TerraformCLIConfigHost{Host: normalized, ServiceIDs: serviceIDs, ServiceURLs: serviceURLs})
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	cliConfig.Hosts = hosts
+
+	return m.writeTerraformCLIConfig(), nil
+}
+
+// WithTerraformCLIConfigCredentials sets the API tokens of registry hosts, both as TF_TOKEN_<host>
+// variables (see WithRegistryTokens) and as the credentials of the generated CLI configuration.
+//
+// The credentials are mounted as a secret credentials.tfrc.json file, which Terraform reads
+// alongside the CLI configuration file, so they never land in a layer.
+//
+// Parameters:
+//   - ctx: The context for the Dagger container
+//   - hosts: The registry hostnames
+//   - tokens: The API tokens, in the same order as hosts
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the credentials mounted
+//   - error: An error if a host is invalid, the hosts and tokens do not pair up, or a token cannot be resolved
+func (m *Infra) WithTerraformCLIConfigCredentials(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+	// hosts are the registry hostnames, e.g. "registry.example.com".
+	hosts []string,
+	// tokens are the API tokens of the registries, in the same order as hosts.
+	tokens []*dagger.Secret,
+) (*Infra, error) {
+	if _, err := m.WithRegistryTokens(hosts, tokens); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.withTerraformCLICredentials(ctx); err != nil {
+		return nil, err
+	}
+
+	return m.writeTerraformCLIConfig(), nil
+}
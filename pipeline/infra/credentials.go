@@ -0,0 +1,455 @@
+package main
+
+import (
+	"dagger/infra/internal/dagger"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// credentialFileMode is the file mode of the credential files mounted as secrets, readable by
+// their owner only.
+const credentialFileMode = 0o400
+
+// envVarNamePattern matches valid environment variable names.
+var envVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CredentialProvider injects a set of credentials into a container.
+//
+// Providers are applied by the job builder, which validates them first, registers their secrets
+// for redaction, and records what they inject so reports can list the credentials in use without
+// revealing their values.
+type CredentialProvider interface {
+	// Name identifies the provider in reports, e.g. "aws-static-keys".
+	Name() string
+	// Validate reports whether the provider is configured consistently.
+	Validate() error
+	// Apply injects the credentials into the container.
+	Apply(ctr *dagger.Container) *dagger.Container
+	// Usage returns the environment variables and files the provider injects.
+	Usage() CredentialUsage
+	// Secrets returns the secrets the provider injects.
+	Secrets() []*dagger.Secret
+}
+
+// CredentialUsage describes what a credential provider injects, without the values.
+type CredentialUsage struct {
+	// Provider is the name of the credential provider.
+	Provider string
+	// EnvVars are the environment variables injected by the provider.
+	EnvVars []string
+	// Files are the files injected by the provider.
+	Files []string
+}
+
+// String returns a one-line description of the credential usage.
+func (u CredentialUsage) String() string {
+	envVars := "none"
+	if len(u.EnvVars) > 0 {
+		envVars = strings.Join(u.EnvVars, ", ")
+	}
+
+	files := "none"
+	if len(u.Files) > 0 {
+		files = strings.Join(u.Files, ", ")
+	}
+
+	return fmt.Sprintf("%s (env: %s; files: %s)", u.Provider, envVars, files)
+}
+
+// credentialEnvVar is an environment variable injected by a credential provider, either as a
+// plain value or as a secret.
+type credentialEnvVar struct {
+	name     string
+	value    string
+	secret   *dagger.Secret
+	required bool
+}
+
+// credentialFile is a file injected by a credential provider, either mounted from a secret or
+// created from plain contents (e.g. a configuration that only references secrets), and
+// optionally referenced by an environment variable.
+type credentialFile struct {
+	path     string
+	secret   *dagger.Secret
+	contents string
+	envVar   string
+}
+
+// isSet reports whether the environment variable has a value.
+func (e credentialEnvVar) isSet() bool {
+	return e.secret != nil || e.value != ""
+}
+
+// validateCredentialEnvVars checks the names of the environment variables, and that the required
+// ones have a value.
+func validateCredentialEnvVars(provider string, envVars []credentialEnvVar) error {
+	seen := make(map[string]bool, len(envVars))
+
+	for _, envVar := range envVars {
+		if !envVarNamePattern.MatchString(envVar.name) {
+			return Errorf("%s: invalid environment variable name %q", provider, envVar.name)
+		}
+
+		if seen[envVar.name] {
+			return Errorf("%s: environment variable %s is set more than once", provider, envVar.name)
+		}
+
+		seen[envVar.name] = true
+
+		if envVar.required && !envVar.isSet() {
+			return Errorf("%s: %s must not be empty", provider, envVar.name)
+		}
+	}
+
+	return nil
+}
+
+// validateCredentialFiles checks that every file has an absolute path and contents.
+func validateCredentialFiles(provider string, files []credentialFile) error {
+	for _, file := range files {
+		if !path.IsAbs(file.path) {
+			return Errorf("%s: credential file path %q must be absolute", provider, file.path)
+		}
+
+		if file.secret == nil && file.contents == "" {
+			return Errorf("%s: credential file %s must not be empty", provider, file.path)
+		}
+
+		if file.envVar != "" && !envVarNamePattern.MatchString(file.envVar) {
+			return Errorf("%s: invalid environment variable name %q", provider, file.envVar)
+		}
+	}
+
+	return nil
+}
+
+// applyCredentialEnvVars sets the environment variables that have a value.
+func applyCredentialEnvVars(ctr *dagger.Container, envVars []credentialEnvVar) *dagger.Container {
+	for _, envVar := range envVars {
+		switch {
+		case envVar.secret != nil:
+			ctr = ctr.WithSecretVariable(envVar.name, envVar.secret)
+		case envVar.value != "":
+			ctr = ctr.WithEnvVariable(envVar.name, envVar.value)
+		}
+	}
+
+	return ctr
+}
+
+// applyCredentialFiles mounts or creates the files, and sets the environment variables that
+// reference them.
+func applyCredentialFiles(ctr *dagger.Container, files []credentialFile) *dagger.Container {
+	for _, file := range files {
+		if file.secret != nil {
+			ctr = ctr.WithMountedSecret(file.path, file.secret, dagger.ContainerWithMountedSecretOpts{
+				Mode: credentialFileMode,
+			})
+		} else {
+			ctr = ctr.WithNewFile(file.path, file.contents)
+		}
+
+		if file.envVar != "" {
+			ctr = ctr.WithEnvVariable(file.envVar, file.path)
+		}
+	}
+
+	return ctr
+}
+
+// getCredentialUsage lists the environment variables and files injected by a provider.
+func getCredentialUsage(provider string, envVars []credentialEnvVar, files []credentialFile) CredentialUsage {
+	usage := CredentialUsage{Provider: provider}
+
+	for _, envVar := range envVars {
+		if envVar.isSet() {
+			usage.EnvVars = append(usage.EnvVars, envVar.name)
+		}
+	}
+
+	for _, file := range files {
+		usage.Files = append(usage.Files, file.path)
+
+		if file.envVar != "" {
+			usage.EnvVars = append(usage.EnvVars, file.envVar)
+		}
+	}
+
+	return usage
+}
+
+// getCredentialSecrets returns the secrets of the environment variables and files.
+func getCredentialSecrets(envVars []credentialEnvVar, files []credentialFile) []*dagger.Secret {
+	var secrets []*dagger.Secret
+
+	for _, envVar := range envVars {
+		if envVar.secret != nil {
+			secrets = append(secrets, envVar.secret)
+		}
+	}
+
+	for _, file := range files {
+		if file.secret != nil {
+			secrets = append(secrets, file.secret)
+		}
+	}
+
+	return secrets
+}
+
+// staticKeysCredentialProvider injects long-lived keys as environment variables.
+type staticKeysCredentialProvider struct {
+	name    string
+	envVars []credentialEnvVar
+}
+
+func (p *staticKeysCredentialProvider) Name() string { return p.name }
+
+func (p *staticKeysCredentialProvider) Validate() error {
+	return validateCredentialEnvVars(p.name, p.envVars)
+}
+
+func (p *staticKeysCredentialProvider) Apply(ctr *dagger.Container) *dagger.Container {
+	return applyCredentialEnvVars(ctr, p.envVars)
+}
+
+func (p *staticKeysCredentialProvider) Usage() CredentialUsage {
+	return getCredentialUsage(p.name, p.envVars, nil)
+}
+
+func (p *staticKeysCredentialProvider) Secrets() []*dagger.Secret {
+	return getCredentialSecrets(p.envVars, nil)
+}
+
+// webIdentityCredentialProvider mounts an OIDC token as a secret file, to be exchanged for
+// short-lived credentials by the provider SDK. Environment variables listed in 'unset' (e.g.
+// static keys that would take precedence) are removed.
+type webIdentityCredentialProvider struct {
+	name            string
+	token           *dagger.Secret
+	tokenPath       string
+	tokenPathEnvVar string
+	envVars         []credentialEnvVar
+	files           []credentialFile
+	unset           []string
+}
+
+func (p *webIdentityCredentialProvider) Name() string { return p.name }
+
+func (p *webIdentityCredentialProvider) Validate() error {
+	if p.token == nil {
+		return Errorf("%s: the OIDC token must not be empty", p.name)
+	}
+
+	if err := validateCredentialEnvVars(p.name, p.envVars); err != nil {
+		return err
+	}
+
+	return validateCredentialFiles(p.name, p.tokenFiles())
+}
+
+func (p *webIdentityCredentialProvider) Apply(ctr *dagger.Container) *dagger.Container {
+	for _, name := range p.unset {
+		ctr = ctr.WithoutEnvVariable(name)
+	}
+
+	ctr = applyCredentialFiles(ctr, p.tokenFiles())
+
+	return applyCredentialEnvVars(ctr, p.envVars)
+}
+
+func (p *webIdentityCredentialProvider) Usage() CredentialUsage {
+	return getCredentialUsage(p.name, p.envVars, p.tokenFiles())
+}
+
+func (p *webIdentityCredentialProvider) Secrets() []*dagger.Secret {
+	return getCredentialSecrets(p.envVars, p.tokenFiles())
+}
+
+// tokenFiles returns the token file followed by the additional files of the provider.
+func (p *webIdentityCredentialProvider) tokenFiles() []credentialFile {
+	token := credentialFile{path: p.tokenPath, secret: p.token, envVar: p.tokenPathEnvVar}
+
+	return append([]credentialFile{token}, p.files...)
+}
+
+// configFileCredentialProvider mounts credential or configuration files (e.g. a shared
+// credentials file, a service-account key) and sets the variables that select them. Environment
+// variables listed in 'unset' are removed, as they would take precedence over the files.
+type configFileCredentialProvider struct {
+	name    string
+	files   []credentialFile
+	envVars []credentialEnvVar
+	unset   []string
+}
+
+func (p *configFileCredentialProvider) Name() string { return p.name }
+
+func (p *configFileCredentialProvider) Validate() error {
+	if len(p.files) == 0 {
+		return Errorf("%s: at least one credential file is required", p.name)
+	}
+
+	if err := validateCredentialFiles(p.name, p.files); err != nil {
+		return err
+	}
+
+	return validateCredentialEnvVars(p.name, p.envVars)
+}
+
+func (p *configFileCredentialProvider) Apply(ctr *dagger.Container) *dagger.Container {
+	for _, name := range p.unset {
+		ctr = ctr.WithoutEnvVariable(name)
+	}
+
+	ctr = applyCredentialFiles(ctr, p.files)
+
+	return applyCredentialEnvVars(ctr, p.envVars)
+}
+
+func (p *configFileCredentialProvider) Usage() CredentialUsage {
+	return getCredentialUsage(p.name, p.envVars, p.files)
+}
+
+func (p *configFileCredentialProvider) Secrets() []*dagger.Secret {
+	return getCredentialSecrets(p.envVars, p.files)
+}
+
+// envPassthroughCredentialProvider passes secrets from the caller's environment (e.g. given as
+// 'env:NAME' on the Dagger CLI) through to the container under the same names.
+type envPassthroughCredentialProvider struct {
+	name    string
+	envVars []credentialEnvVar
+}
+
+func (p *envPassthroughCredentialProvider) Name() string { return p.name }
+
+func (p *envPassthroughCredentialProvider) Validate() error {
+	if len(p.envVars) == 0 {
+		return Errorf("%s: at least one environment variable is required", p.name)
+	}
+
+	return validateCredentialEnvVars(p.name, p.envVars)
+}
+
+func (p *envPassthroughCredentialProvider) Apply(ctr *dagger.Container) *dagger.Container {
+	return applyCredentialEnvVars(ctr, p.envVars)
+}
+
+func (p *envPassthroughCredentialProvider) Usage() CredentialUsage {
+	return getCredentialUsage(p.name, p.envVars, nil)
+}
+
+func (p *envPassthroughCredentialProvider) Secrets() []*dagger.Secret {
+	return getCredentialSecrets(p.envVars, nil)
+}
+
+// applyCredentialProviders validates and applies credential providers, registers their secrets
+// for redaction, and records their usage. A provider replaces the usage previously recorded
+// under the same name. Nil providers are skipped.
+func (m *Infra) applyCredentialProviders(providers ...CredentialProvider) (*Infra, error) {
+	for _, provider := range providers {
+		if provider == nil {
+			continue
+		}
+
+		if err := provider.Validate(); err != nil {
+			return nil, WrapErrorf(err, "invalid %s credentials", provider.Name())
+		}
+
+		m.Ctr = provider.Apply(m.Ctr)
+
+		for _, secret := range provider.Secrets() {
+			m.registerSecret(secret)
+		}
+
+		m.recordCredentialUsage(provider.Usage())
+	}
+
+	return m, nil
+}
+
+// recordCredentialUsage records the usage of a credential provider, replacing the usage
+// previously recorded under the same provider name.
+func (m *Infra) recordCredentialUsage(usage CredentialUsage) {
+	for i, recorded := range m.CredentialsInUse {
+		if recorded.Provider == usage.Provider {
+			m.CredentialsInUse[i] = &usage
+
+			return
+		}
+	}
+
+	m.CredentialsInUse = append(m.CredentialsInUse, &usage)
+}
+
+// forgetCredentialUsage removes the usage recorded for a credential provider, once another
+// provider has removed what it injected.
+func (m *Infra) forgetCredentialUsage(provider string) {
+	for i, recorded := range m.CredentialsInUse {
+		if recorded.Provider == provider {
+			m.CredentialsInUse = append(m.CredentialsInUse[:i], m.CredentialsInUse[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// isCredentialInUse reports whether a credential provider was applied.
+func (m *Infra) isCredentialInUse(provider string) bool {
+	for _, recorded := range m.CredentialsInUse {
+		if recorded.Provider == provider {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithCredentialsEnvPassthrough passes secrets from the caller's environment through to the
+// container, e.g. '--names=VAULT_TOKEN --values=env:VAULT_TOKEN'.
+//
+// Parameters:
+//   - names: The names of the environment variables set in the container
+//   - values: The secrets, in the same order as the names
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the environment variables set
+//   - error: An error if a name is invalid or names and values do not match
+func (m *Infra) WithCredentialsEnvPassthrough(
+	// names are the names of the environment variables set in the container.
+	names []string,
+	// values are the secrets, in the same order as the names.
+	values []*dagger.Secret,
+) (*Infra, error) {
+	if len(names) != len(values) {
+		return nil, Errorf("got %d environment variable names but %d values", len(names), len(values))
+	}
+
+	provider := &envPassthroughCredentialProvider{name: "env-passthrough"}
+
+	for i, name := range names {
+		provider.envVars = append(provider.envVars, credentialEnvVar{name: name, secret: values[i], required: true})
+	}
+
+	return m.applyCredentialProviders(provider)
+}
+
+// formatCredentialsInUse renders the credentials in use as a report section. It returns an empty
+// string when no credential provider was applied.
+func formatCredentialsInUse(credentials []*CredentialUsage) string {
+	if len(credentials) == 0 {
+		return ""
+	}
+
+	var credentialsBuilder strings.Builder
+	credentialsBuilder.WriteString("Credentials in use:\n")
+
+	for _, usage := range credentials {
+		credentialsBuilder.WriteString("  " + usage.String() + "\n")
+	}
+
+	return credentialsBuilder.String()
+}
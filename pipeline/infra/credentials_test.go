@@ -0,0 +1,261 @@
+package main
+
+import (
+	"dagger/infra/internal/dagger"
+	"strings"
+	"testing"
+)
+
+// testCredentialValues are the plain values given to the providers under test, which must never
+// appear in their usage.
+var testCredentialValues = []string{"eu-west-1", "client-123", "tenant-456", "sub-789", "my-project"}
+
+// TestCredentialProviderValidate verifies that every kind of provider rejects missing environment
+// variables and files, and describes what it injects by name only.
+func TestCredentialProviderValidate(t *testing.T) {
+	t.Parallel()
+
+	secret := &dagger.Secret{}
+
+	gcpFederation, err := newGCPWorkloadIdentityFederationProvider(
+		"projects/123/locations/global/workloadIdentityPools/ci/providers/github", secret, "", "my-project", "")
+	if err != nil {
+		t.Fatalf("newGCPWorkloadIdentityFederationProvider() error = %v", err)
+	}
+
+	gcpFederationWithoutToken, err := newGCPWorkloadIdentityFederationProvider(
+		"projects/123/locations/global/workloadIdentityPools/ci/providers/github", nil, "", "my-project", "")
+	if err != nil {
+		t.Fatalf("newGCPWorkloadIdentityFederationProvider() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		provider  CredentialProvider
+		wantErr   string
+		wantUsage string
+	}{
+		{
+			name:      "aws static keys",
+			provider:  newAWSStaticKeysProvider(secret, secret, "eu-west-1", nil),
+			wantUsage: "aws-static-keys (env: AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY; files: none)",
+		},
+		{
+			name:     "aws static keys without an access key",
+			provider: newAWSStaticKeysProvider(nil, secret, "eu-west-1", nil),
+			wantErr:  "AWS_ACCESS_KEY_ID must not be empty",
+		},
+		{
+			name:     "aws static keys without a secret key",
+			provider: newAWSStaticKeysProvider(secret, nil, "eu-west-1", secret),
+			wantErr:  "AWS_SECRET_ACCESS_KEY must not be empty",
+		},
+		{
+			name:      "azure client secret",
+			provider:  newAzureServicePrincipalProvider("client-123", "tenant-456", "sub-789", secret),
+			wantUsage: "azure-client-secret (env: ARM_CLIENT_ID, ARM_TENANT_ID, ARM_SUBSCRIPTION_ID, ARM_CLIENT_SECRET; files: none)",
+		},
+		{
+			name:     "azure client secret without a client ID",
+			provider: newAzureServicePrincipalProvider("", "tenant-456", "", secret),
+			wantErr:  "ARM_CLIENT_ID must not be empty",
+		},
+		{
+			name:     "azure client secret without the secret",
+			provider: newAzureServicePrincipalProvider("client-123", "tenant-456", "", nil),
+			wantErr:  "ARM_CLIENT_SECRET must not be empty",
+		},
+		{
+			name:     "azure client certificate",
+			provider: newAzureClientCertificateProvider("client-123", "tenant-456", "", secret, nil),
+			wantUsage: "azure-client-certificate (env: ARM_CLIENT_ID, ARM_TENANT_ID, ARM_CLIENT_CERTIFICATE_PATH; " +
+				"files: /run/secrets/azure/client-certificate.pfx)",
+		},
+		{
+			name:     "azure client certificate without the certificate",
+			provider: newAzureClientCertificateProvider("client-123", "tenant-456", "", nil, secret),
+			wantErr:  "credential file /run/secrets/azure/client-certificate.pfx must not be empty",
+		},
+		{
+			name:     "azure oidc",
+			provider: newAzureOIDCProvider("client-123", "tenant-456", "sub-789", secret),
+			wantUsage: "azure-oidc (env: ARM_CLIENT_ID, ARM_TENANT_ID, ARM_SUBSCRIPTION_ID, ARM_USE_OIDC, " +
+				"ARM_OIDC_TOKEN_FILE_PATH; files: /run/secrets/azure/oidc-token)",
+		},
+		{
+			name:     "azure oidc without a tenant ID",
+			provider: newAzureOIDCProvider("client-123", "", "", secret),
+			wantErr:  "ARM_TENANT_ID must not be empty",
+		},
+		{
+			name:     "azure oidc without the token",
+			provider: newAzureOIDCProvider("client-123", "tenant-456", "", nil),
+			wantErr:  "the OIDC token must not be empty",
+		},
+		{
+			name:     "gcp service-account key",
+			provider: newGCPServiceAccountKeyProvider(secret, "my-project", "eu-west-1"),
+			wantUsage: "gcp-service-account-key (env: GOOGLE_PROJECT, GOOGLE_CLOUD_PROJECT, GOOGLE_REGION, " +
+				"GOOGLE_APPLICATION_CREDENTIALS; files: /run/secrets/gcp/credentials.json)",
+		},
+		{
+			name:     "gcp service-account key without the key",
+			provider: newGCPServiceAccountKeyProvider(nil, "my-project", ""),
+			wantErr:  "credential file /run/secrets/gcp/credentials.json must not be empty",
+		},
+		{
+			name:     "gcp workload identity federation",
+			provider: gcpFederation,
+			wantUsage: "gcp-workload-identity-federation (env: GOOGLE_PROJECT, GOOGLE_CLOUD_PROJECT, " +
+				"GOOGLE_APPLICATION_CREDENTIALS; files: /run/secrets/gcp/oidc-token, /root/.config/gcloud/external-account.json)",
+		},
+		{
+			name:     "gcp workload identity federation without the token",
+			provider: gcpFederationWithoutToken,
+			wantErr:  "the OIDC token must not be empty",
+		},
+		{
+			name: "shared config",
+			provider: &configFileCredentialProvider{
+				name:  "aws-shared-config",
+				files: []credentialFile{{path: configAWSConfigFilePath, secret: secret, envVar: "AWS_CONFIG_FILE"}},
+			},
+			wantUsage: "aws-shared-config (env: AWS_CONFIG_FILE; files: /run/secrets/aws/config)",
+		},
+		{
+			name:     "shared config without a file",
+			provider: &configFileCredentialProvider{name: "aws-shared-config"},
+			wantErr:  "at least one credential file is required",
+		},
+		{
+			name: "shared config with a relative path",
+			provider: &configFileCredentialProvider{
+				name:  "aws-shared-config",
+				files: []credentialFile{{path: "run/secrets/aws/config", secret: secret}},
+			},
+			wantErr: `credential file path "run/secrets/aws/config" must be absolute`,
+		},
+		{
+			name: "env passthrough",
+			provider: &envPassthroughCredentialProvider{
+				name:    "env-passthrough",
+				envVars: []credentialEnvVar{{name: "VAULT_TOKEN", secret: secret, required: true}},
+			},
+			wantUsage: "env-passthrough (env: VAULT_TOKEN; files: none)",
+		},
+		{
+			name:     "env passthrough without a variable",
+			provider: &envPassthroughCredentialProvider{name: "env-passthrough"},
+			wantErr:  "at least one environment variable is required",
+		},
+		{
+			name: "env passthrough without a value",
+			provider: &envPassthroughCredentialProvider{
+				name:    "env-passthrough",
+				envVars: []credentialEnvVar{{name: "VAULT_TOKEN", required: true}},
+			},
+			wantErr: "VAULT_TOKEN must not be empty",
+		},
+		{
+			name: "env passthrough with an invalid name",
+			provider: &envPassthroughCredentialProvider{
+				name:    "env-passthrough",
+				envVars: []credentialEnvVar{{name: "VAULT-TOKEN", secret: secret, required: true}},
+			},
+			wantErr: `invalid environment variable name "VAULT-TOKEN"`,
+		},
+		{
+			name: "env passthrough with a duplicate name",
+			provider: &envPassthroughCredentialProvider{
+				name: "env-passthrough",
+				envVars: []credentialEnvVar{
+					{name: "VAULT_TOKEN", secret: secret, required: true},
+					{name: "VAULT_TOKEN", secret: secret, required: true},
+				},
+			},
+			wantErr: "environment variable VAULT_TOKEN is set more than once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.provider.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+
+			usage := tt.provider.Usage().String()
+			if usage != tt.wantUsage {
+				t.Errorf("Usage() = %q, want %q", usage, tt.wantUsage)
+			}
+
+			for _, value := range testCredentialValues {
+				if strings.Contains(usage, value) {
+					t.Errorf("Usage() = %q reveals the value %q", usage, value)
+				}
+			}
+		})
+	}
+}
+
+// TestFormatCredentialsInUse verifies the report section listing the credentials in use.
+func TestFormatCredentialsInUse(t *testing.T) {
+	t.Parallel()
+
+	secret := &dagger.Secret{}
+
+	tests := []struct {
+		name      string
+		providers []CredentialProvider
+		want      string
+	}{
+		{
+			name: "no credentials",
+		},
+		{
+			name: "several providers",
+			providers: []CredentialProvider{
+				newAWSStaticKeysProvider(secret, secret, "eu-west-1", secret),
+				newGCPServiceAccountKeyProvider(secret, "my-project", ""),
+			},
+			want: "Credentials in use:\n" +
+				"  aws-static-keys (env: AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN; files: none)\n" +
+				"  gcp-service-account-key (env: GOOGLE_PROJECT, GOOGLE_CLOUD_PROJECT, GOOGLE_APPLICATION_CREDENTIALS; " +
+				"files: /run/secrets/gcp/credentials.json)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var credentials []*CredentialUsage
+
+			for _, provider := range tt.providers {
+				usage := provider.Usage()
+				credentials = append(credentials, &usage)
+			}
+
+			got := formatCredentialsInUse(credentials)
+			if got != tt.want {
+				t.Errorf("formatCredentialsInUse() = %q, want %q", got, tt.want)
+			}
+
+			for _, value := range testCredentialValues {
+				if strings.Contains(got, value) {
+					t.Errorf("formatCredentialsInUse() = %q reveals the value %q", got, value)
+				}
+			}
+		})
+	}
+}
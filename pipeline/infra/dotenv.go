@@ -0,0 +1,461 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// configExamplesRootPath is the directory holding the examples of every module.
+const configExamplesRootPath = "examples"
+
+// DotEnvSource records which dotenv file set a variable. The value is never recorded.
+type DotEnvSource struct {
+	// Key is the variable name.
+	Key string
+	// File is the dotenv file that set the variable, relative to the source directory.
+	File string
+	// Line is the line of the assignment in File.
+	Line int
+	// Secret reports whether the variable was set as a secret.
+	Secret bool
+}
+
+// dotEnvEntry is an assignment parsed from a dotenv file.
+type dotEnvEntry struct {
+	key   string
+	value string
+	file  string
+	line  int
+	// refs are the variables interpolated into the value.
+	refs []string
+	// encrypted reports whether the entry comes from a SOPS-encrypted file.
+	encrypted bool
+}
+
+// dotEnvParser parses the contents of a dotenv file. It supports:
+//   - 'export' prefixes;
+//   - single-quoted values, taken literally, which can span several lines;
+//   - double-quoted values, which can span several lines, with the \n, \r, \t, \", \\ and \$
+//     escape sequences;
+//   - unquoted values, where a '#' preceded by a space starts an inline comment;
+//   - ${VAR}, ${VAR:-default}, ${VAR-default} and $VAR interpolation, in double-quoted and
+//     unquoted values.
+type dotEnvParser struct {
+	file   string
+	input  []rune
+	pos    int
+	line   int
+	lookup func(name string) (string, bool)
+	refs   []string
+}
+
+// parseDotEnv parses the contents of a dotenv file. Interpolated variables are resolved with
+// lookup, which sees the variables assigned earlier in the same file.
+func parseDotEnv(file, contents string, lookup func(name string) (string, bool)) ([]dotEnvEntry, error) {
+	values := map[string]string{}
+
+	p := &dotEnvParser{
+		file:  file,
+		input: []rune(strings.ReplaceAll(contents, "\r\n", "\n")),
+		line:  1,
+		lookup: func(name string) (string, bool) {
+			if value, ok := values[name]; ok {
+				return value, true
+			}
+
+			return lookup(name)
+		},
+	}
+
+	var entries []dotEnvEntry
+
+	for {
+		p.skipBlank()
+
+		if p.eof() {
+			return entries, nil
+		}
+
+		if p.peek() == '#' {
+			p.skipLine()
+
+			continue
+		}
+
+		entry, err := p.parseAssignment()
+		if err != nil {
+			return nil, err
+		}
+
+		values[entry.key] = entry.value
+		entries = append(entries, entry)
+	}
+}
+
+func (p *dotEnvParser) errorf(format string, args ...any) error {
+	return Errorf("%s:%d: %s", p.file, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *dotEnvParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *dotEnvParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *dotEnvParser) next() rune {
+	r := p.input[p.pos]
+	p.pos++
+
+	if r == '\n' {
+		p.line++
+	}
+
+	return r
+}
+
+// skipBlank skips whitespace, including line breaks.
+func (p *dotEnvParser) skipBlank() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.next()
+	}
+}
+
+// skipSpaces skips spaces and tabs, up to the end of the line.
+func (p *dotEnvParser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.next()
+	}
+}
+
+func (p *dotEnvParser) skipLine() {
+	for !p.eof() && p.peek() != '\n' {
+		p.next()
+	}
+}
+
+// isDotEnvKeyRune reports whether a rune can be part of a variable name. Dots are allowed in
+// assigned names, but not in references, where they usually follow the name ("$NAME.txt").
+func isDotEnvKeyRune(r rune, first, allowDot bool) bool {
+	if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+		return true
+	}
+
+	return !first && ((allowDot && r == '.') || (r >= '0' && r <= '9'))
+}
+
+func (p *dotEnvParser) readKey(allowDot bool) string {
+	start := p.pos
+
+	for !p.eof() && isDotEnvKeyRune(p.peek(), p.pos == start, allowDot) {
+		p.next()
+	}
+
+	return string(p.input[start:p.pos])
+}
+
+func (p *dotEnvParser) parseAssignment() (dotEnvEntry, error) {
+	line := p.line
+	key := p.readKey(true)
+
+	if key == "export" && (p.peek() == ' ' || p.peek() == '\t') {
+		p.skipSpaces()
+		key = p.readKey(true)
+	}
+
+	if key == "" {
+		return dotEnvEntry{}, p.errorf("invalid variable name starting with %q", p.peek())
+	}
+
+	p.skipSpaces()
+
+	if p.peek() != '=' {
+		return dotEnvEntry{}, p.errorf("expected '=' after %q", key)
+	}
+
+	p.next()
+	p.skipSpaces()
+
+	p.refs = nil
+
+	var (
+		value string
+		err   error
+	)
+
+	switch p.peek() {
+	case '\'':
+		value, err = p.readSingleQuoted()
+	case '"':
+		value, err = p.readDoubleQuoted()
+	default:
+		value, err = p.readUnquoted()
+	}
+
+	if err != nil {
+		return dotEnvEntry{}, err
+	}
+
+	return dotEnvEntry{key: key, value: value, file: p.file, line: line, refs: p.refs}, nil
+}
+
+// readSingleQuoted reads a literal value, up to the closing quote.
+func (p *dotEnvParser) readSingleQuoted() (string, error) {
+	line := p.line
+	p.next()
+
+	var value strings.Builder
+
+	for {
+		if p.eof() {
+			return "", Errorf("%s:%d: unterminated single-quoted value", p.file, line)
+		}
+
+		r := p.next()
+		if r == '\'' {
+			return value.String(), p.endOfValue()
+		}
+
+		value.WriteRune(r)
+	}
+}
+
+// readDoubleQuoted reads a value with escape sequences and interpolation, up to the closing quote.
+func (p *dotEnvParser) readDoubleQuoted() (string, error) {
+	line := p.line
+	p.next()
+
+	var value strings.Builder
+
+	for {
+		if p.eof() {
+			return "", Errorf("%s:%d: unterminated double-quoted value", p.file, line)
+		}
+
+		r := p.next()
+
+		switch r {
+		case '"':
+			return value.String(), p.endOfValue()
+		case '\\':
+			if p.eof() {
+				continue
+			}
+
+			escaped := p.next()
+
+			switch escaped {
+			case 'n':
+				value.WriteRune('\n')
+			case 'r':
+				value.WriteRune('\r')
+			case 't':
+				value.WriteRune('\t')
+			case '"', '\\', '$':
+				value.WriteRune(escaped)
+			default:
+				value.WriteRune('\\')
+				value.WriteRune(escaped)
+			}
+		case '$':
+			expanded, err := p.readReference()
+			if err != nil {
+				return "", err
+			}
+
+			value.WriteString(expanded)
+		default:
+			value.WriteRune(r)
+		}
+	}
+}
+
+// readUnquoted reads a value up to the end of the line or an inline comment.
+func (p *dotEnvParser) readUnquoted() (string, error) {
+	var value strings.Builder
+
+	for !p.eof() && p.peek() != '\n' {
+		if p.peek() == '#' && (value.Len() == 0 || strings.HasSuffix(value.String(), " ") ||
+			strings.HasSuffix(value.String(), "\t")) {
+			p.skipLine()
+
+			break
+		}
+
+		r := p.next()
+		if r != '$' {
+			value.WriteRune(r)
+
+			continue
+		}
+
+		expanded, err := p.readReference()
+		if err != nil {
+			return "", err
+		}
+
+		value.WriteString(expanded)
+	}
+
+	return strings.TrimSpace(value.String()), nil
+}
+
+// endOfValue checks that only whitespace or a comment follows a quoted value.
+func (p *dotEnvParser) endOfValue() error {
+	p.skipSpaces()
+
+	switch p.peek() {
+	case 0, '\n':
+		return nil
+	case '#':
+		p.skipLine()
+
+		return nil
+	default:
+		return p.errorf("unexpected %q after the closing quote", p.peek())
+	}
+}
+
+// readReference reads a variable reference following a '$' and returns its value. A '$' that
+// does not start a reference is kept as is.
+func (p *dotEnvParser) readReference() (string, error) {
+	if p.peek() != '{' {
+		name := p.readKey(false)
+		if name == "" {
+			return "$", nil
+		}
+
+		value, _ := p.resolve(name)
+
+		return value, nil
+	}
+
+	line := p.line
+	p.next()
+
+	name := p.readKey(false)
+	if name == "" {
+		return "", p.errorf("invalid variable reference")
+	}
+
+	var (
+		fallback    string
+		hasFallback bool
+		ifEmpty     bool
+	)
+
+	if strings.HasPrefix(string(p.input[p.pos:]), ":-") {
+		p.pos += 2
+		hasFallback, ifEmpty = true, true
+	} else if p.peek() == '-' {
+		p.pos++
+		hasFallback = true
+	}
+
+	if hasFallback {
+		start := p.pos
+
+		for !p.eof() && p.peek() != '}' {
+			p.next()
+		}
+
+		fallback = string(p.input[start:p.pos])
+	}
+
+	if p.eof() || p.peek() != '}' {
+		return "", Errorf("%s:%d: unterminated reference to %q", p.file, line, name)
+	}
+
+	p.next()
+
+	value, ok := p.resolve(name)
+
+	switch {
+	case hasFallback && ifEmpty && value == "":
+		return fallback, nil
+	case hasFallback && !ok:
+		return fallback, nil
+	default:
+		return value, nil
+	}
+}
+
+func (p *dotEnvParser) resolve(name string) (string, bool) {
+	p.refs = append(p.refs, name)
+
+	return p.lookup(name)
+}
+
+// getDotEnvLayers returns the directories searched for dotenv files, from the lowest to the
+// highest precedence: the repository root, the module directory, then the example directory.
+func getDotEnvLayers(moduleDir, exampleDir string) []string {
+	layers := []string{"."}
+
+	for _, dir := range []string{moduleDir, exampleDir} {
+		dir = filepath.Clean(dir)
+		if dir == "." || dir == layers[len(layers)-1] {
+			continue
+		}
+
+		layers = append(layers, dir)
+	}
+
+	return layers
+}
+
+// formatDotEnvSources lists which file set each variable, without the values.
+func formatDotEnvSources(sources []*DotEnvSource) string {
+	if len(sources) == 0 {
+		return "no variable set from dotenv files"
+	}
+
+	sorted := append([]*DotEnvSource(nil), sources...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	var builder strings.Builder
+
+	builder.WriteString("dotenv variables (values hidden):\n")
+
+	for _, source := range sorted {
+		kind := "env"
+		if source.Secret {
+			kind = "secret"
+		}
+
+		builder.WriteString(fmt.Sprintf("  %s <- %s:%d (%s)\n", source.Key, source.File, source.Line, kind))
+	}
+
+	return builder.String()
+}
+
+// recordDotEnvSource records the file that set a variable, replacing any earlier record.
+func (m *Infra) recordDotEnvSource(source *DotEnvSource) {
+	for i, existing := range m.DotEnvSources {
+		if existing.Key == source.Key {
+			m.DotEnvSources[i] = source
+
+			return
+		}
+	}
+
+	m.DotEnvSources = append(m.DotEnvSources, source)
+}
+
+// DotEnvReport lists which dotenv file set each variable loaded with WithDotEnvFile. Values are
+// not shown.
+//
+// Returns:
+//   - string: One line per variable, with the file and line that set it
+func (m *Infra) DotEnvReport() string {
+	return formatDotEnvSources(m.DotEnvSources)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseDotEnv verifies the quoting, escaping, multi-line and interpolation rules of the
+// dotenv parser.
+func TestParseDotEnv(t *testing.T) {
+	t.Parallel()
+
+	environment := map[string]string{"HOME": "/root", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := environment[name]
+
+		return value, ok
+	}
+
+	tests := []struct {
+		name     string
+		contents string
+		want     map[string]string
+		wantRefs map[string][]string
+	}{
+		{
+			name:     "unquoted with export prefix and inline comment",
+			contents: "export REGION=eu-west-1 # primary region\nCOLOR=red#blue\n",
+			want:     map[string]string{"REGION": "eu-west-1", "COLOR": "red#blue"},
+		},
+		{
+			name:     "single quotes are literal",
+			contents: `PATTERN='${HOME}\n$1'`,
+			want:     map[string]string{"PATTERN": `${HOME}\n$1`},
+		},
+		{
+			name:     "double quotes expand escapes",
+			contents: `MESSAGE="tab\there \"quoted\" \\ \$HOME"`,
+			want:     map[string]string{"MESSAGE": "tab\there \"quoted\" \\ $HOME"},
+		},
+		{
+			name:     "multi-line values",
+			contents: "KEY=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\nCERT='line 1\nline 2'\n",
+			want:     map[string]string{"KEY": "-----BEGIN KEY-----\nabc\n-----END KEY-----", "CERT": "line 1\nline 2"},
+		},
+		{
+			name:     "interpolation of earlier and environment variables",
+			contents: "BASE=/srv\nDATA=${BASE}/data\nCACHE=$HOME/.cache\n",
+			want:     map[string]string{"BASE": "/srv", "DATA": "/srv/data", "CACHE": "/root/.cache"},
+			wantRefs: map[string][]string{"DATA": {"BASE"}, "CACHE": {"HOME"}},
+		},
+		{
+			name:     "defaults",
+			contents: "A=${UNSET:-fallback}\nB=${EMPTY:-fallback}\nC=${EMPTY-fallback}\nD=${UNSET-fallback}\n",
+			want:     map[string]string{"A": "fallback", "B": "fallback", "C": "", "D": "fallback"},
+		},
+		{
+			name:     "lone dollar and CRLF line endings",
+			contents: "PRICE=5$\r\nNEXT=ok\r\n",
+			want:     map[string]string{"PRICE": "5$", "NEXT": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			entries, err := parseDotEnv(".env", tt.contents, lookup)
+			if err != nil {
+				t.Fatalf("parseDotEnv() error = %v", err)
+			}
+
+			got := map[string]string{}
+			for _, entry := range entries {
+				got[entry.key] = entry.value
+
+				if wantRefs, ok := tt.wantRefs[entry.key]; ok && !reflect.DeepEqual(entry.refs, wantRefs) {
+					t.Errorf("refs of %s = %v, want %v", entry.key, entry.refs, wantRefs)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDotEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseDotEnvErrors verifies that malformed dotenv files are rejected with their line.
+func TestParseDotEnvErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{name: "unterminated double quote", contents: "A=\"open\n"},
+		{name: "unterminated single quote", contents: "A='open\n"},
+		{name: "text after the closing quote", contents: "A=\"value\" trailing\n"},
+		{name: "unterminated reference", contents: "A=${B\n"},
+		{name: "empty reference", contents: "A=${}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parseDotEnv(".env", tt.contents, func(string) (string, bool) { return "", false }); err == nil {
+				t.Errorf("parseDotEnv(%q) error = nil, want an error", tt.contents)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"dagger/infra/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Paths of the GCP credentials mounted into the container
+	configGCPCredentialsPath           = "/run/secrets/gcp/credentials.json"
+	configGCPOIDCTokenPath             = "/run/secrets/gcp/oidc-token"
+	configGCPExternalAccountConfigPath = "/root/.config/gcloud/external-account.json"
+	// Endpoints used by workload identity federation
+	gcpSTSTokenURL                = "https://sts.googleapis.com/v1/token"
+	gcpServiceAccountImpersonator = "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/%s:generateAccessToken"
+	gcpJWTSubjectTokenType        = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// gcpExternalAccountConfig is the credential configuration of a workload identity federation
+// ('external_account') identity, as read by the Google client libraries and the google provider.
+type gcpExternalAccountConfig struct {
+	Type                           string                      `json:"type"`
+	Audience                       string                      `json:"audience"`
+	SubjectTokenType               string                      `json:"subject_token_type"`
+	TokenURL                       string                      `json:"token_url"`
+	ServiceAccountImpersonationURL string                      `json:"service_account_impersonation_url,omitempty"`
+	CredentialSource               gcpExternalCredentialSource `json:"credential_source"`
+}
+
+// gcpExternalCredentialSource points to the file holding the OIDC token exchanged by the STS.
+type gcpExternalCredentialSource struct {
+	File string `json:"file"`
+}
+
+// gcpCredentialProviderNames are the names of the GCP credential providers, of which only one can
+// be applied.
+var gcpCredentialProviderNames = []string{"gcp-service-account-key", "gcp-workload-identity-federation"}
+
+// applyGCPCredentialProvider applies a GCP credential provider, unless another GCP authentication
+// method is already configured: both set GOOGLE_APPLICATION_CREDENTIALS, so the last one would
+// silently win.
+func (m *Infra) applyGCPCredentialProvider(provider CredentialProvider) (*Infra, error) {
+	for _, name := range gcpCredentialProviderNames {
+		if name != provider.Name() && m.isCredentialInUse(name) {
+			return nil, Errorf("GCP credentials are already configured with %s, use only one of "+
+				"WithGCPServiceAccountKey and WithGCPWorkloadIdentityFederation", name)
+		}
+	}
+
+	return m.applyCredentialProviders(provider)
+}
+
+// newGCPCredentialProvider returns the GCP credential provider selected by the GCP inputs of
+// JobTerraform: a service-account key or workload identity federation. It returns nil when
+// neither is given, and an error when both are.
+func newGCPCredentialProvider(
+	credentials *dagger.Secret,
+	workloadIdentityProvider, serviceAccountEmail string,
+	oidcToken *dagger.Secret,
+	project, region string,
+) (CredentialProvider, error) {
+	usesFederation := workloadIdentityProvider != "" || oidcToken != nil
+
+	switch {
+	case credentials != nil && usesFederation:
+		return nil, Errorf("use either a GCP service-account key or workload identity federation, not both")
+	case credentials != nil:
+		return newGCPServiceAccountKeyProvider(credentials, project, region), nil
+	case usesFederation:
+		return newGCPWorkloadIdentityFederationProvider(workloadIdentityProvider, oidcToken, serviceAccountEmail, project, region)
+	default:
+		return nil, nil
+	}
+}
+
+// getGCPProjectAndRegionEnvVars returns the default project and region read by the google provider.
+func getGCPProjectAndRegionEnvVars(project, region string) []credentialEnvVar {
+	return []credentialEnvVar{
+		{name: "GOOGLE_PROJECT", value: project},
+		{name: "GOOGLE_CLOUD_PROJECT", value: project},
+		{name: "GOOGLE_REGION", value: region},
+	}
+}
+
+// WithGCPServiceAccountKey authenticates the google provider with a service-account JSON key.
+//
+// The key is mounted as a secret file referenced by GOOGLE_APPLICATION_CREDENTIALS, so it never
+// enters the layer cache. GOOGLE_PROJECT and GOOGLE_REGION are set when provided.
+//
+// Parameters:
+//   - credentials: The service-account JSON key (e.g. loaded with 'file:./sa.json')
+//   - project: The default GCP project (optional)
+//   - region: The default GCP region (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the GCP credentials set
+//   - error: An error if the key is missing, or workload identity federation is configured
+func (m *Infra) WithGCPServiceAccountKey(
+	// credentials is the service-account JSON key.
+	credentials *dagger.Secret,
+	// project is the default GCP project.
+	// +optional
+	project string,
+	// region is the default GCP region.
+	// +optional
+	region string,
+) (*Infra, error) {
+	return m.applyGCPCredentialProvider(newGCPServiceAccountKeyProvider(credentials, project, region))
+}
+
+// newGCPServiceAccountKeyProvider returns the provider behind WithGCPServiceAccountKey.
+func newGCPServiceAccountKeyProvider(credentials *dagger.Secret, project, region string) CredentialProvider {
+	return &configFileCredentialProvider{
+		name: "gcp-service-account-key",
+		files: []credentialFile{
+			{path: configGCPCredentialsPath, secret: credentials, envVar: "GOOGLE_APPLICATION_CREDENTIALS"},
+		},
+		envVars: getGCPProjectAndRegionEnvVars(project, region),
+	}
+}
+
+// WithGCPWorkloadIdentityFederation authenticates the google provider with workload identity
+// federation, the GCP counterpart of WithAWSOIDC.
+//
+// The OIDC token (e.g. issued by GitHub Actions) is mounted as a secret file, and an
+// 'external_account' credential configuration referencing it is generated and set as
+// GOOGLE_APPLICATION_CREDENTIALS. When a service account is given, it is impersonated.
+//
+// Parameters:
+//   - workloadIdentityProvider: The full provider resource name, e.g.
+//     "projects/123/locations/global/workloadIdentityPools/ci/providers/github"
+//   - oidcToken: The OIDC token exchanged for a GCP access token
+//   - serviceAccountEmail: The service account to impersonate (optional)
+//   - project: The default GCP project (optional)
+//   - region: The default GCP region (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the GCP credentials set
+//   - error: An error if a required value is missing, or a service-account key is configured
+func (m *Infra) WithGCPWorkloadIdentityFederation(
+	// workloadIdentityProvider is the full provider resource name,
+	// e.g. "projects/123/locations/global/workloadIdentityPools/ci/providers/github".
+	workloadIdentityProvider string,
+	// oidcToken is the OIDC token exchanged for a GCP access token.
+	oidcToken *dagger.Secret,
+	// serviceAccountEmail is the service account to impersonate.
+	// +optional
+	serviceAccountEmail string,
+	// project is the default GCP project.
+	// +optional
+	project string,
+	// region is the default GCP region.
+	// +optional
+	region string,
+) (*Infra, error) {
+	provider, err := newGCPWorkloadIdentityFederationProvider(workloadIdentityProvider, oidcToken, serviceAccountEmail, project, region)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.applyGCPCredentialProvider(provider)
+}
+
+// newGCPWorkloadIdentityFederationProvider returns the provider behind WithGCPWorkloadIdentityFederation.
+func newGCPWorkloadIdentityFederationProvider(
+	workloadIdentityProvider string,
+	oidcToken *dagger.Secret,
+	serviceAccountEmail, project, region string,
+) (CredentialProvider, error) {
+	audience, err := getGCPWorkloadIdentityAudience(workloadIdentityProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	config := gcpExternalAccountConfig{
+		Type:             "external_account",
+		Audience:         audience,
+		SubjectTokenType: gcpJWTSubjectTokenType,
+		TokenURL:         gcpSTSTokenURL,
+		CredentialSource: gcpExternalCredentialSource{File: configGCPOIDCTokenPath},
+	}
+
+	if serviceAccountEmail != "" {
+		config.ServiceAccountImpersonationURL = fmt.Sprintf(gcpServiceAccountImpersonator, serviceAccountEmail)
+	}
+
+	configJSON, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to generate the GCP external account configuration")
+	}
+
+	return &webIdentityCredentialProvider{
+		name:      "gcp-workload-identity-federation",
+		token:     oidcToken,
+		tokenPath: configGCPOIDCTokenPath,
+		files: []credentialFile{
+			{path: configGCPExternalAccountConfigPath, contents: string(configJSON), envVar: "GOOGLE_APPLICATION_CREDENTIALS"},
+		},
+		envVars: getGCPProjectAndRegionEnvVars(project, region),
+	}, nil
+}
+
+// getGCPWorkloadIdentityAudience returns the STS audience of a workload identity provider. Both
+// the resource name ("projects/...") and the full audience ("//iam.googleapis.com/projects/...")
+// are accepted.
+func getGCPWorkloadIdentityAudience(workloadIdentityProvider string) (string, error) {
+	provider := strings.TrimPrefix(strings.TrimSpace(workloadIdentityProvider), "//iam.googleapis.com/")
+
+	if !strings.HasPrefix(provider, "projects/") || !strings.Contains(provider, "/workloadIdentityPools/") ||
+		!strings.Contains(provider, "/providers/") {
+		return "", Errorf("invalid workload identity provider %q, expected "+
+			"'projects/<number>/locations/global/workloadIdentityPools/<pool>/providers/<provider>'", workloadIdentityProvider)
+	}
+
+	return "//iam.googleapis.com/" + provider, nil
+}
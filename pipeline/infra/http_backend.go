@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// HTTP state backend service
+	httpBackendServerName       = "httpbackend"
+	httpBackendHostname         = "tfstate"
+	httpBackendPort             = 8080
+	httpBackendDataPath         = "/var/lib/tfstate"
+	defaultHTTPBackendCache     = "terraform-http-backend"
+	defaultHTTPBackendState     = "default"
+	httpBackendVerificationLock = "pipeline-verification"
+)
+
+//go:embed services/httpbackend/main.go
+var httpBackendSource string
+
+// httpBackendStatePattern matches the state names accepted by the HTTP backend service.
+var httpBackendStatePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// HTTPBackendService returns a Terraform HTTP state backend, with locking.
+//
+// The server implements the protocol of Terraform's "http" backend at http://<host>:8080/state/<name>
+// (GET, POST and DELETE for the state, LOCK and UNLOCK for its lock), and stores states and locks
+// in a cache volume, so they survive across runs.
+//
+// Parameters:
+//   - cacheVolume: The name of the cache volume holding the states (defaults to "terraform-http-backend")
+//   - username: The basic authentication username (optional)
+//   - password: The basic authentication password (optional, required with username)
+//
+// Returns:
+//   - *dagger.Service: The HTTP backend as a Dagger service
+func (m *Infra) HTTPBackendService(
+	// cacheVolume is the name of the cache volume holding the states.
+	// +optional
+	cacheVolume string,
+	// username is the basic authentication username.
+	// +optional
+	username string,
+	// password is the basic authentication password.
+	// +optional
+	password *dagger.Secret,
+) *dagger.Service {
+	if cacheVolume == "" {
+		cacheVolume = defaultHTTPBackendCache
+	}
+
+	ctr := buildGoServiceContainer(httpBackendServerName, httpBackendSource).
+		WithMountedCache(httpBackendDataPath, dag.CacheVolume(cacheVolume)).
+		WithEnvVariable("DATA_DIR", httpBackendDataPath).
+		WithEnvVariable("PORT", strconv.Itoa(httpBackendPort))
+
+	if username != "" {
+		ctr = ctr.WithEnvVariable("HTTP_BACKEND_USERNAME", username)
+	}
+
+	if password != nil {
+		ctr = ctr.WithSecretVariable("HTTP_BACKEND_PASSWORD", password)
+	}
+
+	return ctr.
+		WithExposedPort(httpBackendPort).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true})
+}
+
+// withHTTPBackendService binds an HTTP backend service to the container, and configures the
+// backend to use one of its states.
+func (m *Infra) withHTTPBackendService(
+	ctx context.Context,
+	service *dagger.Service,
+	stateName, username string,
+	password *dagger.Secret,
+) (*Infra, error) {
+	if stateName == "" {
+		stateName = defaultHTTPBackendState
+	}
+
+	if !httpBackendStatePattern.MatchString(stateName) {
+		return nil, Errorf("invalid state name %q, expected slash-separated segments that do not start with a dot", stateName)
+	}
+
+	if (username == "") != (password == nil) {
+		return nil, NewError("the HTTP backend username and password must be set together")
+	}
+
+	address := fmt.Sprintf("http://%s:%d/state/%s", httpBackendHostname, httpBackendPort, stateName)
+
+	config := []string{
+		"address=" + address,
+		"lock_address=" + address,
+		"unlock_address=" + address,
+	}
+
+	var secretKeys []string
+
+	var secretValues []*dagger.Secret
+
+	if username != "" {
+		config = append(config, "username="+username)
+		secretKeys = append(secretKeys, "password")
+		secretValues = append(secretValues, password)
+	}
+
+	m.Ctr = m.Ctr.WithServiceBinding(httpBackendHostname, service)
+
+	return m.WithBackend(ctx, "http", config, nil, nil, secretKeys, secretValues)
+}
+
+// WithHTTPBackend binds the HTTP backend service (HTTPBackendService) to the container, and
+// configures it as the backend of the actions that run against remote state.
+//
+// The backend type is set to "http", so modules do not need a backend block: a generated override
+// file declares it. Pass --use-backend to the build to plan against it.
+//
+// Parameters:
+//   - ctx: The context for the Dagger operations
+//   - stateName: The name of the state, e.g. "default" or "team/network" (defaults to "default")
+//   - cacheVolume: The name of the cache volume holding the states (optional)
+//   - username: The basic authentication username (optional)
+//   - password: The basic authentication password (optional, required with username)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the backend configured
+//   - error: An error if the state name is invalid, or only one of username and password is set
+func (m *Infra) WithHTTPBackend(
+	// ctx is the context for the Dagger operations.
+	// +optional
+	ctx context.Context,
+	// stateName is the name of the state, e.g. "team/network".
+	// +optional
+	stateName string,
+	// cacheVolume is the name of the cache volume holding the states.
+	// +optional
+	cacheVolume string,
+	// username is the basic authentication username.
+	// +optional
+	username string,
+	// password is the basic authentication password.
+	// +optional
+	password *dagger.Secret,
+) (*Infra, error) {
+	return m.withHTTPBackendService(ctx, m.HTTPBackendService(cacheVolume, username, password), stateName, username, password)
+}
+
+// ActionTerraformHTTPBackendVerification verifies the HTTP backend service, and its locking.
+//
+// It runs a provider-less configuration through apply, plan and destroy against a fresh state of
+// HTTPBackendService. Between apply and plan, the state is locked from another container: the plan
+// must fail to acquire the lock, then succeed, with no changes, once the lock is released.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//
+// Returns:
+//   - string: The report of the verification
+//   - error: An error if a step fails, or the plan succeeds while the state is locked
+func (m *Infra) ActionTerraformHTTPBackendVerification(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	// The server is started explicitly so the Terraform and lock containers share it.
+	httpBackend, err := m.HTTPBackendService(defaultHTTPBackendCache+"-verification", "", nil).Start(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to start the HTTP backend")
+	}
+
+	defer func() {
+		_, _ = httpBackend.Stop(ctx)
+	}()
+
+	// A fresh state per run, so runs never see each other's state or lock.
+	stateName := "verification/" + uuid.New().String()
+
+	// The verification replaces any backend configured before.
+	m.Backend = nil
+
+	verification, err := m.withHTTPBackendService(ctx, httpBackend, stateName, "", nil)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to configure the backend")
+	}
+
+	probeDir := "/tmp/http-backend-verification"
+	probeConfig := fmt.Sprintf("resource \"terraform_data\" \"verification\" {\n  input = %q\n}\n", stateName)
+
+	probeCtr := verification.withBackendOverride(verification.Ctr.
+		WithNewFile(probeDir+"/main.tf", probeConfig).
+		WithWorkdir(probeDir))
+
+	probeCtr, err = verification.runActionCMDs(ctx, probeCtr,
+		verification.getTerraformInitCmd(true),
+		DaggerCMD{"terraform", "apply", "-input=false", "-auto-approve"},
+	)
+	if err != nil {
+		return "", WrapErrorf(err, "HTTP backend verification failed")
+	}
+
+	stateURL := fmt.Sprintf("http://%s:%d/state/%s", httpBackendHostname, httpBackendPort, stateName)
+	lockInfo := fmt.Sprintf(`{"ID":%q,"Operation":"verification","Who":"pipeline"}`, httpBackendVerificationLock)
+
+	lockCtr := dag.Container().
+		From("alpine:latest").
+		WithExec([]string{"apk", "add", "--no-cache", "curl"}).
+		WithServiceBinding(httpBackendHostname, httpBackend)
+
+	if _, err := lockCtr.WithExec([]string{"curl", "-fsS", "-X", "LOCK", "--data", lockInfo, stateURL}).Sync(ctx); err != nil {
+		return "", WrapErrorf(err, "failed to lock the state")
+	}
+
+	lockedOutput, err := probeCtr.
+		WithExec([]string{"terraform", "plan", "-input=false"}, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeFailure,
+		}).
+		Stderr(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "the plan succeeded while the state was locked")
+	}
+
+	if !strings.Contains(lockedOutput, httpBackendVerificationLock) {
+		return "", Errorf("the plan failed while the state was locked, but not on the lock: %s", lockedOutput)
+	}
+
+	if _, err := lockCtr.WithExec([]string{"curl", "-fsS", "-X", "UNLOCK", "--data", lockInfo, stateURL}).Sync(ctx); err != nil {
+		return "", WrapErrorf(err, "failed to unlock the state")
+	}
+
+	probeCtr, err = verification.runActionCMDs(ctx, probeCtr,
+		DaggerCMD{"terraform", "plan", "-input=false", "-detailed-exitcode"},
+		DaggerCMD{"terraform", "destroy", "-input=false", "-auto-approve"},
+	)
+	if err != nil {
+		return "", WrapErrorf(err, "HTTP backend verification failed")
+	}
+
+	return verification.processActionResult(ctx, "http-backend.destroy", probeCtr)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"dagger/infra/internal/dagger"
 	"fmt"
+	"os"
 	"strings"
 )
 
@@ -9,9 +12,11 @@ import (
 // It contains information about the unit that was executed, the output of the action,
 // and any error that may have occurred during execution.
 type JobResult struct {
-	WorkDir string // WorkDir indicates the specific unit of work that was executed.
-	Output  string // Output contains the result or output generated by the action.
-	Err     error  // Err holds any error encountered during the execution of the action.
+	WorkDir     string             // WorkDir indicates the specific unit of work that was executed.
+	Output      string             // Output contains the result or output generated by the action.
+	Err         error              // Err holds any error encountered during the execution of the action.
+	Attempts    []StepAttempt      // Attempts lists every attempt of the steps that were subject to the retry policy.
+	Credentials []*CredentialUsage // Credentials lists the credentials in use, without their values.
 }
 
 // processActionResults collects results from concurrent actions executed in a separate goroutine.
@@ -131,5 +136,90 @@ func (ar JobResult) String() string {
 		commandName = parts[1]
 	}
 
-	return fmt.Sprintf("--- WorkDir: %s ---\nCommand: %s\n%s", tgExecutionPath, commandName, output)
+	return fmt.Sprintf("--- WorkDir: %s ---\nCommand: %s\n%s%s%s", tgExecutionPath, commandName,
+		formatCredentialsInUse(ar.Credentials), formatStepAttempts(ar.Attempts), output)
+}
+
+// formatStepAttempts renders the attempts recorded for retried steps as a report section.
+// It returns an empty string when no attempt was recorded.
+func formatStepAttempts(attempts []StepAttempt) string {
+	if len(attempts) == 0 {
+		return ""
+	}
+
+	var attemptsBuilder strings.Builder
+	attemptsBuilder.WriteString("Attempts:\n")
+
+	for _, attempt := range attempts {
+		attemptsBuilder.WriteString("  " + attempt.String() + "\n")
+	}
+
+	return attemptsBuilder.String()
+}
+
+// processActionResult runs the container produced by an action and formats its output, together
+// with the attempts recorded for retried steps and the credentials in use, as a job report.
+// Registered secret values are redacted from the report and the error.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - workDir: The unit of work, in the "<workdir>.<command>" form used by JobResult
+//   - ctr: The container produced by the action
+//
+// Returns:
+//   - string: The formatted report
+//   - error: An error if the container failed to run
+func (m *Infra) processActionResult(ctx context.Context, workDir string, ctr *dagger.Container) (string, error) {
+	result := m.getActionResult(ctx, workDir, ctr)
+
+	report, err := ProcessActionSyncResults([]JobResult{result})
+
+	return m.redact(ctx, report), m.redactError(ctx, err)
+}
+
+// processActionOutput runs the container produced by a job and returns its raw output, so it can
+// be consumed as is (e.g. 'terraform output -json'). The attempts recorded for retried steps and the
+// credentials in use are written to the Dagger log instead. Registered secret values are redacted
+// from the output, the log and the error.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - workDir: The unit of work, in the "<workdir>.<command>" form used by JobResult
+//   - ctr: The container produced by the job
+//
+// Returns:
+//   - string: The raw output
+//   - error: An error if the container failed to run
+func (m *Infra) processActionOutput(ctx context.Context, workDir string, ctr *dagger.Container) (string, error) {
+	result := m.getActionResult(ctx, workDir, ctr)
+
+	if details := formatCredentialsInUse(result.Credentials) + formatStepAttempts(result.Attempts); details != "" {
+		fmt.Fprintf(os.Stderr, "--- WorkDir: %s ---\n%s", workDir, m.redact(ctx, details))
+	}
+
+	if result.Err != nil {
+		return "", m.redactError(ctx, result.Err)
+	}
+
+	return m.redact(ctx, result.Output), nil
+}
+
+// getActionResult runs the container produced by an action, and collects its output together with
+// the attempts recorded for retried steps and the credentials in use.
+func (m *Infra) getActionResult(ctx context.Context, workDir string, ctr *dagger.Container) JobResult {
+	result := JobResult{WorkDir: workDir, Attempts: m.stepAttempts, Credentials: m.CredentialsInUse}
+
+	output, err := ctr.Stdout(ctx)
+	if err != nil {
+		result.Err = WrapErrorf(err, "failed to get action output on working directory: %s", workDir)
+
+		if ctx.Err() != nil {
+			result.Err = WrapErrorf(err, "action on working directory %s was stopped while collecting its output (action timeout: %ds)",
+				workDir, m.ActionTimeoutSeconds)
+		}
+	}
+
+	result.Output = output
+
+	return result
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// terraformLockFileName is the name of the dependency lock file.
+	terraformLockFileName = ".terraform.lock.hcl"
+)
+
+// defaultLockFilePlatforms are the platforms recorded in lock files when none are given: the
+// platforms of the CI runners and of the maintainers' machines.
+var defaultLockFilePlatforms = []string{"linux_amd64", "linux_arm64", "darwin_amd64", "darwin_arm64"}
+
+// getLockFileDirs returns the directories whose lock files are managed: the modules, and the
+// examples of every module unless skipped. With WithAffectedModules, only the affected modules and
+// examples are managed by default.
+func (m *Infra) getLockFileDirs(ctx context.Context, tfModulePaths []string, skipExamples bool) ([]string, error) {
+	if len(tfModulePaths) == 0 && m.AffectedTargets != nil {
+		var dirs []string
+
+		for _, moduleName := range m.AffectedTargets.Modules {
+			dirs = append(dirs, getTerraformModulesExecutionPath(moduleName))
+		}
+
+		if !skipExamples {
+			dirs = append(dirs, m.AffectedTargets.Examples...)
+		}
+
+		return dirs, nil
+	}
+
+	if len(tfModulePaths) == 0 {
+		moduleNames, err := m.getTerraformModuleNames(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tfModulePaths = moduleNames
+	}
+
+	var dirs []string
+
+	for _, tfModulePath := range tfModulePaths {
+		dirs = append(dirs, getTerraformModulesExecutionPath(tfModulePath))
+
+		if skipExamples {
+			continue
+		}
+
+		exampleFiles, err := m.Src.Glob(ctx, filepath.Join(configExamplesRootPath, tfModulePath, "*", "*.tf"))
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to list the examples of %s", tfModulePath)
+		}
+
+		var exampleDirs []string
+
+		for _, exampleFile := range exampleFiles {
+			if dir := filepath.Dir(exampleFile); !contains(exampleDirs, dir) {
+				exampleDirs = append(exampleDirs, dir)
+			}
+		}
+
+		sort.Strings(exampleDirs)
+		dirs = append(dirs, exampleDirs...)
+	}
+
+	return dirs, nil
+}
+
+// readLockFile returns the lock file of a directory of the source, or "" when it has none.
+func (m *Infra) readLockFile(ctx context.Context, dir string) (string, error) {
+	lockFiles, err := m.Src.Glob(ctx, filepath.Join(dir, terraformLockFileName))
+	if err != nil {
+		return "", WrapErrorf(err, "failed to look up the lock file of %s", dir)
+	}
+
+	if len(lockFiles) == 0 {
+		return "", nil
+	}
+
+	contents, err := m.Src.File(filepath.Join(dir, terraformLockFileName)).Contents(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to read the lock file of %s", dir)
+	}
+
+	return contents, nil
+}
+
+// ActionTerraformProvidersLock generates or refreshes the dependency lock files of modules and
+// their examples with 'terraform providers lock', and returns them as a directory to commit.
+//
+// Every lock file records the checksums of its providers for every platform, so 'terraform init'
+// verifies the providers of the shared plugin cache on any runner or workstation. Locked versions
+// are kept, unless upgrade is set. In check mode, the action fails when a lock file is missing
+// or differs from the one generated (lock drift), e.g. after a provider constraint changed.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - tfModulePaths: The modules to lock (defaults to every module, or the affected ones, see WithAffectedModules)
+//   - platforms: The platforms to record (defaults to linux and darwin, amd64 and arm64)
+//   - skipExamples: Whether to skip the examples of the modules
+//   - upgrade: Whether to upgrade providers to the newest versions allowed by the constraints
+//   - check: Whether to fail on lock drift instead of only returning the lock files
+//
+// Returns:
+//   - *dagger.Directory: The lock files, at their path in the source directory
+//   - error: An error if a platform is invalid, locking fails, or, in check mode, on lock drift
+func (m *Infra) ActionTerraformProvidersLock(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// tfModulePaths are the modules to lock, e.g. "default". Every module when empty.
+	// +optional
+	tfModulePaths []string,
+	// platforms are the platforms to record, e.g. "linux_amd64", "darwin_arm64".
+	// +optional
+	platforms []string,
+	// skipExamples is a flag to skip the examples of the modules.
+	// +optional
+	skipExamples bool,
+	// upgrade is a flag to upgrade providers to the newest versions allowed by the constraints.
+	// +optional
+	upgrade bool,
+	// check is a flag to fail when a lock file is missing or out of date.
+	// +optional
+	check bool,
+) (*dagger.Directory, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	if m.Src == nil {
+		return nil, NewError("failed to lock the providers, the source directory is nil")
+	}
+
+	if len(platforms) == 0 {
+		platforms = defaultLockFilePlatforms
+	}
+
+	lockCmd := DaggerCMD{"terraform", "providers", "lock"}
+
+	for _, platform := range platforms {
+		if !terraformPlatformPattern.MatchString(platform) {
+			return nil, Errorf("invalid platform %q, expected <os>_<arch>, e.g. linux_amd64", platform)
+		}
+
+		lockCmd = append(lockCmd, "-platform="+platform)
+	}
+
+	initCmd := DaggerCMD{"terraform", "init", "-backend=false", "-input=false"}
+	if upgrade {
+		initCmd = append(initCmd, "-upgrade")
+	}
+
+	dirs, err := m.getLockFileDirs(ctx, tfModulePaths, skipExamples)
+	if err != nil {
+		return nil, err
+	}
+
+	lockFiles := dag.Directory()
+
+	var drifted []string
+
+	for _, dir := range dirs {
+		lockCtr := m.Ctr.WithWorkdir(filepath.Join(defaultMntPath, dir))
+
+		lockCtr, err := m.runActionCMDs(ctx, lockCtr, initCmd, lockCmd)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to lock the providers of %s", dir)
+		}
+
+		lockFile := lockCtr.File(terraformLockFileName)
+		lockFiles = lockFiles.WithFile(filepath.Join(dir, terraformLockFileName), lockFile)
+
+		if !check {
+			continue
+		}
+
+		current, err := m.readLockFile(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		generated, err := lockFile.Contents(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the generated lock file of %s", dir)
+		}
+
+		switch {
+		case current == "":
+			drifted = append(drifted, dir+" (missing)")
+		case current != generated:
+			drifted = append(drifted, dir)
+		}
+	}
+
+	if len(drifted) > 0 {
+		return nil, Errorf("lock files out of date, run action-terraform-providers-lock and commit the result: %s",
+			strings.Join(drifted, ", "))
+	}
+
+	return lockFiles, nil
+}
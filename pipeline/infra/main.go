@@ -5,10 +5,10 @@ import (
 	"dagger/infra/internal/dagger"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 const (
@@ -20,6 +20,7 @@ const (
 	// Terraform tools
 	defaultTFLintVersion        = "0.58.0"
 	defaultTerraformDocsVersion = "0.20.0"
+	defaultSOPSVersion          = "3.9.4"
 	// Default for AWS
 	defaultAWSRegion              = "eu-west-1"
 	defaultAWSOidcTokenSecretName = "AWS_OIDC_TOKEN"
@@ -42,6 +43,80 @@ type Infra struct {
 
 	// Src is the source code for the Terraform project.
 	Src *dagger.Directory
+
+	// RetryPolicy is the retry policy applied to steps that download providers and modules.
+	// When it is not set, failed steps are not retried.
+	RetryPolicy *RetryPolicy
+
+	// ActionTimeoutSeconds bounds the execution of every action. 0 disables it.
+	ActionTimeoutSeconds int
+
+	// StepTimeoutSeconds bounds every step executed by an action. 0 disables it.
+	StepTimeoutSeconds int
+
+	// StepTimeouts overrides StepTimeoutSeconds for the steps that start with a given command.
+	StepTimeouts []*StepTimeout
+
+	// InjectedSecrets are the secrets injected into the container. Their values, and their common
+	// encodings, are redacted from outputs, reports and errors.
+	InjectedSecrets []*dagger.Secret
+
+	// NetrcCredentials are the credentials written to the .netrc file mounted as a secret.
+	NetrcCredentials []*NetrcCredential
+
+	// RegistryTokens are the API tokens of the private Terraform registry hosts.
+	RegistryTokens []*RegistryToken
+
+	// AWSWebIdentity is the web identity (OIDC) configuration set by WithAWSOIDC.
+	AWSWebIdentity *AWSWebIdentity
+
+	// AWSProfiles are the profiles of the shared config mounted with WithAWSSharedConfig.
+	AWSProfiles []*AWSProfile
+
+	// SOPSAgeKey is the age private key used to decrypt SOPS-encrypted files.
+	SOPSAgeKey *dagger.Secret
+
+	// SOPSVersion is the SOPS version used to decrypt files.
+	SOPSVersion string
+
+	// SOPSVarFiles are the decrypted var files mounted as secrets, passed to Terraform with -var-file.
+	SOPSVarFiles []string
+
+	// Backend is the backend configuration used by the actions that run against remote state.
+	Backend *TerraformBackend
+
+	// ModuleRegistry is the local module registry bound with WithModuleRegistry.
+	ModuleRegistry *ModuleRegistry
+
+	// TerraformCLIConfig is the CLI configuration generated by the WithTerraformCLIConfig* functions
+	// and WithProviderMirror.
+	TerraformCLIConfig *TerraformCLIConfig
+
+	// AffectedTargets are the modules, examples and test targets selected with WithAffectedModules.
+	AffectedTargets *AffectedTargets
+
+	// ResultCache skips the actions whose inputs did not change since they last passed.
+	ResultCache *ResultCache
+
+	// DotEnvSources records which dotenv file set each variable loaded with WithDotEnvFile.
+	DotEnvSources []*DotEnvSource
+
+	// SecretClassification decides which envVars and dotenv variables are set as secrets. The
+	// default rules apply when it is not set.
+	SecretClassification *SecretClassification
+
+	// ClassifiedSecrets records the variables set as secrets by SecretClassification.
+	ClassifiedSecrets []*SecretClassificationResult
+
+	// CredentialsInUse describes what the applied credential providers injected, without the values.
+	CredentialsInUse []*CredentialUsage
+
+	// stepAttempts records the attempts of the retried steps executed by the current action.
+	stepAttempts []StepAttempt
+
+	// redactor scrubs the values of InjectedSecrets, guarded by redactorMu.
+	redactor   *secretRedactor
+	redactorMu sync.Mutex
 }
 
 func New(
@@ -66,7 +141,7 @@ func New(
 	// srcDir is the directory to mount as the source code.
 	// +optional
 	// +defaultPath="/"
-	// +ignore=["*", "!**/*.tf", "!**/*.tfvars", "!**/.git/**", "!**/*.tfvars.json", "!*.env", "!**/README.md", "!**/.terraform-docs.yml", "!**/.tflint.hcl"]
+	// +ignore=["*", "!**/*.tf", "!**/*.tfvars", "!**/.git/**", "!**/*.tfvars.json", "!**/*.sops.*", "!**/*.env", "!**/.terraform.lock.hcl", "!**/README.md", "!**/.terraform-docs.yml", "!**/.tflint.hcl"]
 	srcDir *dagger.Directory,
 
 	// EnvVars are the environment variables that will be used to run the Terraform commands.
@@ -74,13 +149,41 @@ func New(
 	// +optional
 	envVars []string,
 
+	// secretKeyPatterns are key patterns classified as secret, in addition to the default ones,
+	// e.g. "*_DSN". They apply to envVars, and to every later WithEnvVars and WithDotEnvFile call.
+	//
+	// +optional
+	secretKeyPatterns []string,
+
+	// secretKeys are the keys always classified as secret.
+	//
+	// +optional
+	secretKeys []string,
+
+	// plainKeys are the keys never classified as secret, e.g. "TF_VAR_token_ttl".
+	//
+	// +optional
+	plainKeys []string,
+
 	// UseHashicorpImage is a flag to use the Hashicorp image.
 	// +optional
 	useHashicorpImage bool,
 ) (*Infra, error) {
+	// 0. The secret classification must be known before envVars are set.
+	var classification *SecretClassification
+
+	if len(secretKeyPatterns) > 0 || len(secretKeys) > 0 || len(plainKeys) > 0 {
+		classified, err := (&Infra{}).WithSecretClassification(secretKeyPatterns, secretKeys, plainKeys, nil, false)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to initialise dagger module with the secret classification")
+		}
+
+		classification = classified.SecretClassification
+	}
+
 	// 1. If useHashicorpImage is true, override everything and use hashicorp image
 	if useHashicorpImage {
-		mod := &Infra{}
+		mod := &Infra{SecretClassification: classification}
 		if tfVersion == "" {
 			tfVersion = defaultTerraformVersion
 		}
@@ -109,7 +212,7 @@ func New(
 
 	// 2. If ctr is passed, use that container (takes precedence over imageURL)
 	if ctr != nil {
-		mod := &Infra{Ctr: ctr}
+		mod := &Infra{Ctr: ctr, SecretClassification: classification}
 		mod, enVarError := mod.WithEnvVars(envVars)
 		if enVarError != nil {
 			return nil, WrapErrorf(enVarError, "failed to initialise dagger module with environment variables")
@@ -128,7 +231,7 @@ func New(
 
 	// 3. If imageURL is passed, use that image
 	if imageURL != "" {
-		mod := &Infra{}
+		mod := &Infra{SecretClassification: classification}
 		mod.Ctr = dag.Container().From(imageURL)
 		modWithSRC, modWithSRCError := mod.WithSRC(ctx, defaultMntPath, srcDir)
 		if modWithSRCError != nil {
@@ -147,7 +250,7 @@ func New(
 	}
 
 	// 4. Default: install binaries (use base image + install terraform)
-	mod := &Infra{}
+	mod := &Infra{SecretClassification: classification}
 	if tfVersion == "" {
 		tfVersion = defaultTerraformVersion
 	}
@@ -215,7 +318,7 @@ func (m *Infra) OpenTerminal(
 	}
 
 	if loadEnvFiles {
-		mDecorated, err := m.WithDotEnvFile(ctx, m.Src)
+		mDecorated, err := m.WithDotEnvFile(ctx, m.Src, "", "")
 		if err != nil {
 			return nil, WrapErrorf(err, "failed to decorate container with dot env file")
 		}
@@ -355,6 +458,8 @@ func (m *Infra) WithSecrets(ctx context.Context, secrets []*dagger.Secret) *Infr
 		m.Ctr = m.
 			Ctr.
 			WithSecretVariable(secretName, secret)
+
+		m.registerSecret(secret)
 	}
 
 	return m
@@ -364,6 +469,7 @@ func (m *Infra) WithSecrets(ctx context.Context, secrets []*dagger.Secret) *Infr
 //
 // This method allows setting multiple environment variables in key=value format.
 // It performs validation to ensure each environment variable is correctly formatted.
+// Variables classified as secret (see WithSecretClassification) are set as secret variables.
 //
 // Parameters:
 //   - envVars: A slice of environment variables in "KEY=VALUE" format
@@ -379,7 +485,19 @@ func (m *Infra) WithEnvVars(envVars []string) (*Infra, error) {
 	}
 
 	for _, envVar := range envVarsDagger {
-		m.Ctr = m.Ctr.WithEnvVariable(envVar.Key, envVar.Value)
+		// Empty values are set as plain variables, a secret cannot be empty.
+		isSecret, rule := m.classifySecret(envVar.Key, "")
+		if envVar.Value == "" || !isSecret {
+			m.Ctr = m.Ctr.WithEnvVariable(envVar.Key, envVar.Value)
+			m.forgetClassifiedSecret(envVar.Key)
+
+			continue
+		}
+
+		secret := newDigestNamedSecret("env-"+envVar.Key, envVar.Value)
+		m.registerSecret(secret)
+		m.Ctr = m.Ctr.WithSecretVariable(envVar.Key, secret)
+		m.recordClassifiedSecret(&SecretClassificationResult{Key: envVar.Key, Source: secretSourceEnvVars, Rule: rule})
 	}
 
 	return m, nil
@@ -464,57 +582,62 @@ func (m *Infra) WithTerraformDocs(terraformDocsVersion string) *Infra {
 
 // WithNewNetrcFileGitHub creates a new .netrc file with the GitHub credentials.
 //
-// The .netrc file is created in the root directory of the container.
+// The password is converted into a Dagger secret, and the .netrc file is mounted as a secret in
+// the root directory of the container, like WithNewNetrcFileAsSecretGitHub, so it never enters the
+// layer cache. Prefer WithNewNetrcFileAsSecretGitHub, which keeps the password out of the call.
 func (m *Infra) WithNewNetrcFileGitHub(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
 	username string,
 	password string,
-) *Infra {
-	machineCMD := "machine github.com\nlogin " + username + "\npassword " + password + "\n"
-
-	m.Ctr = m.Ctr.WithNewFile(configNetrcRootPath, machineCMD)
-
-	return m
+) (*Infra, error) {
+	return m.WithNetrcCredential(ctx, "github.com", username, newDigestNamedSecret("netrc-password", password))
 }
 
 // WithNewNetrcFileAsSecretGitHub creates a new .netrc file with the GitHub credentials.
 //
-// The .netrc file is created in the root directory of the container.
-// The argument 'password' is a secret that is not exposed in the logs.
-func (m *Infra) WithNewNetrcFileAsSecretGitHub(username string, password *dagger.Secret) *Infra {
-	passwordTxtValue, _ := password.Plaintext(context.Background())
-	machineCMD := fmt.Sprintf("machine github.com\nlogin %s\npassword %s\n", username, passwordTxtValue)
-	//nolint:exhaustruct // This is a method that is used to set the base image and version.
-	m.Ctr = m.Ctr.WithNewFile(configNetrcRootPath, machineCMD)
-
-	return m
+// The .netrc file is mounted as a secret in the root directory of the container.
+// The argument 'password' is a secret that is not exposed in the logs nor in the layer cache.
+// For self-hosted instances, use WithNetrcCredential.
+func (m *Infra) WithNewNetrcFileAsSecretGitHub(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+	username string,
+	password *dagger.Secret,
+) (*Infra, error) {
+	return m.WithNetrcCredential(ctx, "github.com", username, password)
 }
 
 // WithNewNetrcFileGitLab creates a new .netrc file with the GitLab credentials.
 //
-// The .netrc file is created in the root directory of the container.
+// The password is converted into a Dagger secret, and the .netrc file is mounted as a secret in
+// the root directory of the container, like WithNewNetrcFileAsSecretGitLab, so it never enters the
+// layer cache. Prefer WithNewNetrcFileAsSecretGitLab, which keeps the password out of the call.
 func (m *Infra) WithNewNetrcFileGitLab(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
 	username string,
 	password string,
-) *Infra {
-	machineCMD := "machine gitlab.com\nlogin " + username + "\npassword " + password + "\n"
-
-	m.Ctr = m.Ctr.WithNewFile(configNetrcRootPath, machineCMD)
-
-	return m
+) (*Infra, error) {
+	return m.WithNetrcCredential(ctx, "gitlab.com", username, newDigestNamedSecret("netrc-password", password))
 }
 
 // WithNewNetrcFileAsSecretGitLab creates a new .netrc file with the GitLab credentials.
 //
-// The .netrc file is created in the root directory of the container.
-// The argument 'password' is a secret that is not exposed in the logs.
-func (m *Infra) WithNewNetrcFileAsSecretGitLab(username string, password *dagger.Secret) *Infra {
-	passwordTxtValue, _ := password.Plaintext(context.Background())
-	machineCMD := fmt.Sprintf("machine gitlab.com\nlogin %s\npassword %s\n", username, passwordTxtValue)
-
-	//nolint:exhaustruct // This is a method that is used to set the base image and version.
-	m.Ctr = m.Ctr.WithNewFile(configNetrcRootPath, machineCMD)
-
-	return m
+// The .netrc file is mounted as a secret in the root directory of the container.
+// The argument 'password' is a secret that is not exposed in the logs nor in the layer cache.
+// For self-hosted instances, use WithNetrcCredential.
+func (m *Infra) WithNewNetrcFileAsSecretGitLab(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+	username string,
+	password *dagger.Secret,
+) (*Infra, error) {
+	return m.WithNetrcCredential(ctx, "gitlab.com", username, password)
 }
 
 // WithSSHAuthSocket configures SSH authentication for Terraform modules with Git SSH sources.
@@ -663,6 +786,7 @@ func (m *Infra) WithTerraformWorkspace(workspace string) *Infra {
 //
 // Returns:
 //   - *Infra: The updated Infra instance with AWS credentials and region set
+//   - error: An error if a required key is missing, or an AWS shared config is mounted
 func (m *Infra) WithAWSKeys(
 	// ctx is the context for the Dagger container.
 	// +optional
@@ -677,65 +801,35 @@ func (m *Infra) WithAWSKeys(
 	// awsSessionToken is the AWS session token.
 	// +optional
 	awsSessionToken *dagger.Secret,
-) *Infra {
-	awsRegion = getDefaultAWSRegionIfNotSet(awsRegion)
-
-	m.Ctr = m.Ctr.
-		WithEnvVariable("AWS_REGION", awsRegion).
-		WithSecretVariable("AWS_ACCESS_KEY_ID", awsAccessKeyID).
-		WithSecretVariable("AWS_SECRET_ACCESS_KEY", awsSecretAccessKey)
+) (*Infra, error) {
+	return m.applyAWSStaticKeysProvider(newAWSStaticKeysProvider(awsAccessKeyID, awsSecretAccessKey, awsRegion, awsSessionToken))
+}
 
-	if awsSessionToken != nil {
-		m.Ctr = m.Ctr.
-			WithSecretVariable("AWS_SESSION_TOKEN", awsSessionToken)
+// applyAWSStaticKeysProvider applies the static keys provider, unless a shared config is mounted:
+// the keys would take precedence over its profiles. The same rule is checked by WithAWSProfile.
+func (m *Infra) applyAWSStaticKeysProvider(provider CredentialProvider) (*Infra, error) {
+	if m.isCredentialInUse("aws-shared-config") {
+		return nil, Errorf("an AWS profile and static AWS keys cannot be used together")
 	}
 
-	return m
+	return m.applyCredentialProviders(provider)
 }
 
-// WithAWSOIDC sets the AWS OIDC credentials in the container.
-//
-// This method sets the AWS OIDC credentials in the container, making them available as environment variables.
-// It also mounts the AWS OIDC credentials as secrets into the container.
-func (m *Infra) WithAWSOIDC(
-	// roleARN is the ARN of the IAM role to assume.
-	roleARN string,
-	// oidcToken is the Dagger Secret containing the OIDC JWT token from GitLab.
-	oidcToken *dagger.Secret,
-	// oidcTokenName is the name of the secret containing the OIDC JWT token from GitLab.
-	// +optional
-	oidcTokenName string,
-	// awsRegion is the AWS region.
-	// +optional
+// newAWSStaticKeysProvider returns the provider behind WithAWSKeys.
+func newAWSStaticKeysProvider(
+	awsAccessKeyID, awsSecretAccessKey *dagger.Secret,
 	awsRegion string,
-	// awsRoleSessionName is an optional name for the assumed role session.
-	// +optional
-	awsRoleSessionName string,
-) *Infra {
-	awsRegion = getDefaultAWSRegionIfNotSet(awsRegion)
-
-	if oidcTokenName == "" {
-		oidcTokenName = defaultAWSOidcTokenSecretName
-	}
-
-	if awsRoleSessionName == "" {
-		awsRoleSessionName = fmt.Sprintf("terragrunt-dagger-%s", uuid.New().String())
+	awsSessionToken *dagger.Secret,
+) CredentialProvider {
+	return &staticKeysCredentialProvider{
+		name: "aws-static-keys",
+		envVars: []credentialEnvVar{
+			{name: "AWS_REGION", value: getDefaultAWSRegionIfNotSet(awsRegion)},
+			{name: "AWS_ACCESS_KEY_ID", secret: awsAccessKeyID, required: true},
+			{name: "AWS_SECRET_ACCESS_KEY", secret: awsSecretAccessKey, required: true},
+			{name: "AWS_SESSION_TOKEN", secret: awsSessionToken},
+		},
 	}
-
-	oidcTokenPath := "run/secrets/" + oidcTokenName
-
-	m.Ctr = m.Ctr.
-		WithEnvVariable("AWS_REGION", awsRegion).
-		WithEnvVariable("AWS_ROLE_ARN", roleARN).
-		WithEnvVariable("AWS_ROLE_SESSION_NAME", awsRoleSessionName).
-		WithEnvVariable("AWS_WEB_IDENTITY_TOKEN_FILE", oidcTokenPath).
-		// cleaning —if set— aws keys.
-		WithoutEnvVariable("AWS_ACCESS_KEY_ID").
-		WithoutEnvVariable("AWS_SECRET_ACCESS_KEY").
-		WithoutEnvVariable("AWS_SESSION_TOKEN").
-		WithSecretVariable(oidcTokenName, oidcToken)
-
-	return m
 }
 
 // WithGitlabToken sets the GitLab token in the container.
@@ -748,6 +842,8 @@ func (m *Infra) WithGitlabToken(ctx context.Context, token *dagger.Secret) *Infr
 	m.Ctr = m.Ctr.
 		WithSecretVariable("GITLAB_TOKEN", token)
 
+	m.registerSecret(token)
+
 	return m
 }
 
@@ -761,6 +857,8 @@ func (m *Infra) WithGitHubToken(ctx context.Context, token *dagger.Secret) *Infr
 	m.Ctr = m.Ctr.
 		WithSecretVariable("GITHUB_TOKEN", token)
 
+	m.registerSecret(token)
+
 	return m
 }
 
@@ -775,6 +873,8 @@ func (m *Infra) WithTerraformToken(ctx context.Context, token *dagger.Secret) *I
 	m.Ctr = m.Ctr.
 		WithSecretVariable("TF_TOKEN", token)
 
+	m.registerSecret(token)
+
 	return m
 }
 
@@ -831,56 +931,127 @@ func (m *Infra) WithTerraformCLIConfigFile(configPath string) *Infra {
 
 // WithDotEnvFile loads and processes environment variables from .env files in the provided directory.
 //
-// This method finds all .env files in the given directory, reads their contents, and sets
-// environment variables in the Terraform container. Files containing "secret" in their name
-// will have their values added as secret variables rather than regular environment variables.
+// Dotenv files are looked up in layers, from the lowest to the highest precedence: the root of
+// the source directory, then the module directory, then the example directory. Within a layer,
+// files are read in name order, and a variable set by a later file overrides the earlier ones.
+// Variables classified as secret (see WithSecretClassification; by default, every variable of a
+// file containing "secret" in its name) are added as secret variables rather than regular
+// environment variables. A source without any .env file is not an error.
 //
-// The method supports standard .env file formats with KEY=VALUE pairs on each line.
-// Comments (lines starting with #) and empty lines are ignored. Values can be optionally
-// quoted with single or double quotes, which will be automatically removed.
+// The files support 'export' prefixes, single- and double-quoted values (which can span several
+// lines), escape sequences in double quotes, inline comments, and ${VAR} interpolation. See
+// DotEnvReport for which file set each variable.
 //
 // Parameters:
 //   - ctx: Context for the Dagger operations
 //   - src: Directory containing the .env files to process
+//   - moduleDir: The module directory, relative to src (optional)
+//   - exampleDir: The example directory, relative to src (optional)
 //
 // Returns:
 //   - *Infra: The updated Infra instance with environment variables set
 //   - error: An error if file reading or parsing fails
-func (m *Infra) WithDotEnvFile(ctx context.Context, src *dagger.Directory) (*Infra, error) {
+func (m *Infra) WithDotEnvFile(
+	// ctx is the context for the Dagger operations.
+	// +optional
+	ctx context.Context,
+	// src is the directory containing the .env files.
+	src *dagger.Directory,
+	// moduleDir is the module directory, relative to src, e.g. "modules/default".
+	// +optional
+	moduleDir string,
+	// exampleDir is the example directory, relative to src, e.g. "examples/default/basic".
+	// +optional
+	exampleDir string,
+) (*Infra, error) {
 	if src == nil {
 		return nil, NewError("failed to load .env file, the source directory is nil")
 	}
 
-	// Check if there's any dotenv file on the source directory passed, or set.
-	entries, err := src.Entries(ctx)
+	var dotEnvFiles []string
+
+	for _, layer := range getDotEnvLayers(moduleDir, exampleDir) {
+		layerFiles, err := src.Glob(ctx, filepath.Join(layer, "*.env"))
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to glob dot env files in %s", layer)
+		}
+
+		sort.Strings(layerFiles)
+		dotEnvFiles = append(dotEnvFiles, layerFiles...)
+	}
+
+	if len(dotEnvFiles) == 0 {
+		return m, nil
+	}
+
+	// Variables that no dotenv file sets are resolved from the container environment.
+	entries, err := m.parseDotEnvFiles(ctx, src, dotEnvFiles, func(name string) (string, bool) {
+		value, err := m.Ctr.EnvVariable(ctx, name)
+
+		return value, err == nil && value != ""
+	})
 	if err != nil {
-		return nil, WrapErrorf(err, "failed to list files in source directory")
+		return nil, WrapErrorf(err, "failed to parse dot env files")
 	}
 
-	foundDotEnvFiles := []string{}
+	// The last assignment of every key wins.
+	var keys []string
+
+	lastEntries := map[string]dotEnvEntry{}
+
 	for _, entry := range entries {
-		if strings.HasSuffix(entry, ".env") {
-			foundDotEnvFiles = append(foundDotEnvFiles, entry)
+		if _, seen := lastEntries[entry.key]; !seen {
+			keys = append(keys, entry.key)
 		}
-	}
 
-	if len(foundDotEnvFiles) == 0 {
-		return nil, NewError("No .env files found when inspecting the source directory")
+		lastEntries[entry.key] = entry
 	}
 
-	dotEnvFilesInSrc, srcError := src.Glob(ctx, "*.env")
+	// Rules of the keys set as secrets. Empty values are set as plain variables, a secret cannot be empty.
+	secretRules := map[string]string{}
 
-	if srcError != nil {
-		return nil, WrapErrorf(srcError, "failed to glob dot env files")
-	}
+	for _, key := range keys {
+		entry := lastEntries[key]
+		if entry.value == "" {
+			continue
+		}
+
+		// Every value of a SOPS-encrypted file is a secret.
+		if entry.encrypted {
+			secretRules[key] = "sops"
 
-	ctrWithDotEnvFiles, dotEnvFilesParseErr := parseDotEnvFiles(ctx, m.Ctr, src, dotEnvFilesInSrc)
+			continue
+		}
 
-	if dotEnvFilesParseErr != nil {
-		return nil, WrapErrorf(dotEnvFilesParseErr, "failed to parse dot env files")
+		if isSecret, rule := m.classifySecret(key, entry.file); isSecret {
+			secretRules[key] = rule
+		}
 	}
 
-	m.Ctr = ctrWithDotEnvFiles
+	for _, key := range keys {
+		entry := lastEntries[key]
+		rule, isSecret := secretRules[key]
+
+		// A value interpolating a secret is a secret too.
+		for _, ref := range entry.refs {
+			if _, refIsSecret := secretRules[ref]; !isSecret && refIsSecret && entry.value != "" {
+				isSecret = true
+				rule = "interpolates:" + ref
+			}
+		}
+
+		if isSecret {
+			secret := newDigestNamedSecret("dotenv-"+key, entry.value)
+			m.registerSecret(secret)
+			m.Ctr = m.Ctr.WithSecretVariable(key, secret)
+			m.recordClassifiedSecret(&SecretClassificationResult{Key: key, Source: entry.file, Rule: rule})
+		} else {
+			m.Ctr = m.Ctr.WithEnvVariable(key, entry.value)
+			m.forgetClassifiedSecret(key)
+		}
+
+		m.recordDotEnvSource(&DotEnvSource{Key: key, File: entry.file, Line: entry.line, Secret: isSecret})
+	}
 
 	return m, nil
 }
@@ -943,7 +1114,8 @@ func (m *Infra) WithTerraformVariable(name, value string) *Infra {
 // WithTerraformVariables sets multiple Terraform input variables at once.
 //
 // This method takes a slice of "KEY=VALUE" strings and sets them as TF_VAR_ environment variables.
-// This approach works around Dagger's limitation with map arguments.
+// This approach works around Dagger's limitation with map arguments. For lists, maps and objects,
+// use WithTypedTerraformVariables.
 //
 // Parameters:
 //   - variables: A slice of strings in "KEY=VALUE" format (e.g., ["env=production", "region=us-west-2"])
@@ -961,11 +1133,10 @@ func (m *Infra) WithTerraformVariables(variables []string) (*Infra, error) {
 		return nil, WrapErrorf(err, "failed to parse terraform variables")
 	}
 
+	// Empty values are kept: an empty string is a valid value for a string variable.
 	for name, value := range parsedVars {
-		if name != "" && value != "" {
-			envVar := fmt.Sprintf("TF_VAR_%s", name)
-			m.Ctr = m.Ctr.WithEnvVariable(envVar, value)
-		}
+		envVar := fmt.Sprintf("TF_VAR_%s", name)
+		m.Ctr = m.Ctr.WithEnvVariable(envVar, value)
 	}
 
 	return m, nil
@@ -1007,6 +1178,8 @@ func (m *Infra) WithTerraformRegistryGitlabToken(ctx context.Context, token *dag
 	m.Ctr = m.Ctr.
 		WithSecretVariable("TF_TOKEN_gitlab_com", token)
 
+	m.registerSecret(token)
+
 	return m
 }
 
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	// Names of the files the module graph is exported to
+	moduleGraphDOTFileName  = "module-graph.dot"
+	moduleGraphJSONFileName = "module-graph.json"
+)
+
+// Kinds of the nodes of the module graph
+const (
+	moduleGraphKindModule       = "module"
+	moduleGraphKindNestedModule = "nested-module"
+	moduleGraphKindExample      = "example"
+	moduleGraphKindTestTarget   = "test-target"
+	moduleGraphKindLocal        = "local"
+)
+
+// terraformModuleCall is a module block of a configuration.
+type terraformModuleCall struct {
+	file   string
+	name   string
+	source string
+	// dir is the directory a local source points to, from the root of the source directory, or ""
+	// when the source is not local (e.g. a registry or git source).
+	dir string
+}
+
+// isLocalModuleSource reports whether a module source is a local path, as Terraform decides it.
+func isLocalModuleSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
+
+// parseModuleCalls reads the module blocks of the .tf files of a directory.
+//
+// Parameters:
+//   - dir: The directory, from the root of the source directory
+//   - files: The contents of the .tf files, by file name
+//
+// Returns:
+//   - []*terraformModuleCall: The module calls, by file and in the order they are declared
+//   - error: An error if a file cannot be parsed, or a source is not a literal string
+func parseModuleCalls(dir string, files map[string]string) ([]*terraformModuleCall, error) {
+	parser := hclparse.NewParser()
+
+	var calls []*terraformModuleCall
+
+	for _, name := range sortedMapKeys(files) {
+		file, diags := parser.ParseHCL([]byte(files[name]), name)
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		content, _, diags := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "module", LabelNames: []string{"name"}}},
+		})
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			blockContent, _, diags := block.Body.PartialContent(&hcl.BodySchema{
+				Attributes: []hcl.AttributeSchema{{Name: "source", Required: true}},
+			})
+			if diags.HasErrors() {
+				return nil, Errorf("invalid module %q in %s: %s", block.Labels[0], name, diags.Error())
+			}
+
+			value, diags := blockContent.Attributes["source"].Expr.Value(nil)
+			if diags.HasErrors() || value.Type() != cty.String || value.IsNull() {
+				return nil, Errorf("the source of module %q in %s must be a literal string", block.Labels[0], name)
+			}
+
+			call := &terraformModuleCall{file: name, name: block.Labels[0], source: value.AsString()}
+			if isLocalModuleSource(call.source) {
+				call.dir = filepath.Clean(filepath.Join(dir, call.source))
+			}
+
+			calls = append(calls, call)
+		}
+	}
+
+	return calls, nil
+}
+
+// moduleGraphNode is a directory of the module graph: a module, a nested module, an example, a
+// test target, or another local module called by one of them.
+type moduleGraphNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+// moduleGraphEdge is a module call with a local source.
+type moduleGraphEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	File   string `json:"file"`
+}
+
+// moduleGraph is the graph of the local module calls, with the problems found in it.
+type moduleGraph struct {
+	Nodes                  []*moduleGraphNode `json:"nodes"`
+	Edges                  []*moduleGraphEdge `json:"edges"`
+	Cycles                 [][]string         `json:"cycles"`
+	BrokenSources          []*moduleGraphEdge `json:"broken_sources"`
+	ModulesWithoutExamples []string           `json:"modules_without_examples"`
+	ModulesWithoutTests    []string           `json:"modules_without_tests"`
+}
+
+// getModuleGraphNodeKind returns the kind of a directory of the source directory.
+func getModuleGraphNodeKind(dir string) string {
+	parts := strings.Split(dir, "/")
+
+	switch {
+	case len(parts) == 2 && parts[0] == configTerraformModulesRootPath:
+		return moduleGraphKindModule
+	case len(parts) == 4 && parts[0] == configTerraformModulesRootPath && parts[2] == configTerraformModulesRootPath:
+		return moduleGraphKindNestedModule
+	case len(parts) == 3 && parts[0] == configExamplesRootPath:
+		return moduleGraphKindExample
+	case strings.HasPrefix(dir, configTestTargetsRootPath+"/") && len(parts) == 5 && parts[3] == "target":
+		return moduleGraphKindTestTarget
+	default:
+		return moduleGraphKindLocal
+	}
+}
+
+// findModuleGraphCycles returns the cycles of the graph, each starting from its smallest node,
+// e.g. ["modules/a", "modules/b", "modules/a"].
+func findModuleGraphCycles(nodes []string, next map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	seen := map[string]bool{}
+
+	var (
+		cycles [][]string
+		path   []string
+		visit  func(node string)
+	)
+
+	visit = func(node string) {
+		state[node] = visiting
+		path = append(path, node)
+
+		for _, to := range next[node] {
+			switch state[to] {
+			case unvisited:
+				visit(to)
+			case visiting:
+				start := 0
+				for path[start] != to {
+					start++
+				}
+
+				cycle := append([]string{}, path[start:]...)
+
+				// Rotate the cycle to start from its smallest node, so it is reported once.
+				smallest := 0
+				for i := range cycle {
+					if cycle[i] < cycle[smallest] {
+						smallest = i
+					}
+				}
+
+				cycle = append(cycle[smallest:], cycle[:smallest]...)
+				cycle = append(cycle, cycle[0])
+
+				if key := strings.Join(cycle, " -> "); !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[node] = visited
+	}
+
+	for _, node := range nodes {
+		if state[node] == unvisited {
+			visit(node)
+		}
+	}
+
+	return cycles
+}
+
+// getReachableModules returns the nodes reachable from the nodes of a kind.
+func getReachableModules(graph *moduleGraph, next map[string][]string, kind string) map[string]bool {
+	reachable := map[string]bool{}
+
+	var pending []string
+
+	for _, node := range graph.Nodes {
+		if node.Kind == kind {
+			pending = append(pending, next[node.ID]...)
+		}
+	}
+
+	for len(pending) > 0 {
+		node := pending[0]
+		pending = pending[1:]
+
+		if !reachable[node] {
+			reachable[node] = true
+			pending = append(pending, next[node]...)
+		}
+	}
+
+	return reachable
+}
+
+// buildModuleGraph builds the graph of the local module calls of the modules, nested modules,
+// examples and test targets, following local sources to other directories.
+func (m *Infra) buildModuleGraph(ctx context.Context) (*moduleGraph, error) {
+	if m.Src == nil {
+		return nil, NewError("failed to build the module graph, the source directory is nil")
+	}
+
+	var pending []string
+
+	for _, pattern := range []string{
+		filepath.Join(configTerraformModulesRootPath, "*", "*.tf"),
+		filepath.Join(configTerraformModulesRootPath, "*", configTerraformModulesRootPath, "*", "*.tf"),
+		filepath.Join(configExamplesRootPath, "*", "*", "*.tf"),
+		filepath.Join(configTestTargetsRootPath, "*", "target", "*", "*.tf"),
+	} {
+		tfFiles, err := m.Src.Glob(ctx, pattern)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to list %s", pattern)
+		}
+
+		for _, tfFile := range tfFiles {
+			if dir := filepath.Dir(tfFile); !contains(pending, dir) {
+				pending = append(pending, dir)
+			}
+		}
+	}
+
+	sort.Strings(pending)
+
+	graph := &moduleGraph{
+		Nodes:                  []*moduleGraphNode{},
+		Edges:                  []*moduleGraphEdge{},
+		Cycles:                 [][]string{},
+		BrokenSources:          []*moduleGraphEdge{},
+		ModulesWithoutExamples: []string{},
+		ModulesWithoutTests:    []string{},
+	}
+
+	next := map[string][]string{}
+	nodes := map[string]bool{}
+
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+
+		if nodes[dir] {
+			continue
+		}
+
+		nodes[dir] = true
+		graph.Nodes = append(graph.Nodes, &moduleGraphNode{ID: dir, Kind: getModuleGraphNodeKind(dir)})
+
+		files, err := m.readModuleTerraformFiles(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		calls, err := parseModuleCalls(dir, files)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the module calls of %s", dir)
+		}
+
+		for _, call := range calls {
+			if call.dir == "" {
+				continue
+			}
+
+			edge := &moduleGraphEdge{From: dir, To: call.dir, Name: call.name, Source: call.source, File: call.file}
+
+			if call.dir == ".." || strings.HasPrefix(call.dir, "../") {
+				graph.BrokenSources = append(graph.BrokenSources, edge)
+
+				continue
+			}
+
+			tfFiles, err := m.Src.Glob(ctx, filepath.Join(call.dir, "*.tf"))
+			if err != nil {
+				return nil, WrapErrorf(err, "failed to list the Terraform files of %s", call.dir)
+			}
+
+			if len(tfFiles) == 0 {
+				graph.BrokenSources = append(graph.BrokenSources, edge)
+
+				continue
+			}
+
+			graph.Edges = append(graph.Edges, edge)
+			next[dir] = append(next[dir], call.dir)
+
+			if !nodes[call.dir] {
+				pending = append(pending, call.dir)
+			}
+		}
+	}
+
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].ID < graph.Nodes[j].ID })
+
+	nodeIDs := make([]string, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		nodeIDs[i] = node.ID
+	}
+
+	graph.Cycles = append(graph.Cycles, findModuleGraphCycles(nodeIDs, next)...)
+
+	testedByExamples := getReachableModules(graph, next, moduleGraphKindExample)
+	testedByTargets := getReachableModules(graph, next, moduleGraphKindTestTarget)
+
+	for _, node := range graph.Nodes {
+		if node.Kind != moduleGraphKindModule {
+			continue
+		}
+
+		if !testedByExamples[node.ID] {
+			graph.ModulesWithoutExamples = append(graph.ModulesWithoutExamples, node.ID)
+		}
+
+		if !testedByTargets[node.ID] {
+			graph.ModulesWithoutTests = append(graph.ModulesWithoutTests, node.ID)
+		}
+	}
+
+	return graph, nil
+}
+
+// renderModuleGraphDOT renders the module graph in the Graphviz DOT language. Broken sources are
+// drawn as dashed red edges to the path they point to, and the edges of cycles in red.
+func renderModuleGraphDOT(graph *moduleGraph) string {
+	shapes := map[string]string{
+		moduleGraphKindModule:       "box",
+		moduleGraphKindNestedModule: "box3d",
+		moduleGraphKindExample:      "note",
+		moduleGraphKindTestTarget:   "component",
+		moduleGraphKindLocal:        "ellipse",
+	}
+
+	inCycle := map[string]bool{}
+
+	for _, cycle := range graph.Cycles {
+		for i := 0; i < len(cycle)-1; i++ {
+			inCycle[cycle[i]+"\x00"+cycle[i+1]] = true
+		}
+	}
+
+	var dot strings.Builder
+
+	dot.WriteString("digraph modules {\n  rankdir=LR;\n  node [fontname=\"Helvetica\"];\n")
+
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&dot, "  %q [shape=%s, tooltip=%q];\n", node.ID, shapes[node.Kind], node.Kind)
+	}
+
+	for _, edge := range graph.Edges {
+		color := ""
+		if inCycle[edge.From+"\x00"+edge.To] {
+			color = ", color=red"
+		}
+
+		fmt.Fprintf(&dot, "  %q -> %q [label=%q%s];\n", edge.From, edge.To, edge.Name, color)
+	}
+
+	for _, edge := range graph.BrokenSources {
+		fmt.Fprintf(&dot, "  %q [shape=plaintext, fontcolor=red];\n", edge.To)
+		fmt.Fprintf(&dot, "  %q -> %q [label=%q, style=dashed, color=red];\n", edge.From, edge.To, edge.Name)
+	}
+
+	dot.WriteString("}\n")
+
+	return dot.String()
+}
+
+// getModuleGraphFindings describes the problems found in the module graph.
+func getModuleGraphFindings(graph *moduleGraph) []string {
+	var findings []string
+
+	for _, cycle := range graph.Cycles {
+		findings = append(findings, "cycle: "+strings.Join(cycle, " -> "))
+	}
+
+	for _, edge := range graph.BrokenSources {
+		findings = append(findings, fmt.Sprintf("broken source: module %q in %s points to %q (%s), which holds no Terraform file",
+			edge.Name, edge.File, edge.Source, edge.To))
+	}
+
+	for _, module := range graph.ModulesWithoutExamples {
+		findings = append(findings, fmt.Sprintf("no example: %s is not called by any example under %s/",
+			module, configExamplesRootPath))
+	}
+
+	for _, module := range graph.ModulesWithoutTests {
+		findings = append(findings, fmt.Sprintf("no tests: %s is not called by any test target under %s/<module>/target/",
+			module, configTestTargetsRootPath))
+	}
+
+	return findings
+}
+
+// ActionTerraformModuleGraph builds the graph of the local module calls of the modules (and
+// their nested modules under modules/<module>/modules/), examples and test targets, and exports
+// it as DOT and JSON.
+//
+// Every module block is parsed, and local sources (e.g. "../../../modules/default") become
+// edges. The graph reports cycles, broken relative paths (sources pointing to a directory without
+// Terraform files, or outside the source directory), modules no example calls, and modules no
+// test target calls, directly or through other modules. In check mode, the action fails when any
+// of these is found.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - check: Whether to fail when the graph has cycles, broken sources, or untested modules
+//
+// Returns:
+//   - *dagger.Directory: The module-graph.dot and module-graph.json files
+//   - error: An error if a module cannot be parsed or, in check mode, listing the problems found
+func (m *Infra) ActionTerraformModuleGraph(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// check is a flag to fail when the graph has cycles, broken sources, or modules without examples or tests.
+	// +optional
+	check bool,
+) (*dagger.Directory, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	graph, err := m.buildModuleGraph(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if findings := getModuleGraphFindings(graph); check && len(findings) > 0 {
+		return nil, Errorf("the module graph has problems:\n  - %s", strings.Join(findings, "\n  - "))
+	}
+
+	encoded, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return nil, WrapError(err, "failed to encode the module graph")
+	}
+
+	return dag.Directory().
+		WithNewFile(moduleGraphDOTFileName, renderModuleGraphDOT(graph)).
+		WithNewFile(moduleGraphJSONFileName, string(encoded)+"\n"), nil
+}
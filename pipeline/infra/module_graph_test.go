@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestFindModuleGraphCycles verifies that cycles are found once, starting from their smallest
+// node, and that shared dependencies are not reported as cycles.
+func TestFindModuleGraphCycles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		nodes []string
+		next  map[string][]string
+		want  [][]string
+	}{
+		{
+			name:  "chain",
+			nodes: []string{"examples/a/basic", "modules/a", "modules/b"},
+			next: map[string][]string{
+				"examples/a/basic": {"modules/a"},
+				"modules/a":        {"modules/b"},
+			},
+		},
+		{
+			name:  "diamond",
+			nodes: []string{"modules/a", "modules/b", "modules/c", "modules/d"},
+			next: map[string][]string{
+				"modules/a": {"modules/b", "modules/c"},
+				"modules/b": {"modules/d"},
+				"modules/c": {"modules/d"},
+			},
+		},
+		{
+			name:  "self reference",
+			nodes: []string{"modules/a"},
+			next:  map[string][]string{"modules/a": {"modules/a"}},
+			want:  [][]string{{"modules/a", "modules/a"}},
+		},
+		{
+			name:  "cycle entered from its largest node",
+			nodes: []string{"modules/c", "modules/a", "modules/b"},
+			next: map[string][]string{
+				"modules/c": {"modules/a"},
+				"modules/a": {"modules/b"},
+				"modules/b": {"modules/c"},
+			},
+			want: [][]string{{"modules/a", "modules/b", "modules/c", "modules/a"}},
+		},
+		{
+			name:  "cycle reached from an example",
+			nodes: []string{"examples/a/basic", "modules/a", "modules/b"},
+			next: map[string][]string{
+				"examples/a/basic": {"modules/a"},
+				"modules/a":        {"modules/b"},
+				"modules/b":        {"modules/a"},
+			},
+			want: [][]string{{"modules/a", "modules/b", "modules/a"}},
+		},
+		{
+			name:  "two separate cycles",
+			nodes: []string{"modules/a", "modules/b", "modules/x", "modules/y"},
+			next: map[string][]string{
+				"modules/a": {"modules/b"},
+				"modules/b": {"modules/a"},
+				"modules/x": {"modules/y"},
+				"modules/y": {"modules/x"},
+			},
+			want: [][]string{
+				{"modules/a", "modules/b", "modules/a"},
+				{"modules/x", "modules/y", "modules/x"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := findModuleGraphCycles(tt.nodes, tt.next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findModuleGraphCycles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
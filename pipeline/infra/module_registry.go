@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dagger/infra/internal/dagger"
+	_ "embed"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	// Module registry service
+	moduleRegistryServerName   = "moduleregistry"
+	moduleRegistryHostname     = "registry.internal"
+	moduleRegistryPort         = 443
+	moduleRegistryModulesPath  = "/modules"
+	moduleRegistryTLSPath      = "/run/secrets/registry-tls"
+	moduleRegistryCAPath       = "/usr/local/share/pipeline-ca"
+	defaultModuleRegistryNS    = "local"
+	defaultModuleRegistrySys   = "generic"
+	defaultModuleRegistryVer   = "1.0.0"
+	moduleRegistryCertValidity = 24 * time.Hour
+)
+
+//go:embed services/moduleregistry/main.go
+var moduleRegistrySource string
+
+// ModuleRegistry is the local module registry bound with WithModuleRegistry.
+type ModuleRegistry struct {
+	// Host is the registry hostname used in module addresses.
+	Host string
+	// Namespace is the namespace of every module.
+	Namespace string
+	// System is the target system of every module.
+	System string
+	// Version is the version every module is published at.
+	Version string
+}
+
+// getModuleAddress returns the registry address of a module, e.g. "registry.internal/local/default/generic".
+func (r *ModuleRegistry) getModuleAddress(name string) string {
+	return strings.Join([]string{r.Host, r.Namespace, name, r.System}, "/")
+}
+
+// newSelfSignedCertificate returns a self-signed certificate and its private key, in PEM format,
+// for a hostname. The certificate is its own CA, so trusting it is enough to reach the host.
+func newSelfSignedCertificate(hostname string) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", Errorf("failed to generate the TLS key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", Errorf("failed to generate the certificate serial number: %v", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname},
+		DNSNames:              []string{hostname},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(moduleRegistryCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", Errorf("failed to create the certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", Errorf("failed to encode the TLS key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return string(certPEM), string(keyPEM), nil
+}
+
+// ModuleRegistryService returns a Terraform module registry serving the modules of the source directory.
+//
+// Every directory of modules/ is published as <namespace>/<directory>/<system> at moduleVersion,
+// following the Module Registry Protocol (service discovery, versions and download). The modules
+// are archived on the fly, so the registry serves the current source. Terraform only uses
+// registries over HTTPS: the server listens on port 443 with the given certificate.
+//
+// Parameters:
+//   - tlsCertificate: The certificate of the registry hostname, in PEM format
+//   - tlsKey: The private key of the certificate, in PEM format
+//   - namespace: The namespace of the modules (defaults to "local")
+//   - system: The target system of the modules (defaults to "generic")
+//   - moduleVersion: The version the modules are published at (defaults to "1.0.0")
+//
+// Returns:
+//   - *dagger.Service: The module registry as a Dagger service
+//   - error: An error if the source directory is not set
+func (m *Infra) ModuleRegistryService(
+	// tlsCertificate is the certificate of the registry hostname, in PEM format.
+	tlsCertificate *dagger.File,
+	// tlsKey is the private key of the certificate, in PEM format.
+	tlsKey *dagger.Secret,
+	// namespace is the namespace of the modules.
+	// +optional
+	namespace string,
+	// system is the target system of the modules, e.g. "aws".
+	// +optional
+	system string,
+	// moduleVersion is the version the modules are published at.
+	// +optional
+	moduleVersion string,
+) (*dagger.Service, error) {
+	if m.Src == nil {
+		return nil, NewError("failed to create the module registry, the source directory is nil")
+	}
+
+	registry := newModuleRegistry(namespace, system, moduleVersion)
+
+	return buildGoServiceContainer(moduleRegistryServerName, moduleRegistrySource).
+		WithDirectory(moduleRegistryModulesPath, m.Src.Directory(configTerraformModulesRootPath)).
+		WithFile(filepath.Join(moduleRegistryTLSPath, "tls.crt"), tlsCertificate).
+		WithMountedSecret(filepath.Join(moduleRegistryTLSPath, "tls.key"), tlsKey).
+		WithEnvVariable("MODULES_DIR", moduleRegistryModulesPath).
+		WithEnvVariable("REGISTRY_NAMESPACE", registry.Namespace).
+		WithEnvVariable("REGISTRY_SYSTEM", registry.System).
+		WithEnvVariable("MODULE_VERSION", registry.Version).
+		WithEnvVariable("TLS_CERT_FILE", filepath.Join(moduleRegistryTLSPath, "tls.crt")).
+		WithEnvVariable("TLS_KEY_FILE", filepath.Join(moduleRegistryTLSPath, "tls.key")).
+		WithEnvVariable("PORT", fmt.Sprint(moduleRegistryPort)).
+		WithExposedPort(moduleRegistryPort).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true}), nil
+}
+
+// newModuleRegistry returns the settings of the module registry, with defaults applied.
+func newModuleRegistry(namespace, system, moduleVersion string) *ModuleRegistry {
+	registry := &ModuleRegistry{
+		Host:      moduleRegistryHostname,
+		Namespace: namespace,
+		System:    system,
+		Version:   moduleVersion,
+	}
+
+	if registry.Namespace == "" {
+		registry.Namespace = defaultModuleRegistryNS
+	}
+
+	if registry.System == "" {
+		registry.System = defaultModuleRegistrySys
+	}
+
+	if registry.Version == "" {
+		registry.Version = defaultModuleRegistryVer
+	}
+
+	return registry
+}
+
+// WithModuleRegistry binds the module registry (ModuleRegistryService) to the container, at
+// https://registry.internal, so modules can be pulled as registry.internal/<namespace>/<name>/<system>.
+//
+// A self-signed certificate is generated for the registry, and trusted by Terraform through
+// SSL_CERT_DIR; the system certificates stay trusted.
+//
+// Parameters:
+//   - namespace: The namespace of the modules (defaults to "local")
+//   - system: The target system of the modules (defaults to "generic")
+//   - moduleVersion: The version the modules are published at (defaults to "1.0.0")
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the registry bound
+//   - error: An error if the source directory is not set, or the certificate cannot be generated
+func (m *Infra) WithModuleRegistry(
+	// namespace is the namespace of the modules.
+	// +optional
+	namespace string,
+	// system is the target system of the modules, e.g. "aws".
+	// +optional
+	system string,
+	// moduleVersion is the version the modules are published at.
+	// +optional
+	moduleVersion string,
+) (*Infra, error) {
+	certPEM, keyPEM, err := newSelfSignedCertificate(moduleRegistryHostname)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to generate the module registry certificate")
+	}
+
+	certFile := dag.Directory().WithNewFile("registry.pem", certPEM).File("registry.pem")
+
+	service, err := m.ModuleRegistryService(certFile, newDigestNamedSecret("module-registry-key", keyPEM),
+		namespace, system, moduleVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	m.ModuleRegistry = newModuleRegistry(namespace, system, moduleVersion)
+	m.Ctr = m.Ctr.
+		WithServiceBinding(moduleRegistryHostname, service).
+		WithFile(filepath.Join(moduleRegistryCAPath, "registry.pem"), certFile).
+		WithEnvVariable("SSL_CERT_DIR", "/etc/ssl/certs:"+moduleRegistryCAPath)
+
+	return m, nil
+}
+
+// rewriteModuleSources rewrites the relative sources of the module blocks of a file that point at
+// a top-level module of modules/ to their registry address, and pins the registry version.
+//
+// Parameters:
+//   - fileName: The path of the file, relative to the source directory
+//   - contents: The contents of the file
+//   - registry: The module registry
+//
+// Returns:
+//   - []byte: The rewritten file
+//   - []string: The names of the modules whose sources were rewritten
+//   - error: An error if the file cannot be parsed
+func rewriteModuleSources(fileName string, contents []byte, registry *ModuleRegistry) ([]byte, []string, error) {
+	file, diags := hclwrite.ParseConfig(contents, fileName, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, nil, Errorf("failed to parse %s: %s", fileName, diags.Error())
+	}
+
+	var rewritten []string
+
+	for _, block := range file.Body().Blocks() {
+		if block.Type() != "module" {
+			continue
+		}
+
+		source := block.Body().GetAttribute("source")
+		if source == nil {
+			continue
+		}
+
+		value, ok := getLiteralString(source.Expr().BuildTokens(nil).Bytes())
+		if !ok || !(strings.HasPrefix(value, "./") || strings.HasPrefix(value, "../")) {
+			continue
+		}
+
+		target := path.Clean(path.Join(path.Dir(filepath.ToSlash(fileName)), value))
+
+		name, found := strings.CutPrefix(target, configTerraformModulesRootPath+"/")
+		if !found || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+
+		block.Body().SetAttributeValue("source", cty.StringVal(registry.getModuleAddress(name)))
+		block.Body().SetAttributeValue("version", cty.StringVal(registry.Version))
+		rewritten = append(rewritten, name)
+	}
+
+	return file.Bytes(), rewritten, nil
+}
+
+// getLiteralString returns the value of an expression that is a literal string.
+func getLiteralString(expression []byte) (string, bool) {
+	expr, diags := hclsyntax.ParseExpression(expression, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return "", false
+	}
+
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() || value.Type() != cty.String || value.IsNull() || !value.IsKnown() {
+		return "", false
+	}
+
+	return value.AsString(), true
+}
+
+// ActionTerraformRegistryExamples runs the examples of a module the way consumers use it: through
+// registry addresses.
+//
+// The module registry is bound with WithModuleRegistry unless one is already. In every example of
+// examples/<module>, relative sources pointing at modules/ are rewritten to registry addresses,
+// then 'terraform init' and 'terraform plan' run, so modules are downloaded from the registry.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - tfModulePath: The module whose examples are run, e.g. "default"
+//   - namespace: The namespace of the modules (defaults to "local")
+//   - system: The target system of the modules (defaults to "generic")
+//   - moduleVersion: The version the modules are published at (defaults to "1.0.0")
+//
+// Returns:
+//   - string: The reports of the examples
+//   - error: An error if no example pulls a module through the registry, or an example fails
+func (m *Infra) ActionTerraformRegistryExamples(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// tfModulePath is the path to the Terraform module whose examples are run.
+	tfModulePath string,
+	// namespace is the namespace of the modules.
+	// +optional
+	namespace string,
+	// system is the target system of the modules, e.g. "aws".
+	// +optional
+	system string,
+	// moduleVersion is the version the modules are published at.
+	// +optional
+	moduleVersion string,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	if m.Src == nil {
+		return "", NewError("failed to run the examples, the source directory is nil")
+	}
+
+	if m.ModuleRegistry == nil {
+		if _, err := m.WithModuleRegistry(namespace, system, moduleVersion); err != nil {
+			return "", WrapErrorf(err, "failed to bind the module registry")
+		}
+	}
+
+	tfFiles, err := m.Src.Glob(ctx, filepath.Join(configExamplesRootPath, tfModulePath, "*", "*.tf"))
+	if err != nil {
+		return "", WrapErrorf(err, "failed to list the examples of %s", tfModulePath)
+	}
+
+	filesByExample := map[string][]string{}
+	for _, tfFile := range tfFiles {
+		filesByExample[filepath.Dir(tfFile)] = append(filesByExample[filepath.Dir(tfFile)], tfFile)
+	}
+
+	examples := make([]string, 0, len(filesByExample))
+	for example := range filesByExample {
+		examples = append(examples, example)
+	}
+
+	sort.Strings(examples)
+
+	var reports []string
+
+	for _, example := range examples {
+		exampleCtr := m.Ctr.WithWorkdir(filepath.Join(defaultMntPath, example))
+
+		var pulled []string
+
+		for _, tfFile := range filesByExample[example] {
+			contents, err := m.Src.File(tfFile).Contents(ctx)
+			if err != nil {
+				return "", WrapErrorf(err, "failed to read %s", tfFile)
+			}
+
+			rewrittenFile, rewritten, err := rewriteModuleSources(tfFile, []byte(contents), m.ModuleRegistry)
+			if err != nil {
+				return "", err
+			}
+
+			if len(rewritten) > 0 {
+				exampleCtr = exampleCtr.WithNewFile(filepath.Join(defaultMntPath, tfFile), string(rewrittenFile))
+				pulled = append(pulled, rewritten...)
+			}
+		}
+
+		if len(pulled) == 0 {
+			continue
+		}
+
+		exampleCtr, err = m.runActionCMDs(ctx, exampleCtr,
+			DaggerCMD{"terraform", "init", "-backend=false", "-input=false"},
+			DaggerCMD{"terraform", "plan", "-input=false"},
+		)
+		if err != nil {
+			return "", WrapErrorf(err, "example %s failed through the module registry", example)
+		}
+
+		report, err := m.processActionResult(ctx, example+".registry-plan", exampleCtr)
+		if err != nil {
+			return "", err
+		}
+
+		reports = append(reports, fmt.Sprintf("%s (modules: %s)\n%s", example, strings.Join(pulled, ", "), report))
+	}
+
+	if len(reports) == 0 {
+		return "", Errorf("no example of %s sources a module of %s/", tfModulePath, configTerraformModulesRootPath)
+	}
+
+	return strings.Join(reports, "\n"), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"dagger/infra/internal/dagger"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// netrcFileMode is the file mode of the mounted .netrc file, readable by its owner only.
+const netrcFileMode = 0o600
+
+// NetrcCredential is a single 'machine' entry of the .netrc file.
+type NetrcCredential struct {
+	// Host is the machine the credential applies to (e.g. "github.example.com", "bitbucket.org").
+	Host string
+	// Login is the user name sent to the host.
+	Login string
+	// Password is the password or access token sent to the host.
+	Password *dagger.Secret
+}
+
+// WithNetrcCredential adds a credential for a host to the .netrc file of the container.
+//
+// Any host is supported, including self-hosted GitHub Enterprise, GitLab and Bitbucket instances.
+// A credential for a host that is already configured replaces the previous one. The .netrc file
+// is mounted as a Dagger secret, so the password never enters the layer cache and is redacted
+// from outputs, reports and errors.
+//
+// Parameters:
+//   - ctx: The context for the Dagger container
+//   - host: The machine the credential applies to (e.g. "gitlab.example.com")
+//   - login: The user name sent to the host
+//   - password: The password or access token sent to the host
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the .netrc file mounted
+//   - error: An error if the entry is invalid or the .netrc file cannot be built
+func (m *Infra) WithNetrcCredential(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+	// host is the machine the credential applies to, e.g. "gitlab.example.com".
+	host string,
+	// login is the user name sent to the host.
+	login string,
+	// password is the password or access token sent to the host.
+	password *dagger.Secret,
+) (*Infra, error) {
+	if err := m.addNetrcCredential(host, login, password); err != nil {
+		return nil, err
+	}
+
+	if err := m.mountNetrcFile(ctx); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// WithNetrcCredentials adds several credentials to the .netrc file of the container.
+//
+// Each machine is given as "host:login", and is paired with the password at the same position
+// in 'passwords'. See WithNetrcCredential for the behaviour of each entry.
+//
+// Parameters:
+//   - ctx: The context for the Dagger container
+//   - machines: The machines, as "host:login" (e.g. "github.example.com:ci-bot")
+//   - passwords: The passwords or access tokens, in the same order as the machines
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the .netrc file mounted
+//   - error: An error if an entry is invalid or the .netrc file cannot be built
+func (m *Infra) WithNetrcCredentials(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+	// machines are the machines, as "host:login", e.g. "github.example.com:ci-bot".
+	machines []string,
+	// passwords are the passwords or access tokens, in the same order as the machines.
+	passwords []*dagger.Secret,
+) (*Infra, error) {
+	if len(machines) != len(passwords) {
+		return nil, Errorf("got %d netrc machines but %d passwords, each machine needs exactly one password",
+			len(machines), len(passwords))
+	}
+
+	for i, machine := range machines {
+		host, login, found := strings.Cut(machine, ":")
+		if !found {
+			return nil, Errorf("invalid netrc machine %q, expected 'host:login'", machine)
+		}
+
+		if err := m.addNetrcCredential(host, login, passwords[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.mountNetrcFile(ctx); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// addNetrcCredential validates a credential and records it, replacing the credential previously
+// configured for the same host.
+func (m *Infra) addNetrcCredential(host, login string, password *dagger.Secret) error {
+	host = strings.TrimSpace(host)
+	login = strings.TrimSpace(login)
+
+	if host == "" || login == "" {
+		return Errorf("netrc host and login must not be empty")
+	}
+
+	if strings.ContainsAny(host+login, " \t\r\n") {
+		return Errorf("netrc host %q and login %q must not contain whitespace", host, login)
+	}
+
+	if password == nil {
+		return Errorf("netrc password for host %q must not be empty", host)
+	}
+
+	m.registerSecret(password)
+
+	for _, credential := range m.NetrcCredentials {
+		if strings.EqualFold(credential.Host, host) {
+			credential.Login = login
+			credential.Password = password
+
+			return nil
+		}
+	}
+
+	m.NetrcCredentials = append(m.NetrcCredentials, &NetrcCredential{
+		Host:     host,
+		Login:    login,
+		Password: password,
+	})
+
+	return nil
+}
+
+// mountNetrcFile renders the configured credentials and mounts them as a secret .netrc file.
+// The passwords are only resolved in memory to render the file, which is then stored as a Dagger
+// secret named after its digest, so neither the file nor its name reveal the credentials.
+func (m *Infra) mountNetrcFile(ctx context.Context) error {
+	var netrc strings.Builder
+
+	for _, credential := range m.NetrcCredentials {
+		password, err := credential.Password.Plaintext(ctx)
+		if err != nil {
+			return WrapErrorf(err, "failed to resolve the netrc password for host %q", credential.Host)
+		}
+
+		if strings.ContainsAny(password, " \t\r\n") {
+			return Errorf("netrc password for host %q must not contain whitespace", credential.Host)
+		}
+
+		fmt.Fprintf(&netrc, "machine %s\nlogin %s\npassword %s\n", credential.Host, credential.Login, password)
+	}
+
+	digest := sha256.Sum256([]byte(netrc.String()))
+	netrcSecret := dag.SetSecret("netrc-"+hex.EncodeToString(digest[:8]), netrc.String())
+
+	m.Ctr = m.Ctr.
+		WithMountedSecret(configNetrcRootPath, netrcSecret, dagger.ContainerWithMountedSecretOpts{
+			Mode: netrcFileMode,
+		})
+
+	return nil
+}
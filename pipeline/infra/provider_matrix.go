@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	// providerVersionsOverrideFileName is the override file pinning the provider versions of a combination.
+	providerVersionsOverrideFileName = "pipeline_provider_versions_override.tf"
+	// defaultProviderRegistryHost is the registry of provider sources without a hostname.
+	defaultProviderRegistryHost = "registry.terraform.io"
+	// maxProviderVersionCombinations bounds the size of the provider version matrix.
+	maxProviderVersionCombinations = 32
+)
+
+// terraformRequiredProvider is a provider required by a module.
+type terraformRequiredProvider struct {
+	name       string
+	source     string
+	constraint string
+}
+
+// getProviderSourceParts returns the hostname, namespace and type of a provider source address.
+func getProviderSourceParts(source string) (string, string, string, error) {
+	parts := strings.Split(source, "/")
+
+	switch len(parts) {
+	case 2:
+		return defaultProviderRegistryHost, parts[0], parts[1], nil
+	case 3:
+		return parts[0], parts[1], parts[2], nil
+	default:
+		return "", "", "", Errorf("invalid provider source %q, expected [hostname/]namespace/type", source)
+	}
+}
+
+// parseRequiredProviders reads the required_providers blocks of the .tf files of a module.
+//
+// Parameters:
+//   - files: The contents of the .tf files, by file name
+//
+// Returns:
+//   - []*terraformRequiredProvider: The required providers, sorted by local name
+//   - error: An error if a file or a required_providers entry cannot be parsed
+func parseRequiredProviders(files map[string]string) ([]*terraformRequiredProvider, error) {
+	parser := hclparse.NewParser()
+	providers := map[string]*terraformRequiredProvider{}
+
+	for name, contents := range files {
+		file, diags := parser.ParseHCL([]byte(contents), name)
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		content, _, diags := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+		})
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		for _, terraformBlock := range content.Blocks {
+			terraformContent, _, diags := terraformBlock.Body.PartialContent(&hcl.BodySchema{
+				Blocks: []hcl.BlockHeaderSchema{{Type: "required_providers"}},
+			})
+			if diags.HasErrors() {
+				return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+			}
+
+			for _, requiredProvidersBlock := range terraformContent.Blocks {
+				attrs, diags := requiredProvidersBlock.Body.JustAttributes()
+				if diags.HasErrors() {
+					return nil, Errorf("invalid required_providers in %s: %s", name, diags.Error())
+				}
+
+				for _, attr := range attrs {
+					provider, err := parseRequiredProvider(attr)
+					if err != nil {
+						return nil, Errorf("invalid required_providers in %s: %v", name, err)
+					}
+
+					providers[provider.name] = provider
+				}
+			}
+		}
+	}
+
+	sorted := make([]*terraformRequiredProvider, 0, len(providers))
+	for _, provider := range providers {
+		sorted = append(sorted, provider)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+
+	return sorted, nil
+}
+
+// parseRequiredProvider reads an entry of a required_providers block: an object with source and
+// version, or, in the legacy form, a version constraint string.
+func parseRequiredProvider(attr *hcl.Attribute) (*terraformRequiredProvider, error) {
+	provider := &terraformRequiredProvider{name: attr.Name, source: "hashicorp/" + attr.Name}
+
+	pairs, diags := hcl.ExprMap(attr.Expr)
+	if diags.HasErrors() {
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || value.Type() != cty.String || value.IsNull() {
+			return nil, Errorf("provider %q must be an object with source and version", attr.Name)
+		}
+
+		provider.constraint = value.AsString()
+
+		return provider, nil
+	}
+
+	for _, pair := range pairs {
+		key, diags := pair.Key.Value(nil)
+		if diags.HasErrors() || key.Type() != cty.String {
+			continue
+		}
+
+		target := map[string]*string{"source": &provider.source, "version": &provider.constraint}[key.AsString()]
+		if target == nil {
+			// configuration_aliases does not constrain versions.
+			continue
+		}
+
+		value, diags := pair.Value.Value(nil)
+		if diags.HasErrors() || value.Type() != cty.String || value.IsNull() {
+			return nil, Errorf("the %s of provider %q must be a literal string", key.AsString(), attr.Name)
+		}
+
+		*target = value.AsString()
+	}
+
+	return provider, nil
+}
+
+// fetchURL returns the body of an HTTPS URL, fetched from a container. The response is cached
+// for a day at most.
+func fetchURL(ctx context.Context, url string) (string, error) {
+	body, err := dag.Container().
+		From("alpine:latest").
+		WithEnvVariable("DAGGER_OPT_CACHE_BUSTER", fmt.Sprintf("%d", time.Now().Truncate(24*time.Hour).Unix())).
+		WithExec([]string{"wget", "-qO-", url}).
+		Stdout(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to fetch %s", url)
+	}
+
+	return body, nil
+}
+
+// getProviderVersions lists the versions of a provider published on its registry, discovering
+// the registry's provider API first.
+func getProviderVersions(ctx context.Context, source string) ([]*version.Version, error) {
+	host, namespace, providerType, err := getProviderSourceParts(source)
+	if err != nil {
+		return nil, err
+	}
+
+	discovery, err := fetchURL(ctx, fmt.Sprintf("https://%s/.well-known/terraform.json", host))
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to discover the provider registry of %s", host)
+	}
+
+	var services map[string]any
+	if err := json.Unmarshal([]byte(discovery), &services); err != nil {
+		return nil, Errorf("invalid service discovery document of %s: %v", host, err)
+	}
+
+	providersPath, ok := services["providers.v1"].(string)
+	if !ok {
+		return nil, Errorf("%s does not serve the provider registry protocol", host)
+	}
+
+	if !strings.HasPrefix(providersPath, "https://") {
+		providersPath = "https://" + host + "/" + strings.TrimPrefix(providersPath, "/")
+	}
+
+	listing, err := fetchURL(ctx, fmt.Sprintf("%s/%s/%s/versions", strings.TrimSuffix(providersPath, "/"), namespace, providerType))
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to list the versions of %s", source)
+	}
+
+	var document struct {
+		Versions []struct {
+			Version string `json:"version"`
+		} `json:"versions"`
+	}
+
+	if err := json.Unmarshal([]byte(listing), &document); err != nil {
+		return nil, Errorf("invalid versions of %s: %v", source, err)
+	}
+
+	versions := make([]*version.Version, 0, len(document.Versions))
+
+	for _, published := range document.Versions {
+		parsed, err := version.NewVersion(published.Version)
+		if err == nil {
+			versions = append(versions, parsed)
+		}
+	}
+
+	sort.Sort(version.Collection(versions))
+
+	return versions, nil
+}
+
+// getVersionBounds returns the lowest and highest versions allowed by a constraint. Pre-releases
+// are only allowed when the constraint names one.
+func getVersionBounds(versions []*version.Version, constraint string) (*version.Version, *version.Version, error) {
+	constraints := version.Constraints{}
+
+	if strings.TrimSpace(constraint) != "" {
+		parsed, err := version.NewConstraint(constraint)
+		if err != nil {
+			return nil, nil, Errorf("invalid version constraint %q: %v", constraint, err)
+		}
+
+		constraints = parsed
+	}
+
+	var allowed []*version.Version
+
+	for _, candidate := range versions {
+		if candidate.Prerelease() != "" && !strings.Contains(constraint, candidate.Original()) {
+			continue
+		}
+
+		if constraints.Check(candidate) {
+			allowed = append(allowed, candidate)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, nil, Errorf("no published version matches %q", constraint)
+	}
+
+	return allowed[0], allowed[len(allowed)-1], nil
+}
+
+// renderProviderVersionsOverride renders the override file pinning the provider versions of a
+// combination. Override files replace the required_providers entries of the module.
+func renderProviderVersionsOverride(providers []*terraformRequiredProvider, combination []string) string {
+	file := hclwrite.NewEmptyFile()
+	requiredProviders := file.Body().AppendNewBlock("terraform", nil).Body().AppendNewBlock("required_providers", nil).Body()
+
+	for i, provider := range providers {
+		requiredProviders.SetAttributeValue(provider.name, cty.ObjectVal(map[string]cty.Value{
+			"source":  cty.StringVal(provider.source),
+			"version": cty.StringVal(combination[i]),
+		}))
+	}
+
+	return string(hclwrite.Format(file.Bytes()))
+}
+
+// getProviderVersionCombinations returns every combination of the candidate versions of the
+// providers, in the order of the providers.
+func getProviderVersionCombinations(candidates [][]string) [][]string {
+	combinations := [][]string{{}}
+
+	for _, providerCandidates := range candidates {
+		next := make([][]string, 0, len(combinations)*len(providerCandidates))
+
+		for _, combination := range combinations {
+			for _, candidate := range providerCandidates {
+				next = append(next, append(append([]string{}, combination...), candidate))
+			}
+		}
+
+		combinations = next
+	}
+
+	return combinations
+}
+
+// ActionTerraformProviderVersionMatrix tests a module against the range of provider versions its
+// constraints allow.
+//
+// The required_providers of the module are read, and the lowest and highest published versions
+// allowed by each constraint are looked up on the provider's registry, together with the extra
+// versions given. For every combination, an override file pins the versions, then 'terraform init
+// -upgrade', 'terraform validate' and 'terraform plan' run, in parallel. The report is a
+// compatibility matrix, with the output of every combination. After WithAffectedModules, an
+// unaffected module is reported as skipped.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - tfModulePath: The module to test, e.g. "default"
+//   - extraVersions: Extra versions to test, as "<provider>=<version>", e.g. "random=3.6.1" (optional)
+//   - skipPlan: Whether to stop after validate, e.g. when the plan needs credentials
+//
+// Returns:
+//   - string: The compatibility matrix
+//   - error: An error if the constraints cannot be resolved, or a combination fails
+func (m *Infra) ActionTerraformProviderVersionMatrix(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// tfModulePath is the path to the Terraform module, e.g. "default".
+	tfModulePath string,
+	// extraVersions are extra versions to test, as "<provider>=<version>", e.g. "random=3.6.1".
+	// +optional
+	extraVersions []string,
+	// skipPlan is a flag to stop after validate.
+	// +optional
+	skipPlan bool,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	if !m.isModuleAffected(tfModulePath) {
+		return getNotAffectedReport(tfModulePath, "provider-version-matrix")
+	}
+
+	if m.Src == nil {
+		return "", NewError("failed to build the provider version matrix, the source directory is nil")
+	}
+
+	moduleDir := getTerraformModulesExecutionPath(tfModulePath)
+
+	tfFiles, err := m.readModuleTerraformFiles(ctx, moduleDir)
+	if err != nil {
+		return "", err
+	}
+
+	providers, err := parseRequiredProviders(tfFiles)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to read the required providers of %s", moduleDir)
+	}
+
+	if len(providers) == 0 {
+		return "", Errorf("%s requires no provider", moduleDir)
+	}
+
+	extras := map[string][]string{}
+
+	for _, extra := range extraVersions {
+		name, extraVersion, found := strings.Cut(extra, "=")
+		if !found {
+			return "", Errorf("extra versions must be in the format <provider>=<version>: %q", extra)
+		}
+
+		parsed, err := version.NewVersion(strings.TrimSpace(extraVersion))
+		if err != nil {
+			return "", Errorf("invalid extra version %q: %v", extra, err)
+		}
+
+		extras[strings.TrimSpace(name)] = append(extras[strings.TrimSpace(name)], parsed.String())
+	}
+
+	candidates := make([][]string, 0, len(providers))
+
+	for _, provider := range providers {
+		versions, err := getProviderVersions(ctx, provider.source)
+		if err != nil {
+			return "", err
+		}
+
+		lowest, highest, err := getVersionBounds(versions, provider.constraint)
+		if err != nil {
+			return "", WrapErrorf(err, "failed to resolve the versions of provider %q", provider.name)
+		}
+
+		providerCandidates := []string{lowest.String()}
+		for _, candidate := range append([]string{highest.String()}, extras[provider.name]...) {
+			if !contains(providerCandidates, candidate) {
+				providerCandidates = append(providerCandidates, candidate)
+			}
+		}
+
+		delete(extras, provider.name)
+		candidates = append(candidates, providerCandidates)
+	}
+
+	if len(extras) > 0 {
+		unknown := make([]string, 0, len(extras))
+		for name := range extras {
+			unknown = append(unknown, name)
+		}
+
+		sort.Strings(unknown)
+
+		return "", Errorf("extra versions given for providers %s does not require: %s", moduleDir, strings.Join(unknown, ", "))
+	}
+
+	combinations := getProviderVersionCombinations(candidates)
+	if len(combinations) > maxProviderVersionCombinations {
+		return "", Errorf("%d provider version combinations, more than the %d allowed, test fewer extra versions",
+			len(combinations), maxProviderVersionCombinations)
+	}
+
+	commands := [][]string{
+		{"terraform", "init", "-backend=false", "-input=false", "-upgrade"},
+		{"terraform", "validate"},
+	}
+
+	if !skipPlan {
+		commands = append(commands, []string{"terraform", "plan", "-input=false", "-lock=false", "-no-color"})
+	}
+
+	resultChan := make(chan JobResult, len(combinations))
+	workDirs := make([]string, len(combinations))
+
+	for i, combination := range combinations {
+		pins := make([]string, len(providers))
+		for j, provider := range providers {
+			pins[j] = provider.name + "@" + combination[j]
+		}
+
+		workDirs[i] = fmt.Sprintf("%s[%s].matrix", tfModulePath, strings.Join(pins, ","))
+
+		combinationCtr := m.Ctr.
+			WithWorkdir(filepath.Join(defaultMntPath, moduleDir)).
+			WithNewFile(providerVersionsOverrideFileName, renderProviderVersionsOverride(providers, combination))
+
+		go m.executeDaggerCtrAsync(ctx, resultChan, combinationCtr, workDirs[i], commands)
+	}
+
+	results := make([]JobResult, 0, len(combinations))
+	statuses := make(map[string]string, len(combinations))
+
+	for range combinations {
+		result := <-resultChan
+		results = append(results, result)
+
+		statuses[result.WorkDir] = "compatible"
+		if result.Err != nil {
+			statuses[result.WorkDir] = "incompatible"
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].WorkDir < results[j].WorkDir
+	})
+
+	var matrix strings.Builder
+
+	table := tabwriter.NewWriter(&matrix, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "%s\tRESULT\n", strings.ToUpper(strings.Join(getProviderNames(providers), "\t")))
+
+	for i, combination := range combinations {
+		fmt.Fprintf(table, "%s\t%s\n", strings.Join(combination, "\t"), statuses[workDirs[i]])
+	}
+
+	_ = table.Flush()
+
+	report, err := ProcessActionSyncResults(results)
+	report = fmt.Sprintf("Provider version matrix of %s:\n%s\n%s", moduleDir, matrix.String(), report)
+
+	if err != nil {
+		return m.redact(ctx, report), m.redactError(ctx, WrapErrorf(err,
+			"provider version combinations failed for %s:\n%s", moduleDir, matrix.String()))
+	}
+
+	return m.redact(ctx, report), nil
+}
+
+// getProviderNames returns the local names of providers.
+func getProviderNames(providers []*terraformRequiredProvider) []string {
+	names := make([]string, len(providers))
+	for i, provider := range providers {
+		names[i] = provider.name
+	}
+
+	return names
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+)
+
+// TestGetVersionBounds verifies that the lowest and highest published versions allowed by a
+// constraint are selected, and that pre-releases are skipped unless the constraint names them.
+func TestGetVersionBounds(t *testing.T) {
+	t.Parallel()
+
+	var versions []*version.Version
+
+	for _, raw := range []string{"3.0.0", "3.5.1", "3.6.0", "3.6.2", "4.0.0-beta1", "4.0.0", "4.1.0", "5.0.0-rc1"} {
+		versions = append(versions, version.Must(version.NewVersion(raw)))
+	}
+
+	sort.Sort(version.Collection(versions))
+
+	tests := []struct {
+		name        string
+		constraint  string
+		wantLowest  string
+		wantHighest string
+		wantErr     bool
+	}{
+		{name: "no constraint", constraint: "", wantLowest: "3.0.0", wantHighest: "4.1.0"},
+		{name: "lower bound", constraint: ">= 3.6", wantLowest: "3.6.0", wantHighest: "4.1.0"},
+		{name: "range", constraint: ">= 3.5, < 4.0", wantLowest: "3.5.1", wantHighest: "3.6.2"},
+		{name: "pessimistic minor", constraint: "~> 3.5", wantLowest: "3.5.1", wantHighest: "3.6.2"},
+		{name: "pessimistic patch", constraint: "~> 3.6.0", wantLowest: "3.6.0", wantHighest: "3.6.2"},
+		{name: "exact", constraint: "= 4.0.0", wantLowest: "4.0.0", wantHighest: "4.0.0"},
+		{name: "named pre-release", constraint: "4.0.0-beta1", wantLowest: "4.0.0-beta1", wantHighest: "4.0.0-beta1"},
+		{name: "exclusion", constraint: ">= 4.0, != 4.1.0", wantLowest: "4.0.0", wantHighest: "4.0.0"},
+		{name: "no published version", constraint: ">= 6.0", wantErr: true},
+		{name: "invalid constraint", constraint: ">= three", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lowest, highest, err := getVersionBounds(versions, tt.constraint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getVersionBounds(%q) error = %v, wantErr %v", tt.constraint, err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if lowest.Original() != tt.wantLowest || highest.Original() != tt.wantHighest {
+				t.Errorf("getVersionBounds(%q) = (%s, %s), want (%s, %s)",
+					tt.constraint, lowest.Original(), highest.Original(), tt.wantLowest, tt.wantHighest)
+			}
+		})
+	}
+}
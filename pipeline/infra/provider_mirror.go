@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// configProviderMirrorPath is where a filesystem mirror is mounted.
+	configProviderMirrorPath = "/root/.terraform.d/provider-mirror"
+	// defaultProviderMirrorPlatform is the platform mirrored when none is given.
+	defaultProviderMirrorPlatform = "linux_amd64"
+)
+
+// terraformPlatformPattern matches Terraform platform names, e.g. "linux_amd64".
+var terraformPlatformPattern = regexp.MustCompile(`^[a-z0-9]+_[a-z0-9]+$`)
+
+// ActionTerraformProvidersMirror runs 'terraform providers mirror' for a set of modules, and
+// returns the mirror as a directory.
+//
+// The mirror holds the providers (and versions) required by every module, for every platform,
+// in the packed layout, which both filesystem mirrors and network mirrors (served over HTTPS)
+// accept. It can be exported, or copied into a cache volume for WithProviderMirror.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - tfModulePaths: The modules to mirror the providers of (defaults to every module, or the affected ones, see
+//     WithAffectedModules)
+//   - platforms: The platforms to mirror, e.g. "linux_arm64" (defaults to "linux_amd64")
+//   - cacheVolume: The name of a cache volume the mirror is also copied to (optional)
+//
+// Returns:
+//   - *dagger.Directory: The provider mirror
+//   - error: An error if a platform is invalid, or the mirror cannot be built
+func (m *Infra) ActionTerraformProvidersMirror(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// tfModulePaths are the modules to mirror the providers of, e.g. "default". Every module when empty.
+	// +optional
+	tfModulePaths []string,
+	// platforms are the platforms to mirror, e.g. "linux_amd64", "darwin_arm64".
+	// +optional
+	platforms []string,
+	// cacheVolume is the name of a cache volume the mirror is also copied to, e.g. "terraform-provider-mirror".
+	// +optional
+	cacheVolume string,
+) (*dagger.Directory, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	if len(tfModulePaths) == 0 {
+		moduleNames, err := m.getSelectedModuleNames(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tfModulePaths = moduleNames
+	}
+
+	if len(platforms) == 0 {
+		platforms = []string{defaultProviderMirrorPlatform}
+	}
+
+	mirrorCmd := DaggerCMD{"terraform", "providers", "mirror"}
+
+	for _, platform := range platforms {
+		if !terraformPlatformPattern.MatchString(platform) {
+			return nil, Errorf("invalid platform %q, expected <os>_<arch>, e.g. linux_amd64", platform)
+		}
+
+		mirrorCmd = append(mirrorCmd, "-platform="+platform)
+	}
+
+	mirrorCmd = append(mirrorCmd, configProviderMirrorPath)
+
+	// Modules are mirrored one after the other into the same directory.
+	mirror := dag.Directory()
+
+	for _, tfModulePath := range tfModulePaths {
+		moduleCtr := m.Ctr.
+			WithDirectory(configProviderMirrorPath, mirror).
+			WithWorkdir(filepath.Join(defaultMntPath, getTerraformModulesExecutionPath(tfModulePath)))
+
+		moduleCtr, err := m.runActionCMDs(ctx, moduleCtr,
+			DaggerCMD{"terraform", "init", "-backend=false", "-input=false"},
+			mirrorCmd,
+		)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to mirror the providers of %s", tfModulePath)
+		}
+
+		mirror = moduleCtr.Directory(configProviderMirrorPath)
+	}
+
+	if cacheVolume != "" {
+		if _, err := dag.Container().
+			From("alpine:latest").
+			WithMountedDirectory("/mirror", mirror).
+			WithMountedCache("/cache", dag.CacheVolume(cacheVolume)).
+			WithExec([]string{"cp", "-R", "/mirror/.", "/cache/"}).
+			Sync(ctx); err != nil {
+			return nil, WrapErrorf(err, "failed to copy the mirror to the cache volume %s", cacheVolume)
+		}
+	}
+
+	return mirror, nil
+}
+
+// WithProviderMirror switches provider installation to a mirror only, so init needs no access to
+// the origin registries (offline mode).
+//
+// The mirror is either a directory (e.g. exported by ActionTerraformProvidersMirror), a cache
+// volume holding one, or the URL of a network mirror. It becomes the only method of the
+// provider_installation block of the generated CLI configuration (see the WithTerraformCLIConfig*
+// functions). CHECKPOINT_DISABLE is set, so Terraform does not check for new versions.
+//
+// Parameters:
+//   - mirror: A provider mirror directory (optional)
+//   - cacheVolume: The name of a cache volume holding a provider mirror (optional)
+//   - networkMirrorURL: The HTTPS URL of a network mirror (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the mirror configured
+//   - error: An error if not exactly one mirror is given, or the URL is invalid
+func (m *Infra) WithProviderMirror(
+	// mirror is a provider mirror directory, e.g. exported by action-terraform-providers-mirror.
+	// +optional
+	mirror *dagger.Directory,
+	// cacheVolume is the name of a cache volume holding a provider mirror.
+	// +optional
+	cacheVolume string,
+	// networkMirrorURL is the HTTPS URL of a network mirror, e.g. "https://mirror.example.com/providers/".
+	// +optional
+	networkMirrorURL string,
+) (*Infra, error) {
+	given := 0
+
+	for _, set := range []bool{mirror != nil, cacheVolume != "", networkMirrorURL != ""} {
+		if set {
+			given++
+		}
+	}
+
+	if given != 1 {
+		return nil, NewError("exactly one of mirror, cacheVolume and networkMirrorURL must be set")
+	}
+
+	method := &ProviderInstallationMethod{Method: providerInstallationFilesystemMirror, Location: configProviderMirrorPath}
+
+	switch {
+	case mirror != nil:
+		m.Ctr = m.Ctr.WithMountedDirectory(configProviderMirrorPath, mirror)
+	case cacheVolume != "":
+		m.Ctr = m.Ctr.WithMountedCache(configProviderMirrorPath, dag.CacheVolume(cacheVolume))
+	default:
+		parsed, err := url.Parse(networkMirrorURL)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return nil, Errorf("invalid network mirror URL %q, Terraform requires an https:// URL", networkMirrorURL)
+		}
+
+		if !strings.HasSuffix(networkMirrorURL, "/") {
+			networkMirrorURL += "/"
+		}
+
+		method = &ProviderInstallationMethod{Method: providerInstallationNetworkMirror, Location: networkMirrorURL}
+	}
+
+	// The mirror replaces every other installation method.
+	m.getTerraformCLIConfig().ProviderInstallation = []*ProviderInstallationMethod{method}
+	m.Ctr = m.Ctr.WithEnvVariable("CHECKPOINT_DISABLE", "1")
+
+	return m.writeTerraformCLIConfig(), nil
+}
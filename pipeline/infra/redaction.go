@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"dagger/infra/internal/dagger"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// redactedPlaceholder replaces every secret value found in outputs, reports and errors.
+	redactedPlaceholder = "***"
+	// minRedactableSecretLength is the minimum length of a secret value to be redacted. Shorter
+	// values would match ordinary text and make the output unreadable.
+	minRedactableSecretLength = 4
+)
+
+// secretRedactor scrubs known secret values, and their common encodings, from text.
+type secretRedactor struct {
+	replacer *strings.Replacer
+	size     int // size is the number of registered secrets the redactor was built from.
+}
+
+// getSecretVariants returns the forms in which a secret value can show up in an output: the raw
+// value, its URL-encoded forms, and its base64 (standard and URL-safe) encodings. Base64 variants
+// are computed for the three possible alignments, so the value is also found when it is encoded as
+// part of a larger payload (e.g. "user:password" in a basic auth header).
+func getSecretVariants(value string) []string {
+	variants := map[string]struct{}{value: {}}
+
+	variants[url.QueryEscape(value)] = struct{}{}
+	variants[url.PathEscape(value)] = struct{}{}
+
+	for shift := 0; shift < 3; shift++ {
+		payload := append(make([]byte, shift), value...)
+		encoded := strings.TrimRight(base64.StdEncoding.EncodeToString(payload), "=")
+
+		// Skip the characters that also encode the padding bytes, and the last character when it
+		// also encodes what follows the value.
+		start := (shift*8 + 5) / 6
+		end := len(encoded)
+
+		if len(payload)%3 != 0 {
+			end--
+		}
+
+		if end-start < minRedactableSecretLength {
+			continue
+		}
+
+		variant := encoded[start:end]
+		variants[variant] = struct{}{}
+		variants[strings.NewReplacer("+", "-", "/", "_").Replace(variant)] = struct{}{}
+	}
+
+	result := make([]string, 0, len(variants))
+	for variant := range variants {
+		if len(variant) >= minRedactableSecretLength {
+			result = append(result, variant)
+		}
+	}
+
+	return result
+}
+
+// newSecretRedactor builds a redactor for the given secret values.
+func newSecretRedactor(values []string) *secretRedactor {
+	var variants []string
+
+	for _, value := range values {
+		if len(value) < minRedactableSecretLength {
+			continue
+		}
+
+		variants = append(variants, getSecretVariants(value)...)
+	}
+
+	// Longer variants first, so a secret that contains another one is fully redacted.
+	sort.Slice(variants, func(i, j int) bool {
+		return len(variants[i]) > len(variants[j])
+	})
+
+	oldNew := make([]string, 0, len(variants)*2)
+	for _, variant := range variants {
+		oldNew = append(oldNew, variant, redactedPlaceholder)
+	}
+
+	return &secretRedactor{
+		replacer: strings.NewReplacer(oldNew...),
+		size:     len(values),
+	}
+}
+
+// redact replaces every known secret value in the text.
+func (r *secretRedactor) redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+
+	return r.replacer.Replace(text)
+}
+
+// registerSecret records a secret injected into the container, so its value is redacted from
+// outputs, reports and errors.
+func (m *Infra) registerSecret(secret *dagger.Secret) {
+	if secret == nil {
+		return
+	}
+
+	m.InjectedSecrets = append(m.InjectedSecrets, secret)
+}
+
+// registerSecretValue records a secret value passed as a plain string, so it is redacted from
+// outputs, reports and errors. The value is kept as a Dagger secret, never as plain text.
+func (m *Infra) registerSecretValue(value string) {
+	if len(value) < minRedactableSecretLength {
+		return
+	}
+
+	digest := sha256.Sum256([]byte(value))
+	secretName := "redacted-" + hex.EncodeToString(digest[:8])
+
+	m.registerSecret(dag.SetSecret(secretName, value))
+}
+
+// newDigestNamedSecret stores a value as a Dagger secret named after its digest, so distinct values
+// never share a name.
+func newDigestNamedSecret(name, value string) *dagger.Secret {
+	if value == "" {
+		return nil
+	}
+
+	digest := sha256.Sum256([]byte(value))
+
+	return dag.SetSecret(name+"-"+hex.EncodeToString(digest[:8]), value)
+}
+
+// WithRedactedSecrets registers additional secrets whose values must be redacted from outputs,
+// reports and errors, even though they were not injected by the Infra module.
+//
+// Parameters:
+//   - secrets: The secrets to redact
+//
+// Returns:
+//   - *Infra: The updated Infra instance
+func (m *Infra) WithRedactedSecrets(
+	// secrets are the secrets whose values must be redacted.
+	secrets []*dagger.Secret,
+) *Infra {
+	for _, secret := range secrets {
+		m.registerSecret(secret)
+	}
+
+	return m
+}
+
+// getSecretRedactor returns a redactor for every secret registered on the Infra instance. The
+// redactor is rebuilt only when new secrets were registered since it was last built.
+func (m *Infra) getSecretRedactor(ctx context.Context) (*secretRedactor, error) {
+	m.redactorMu.Lock()
+	defer m.redactorMu.Unlock()
+
+	if m.redactor != nil && m.redactor.size == len(m.InjectedSecrets) {
+		return m.redactor, nil
+	}
+
+	values := make([]string, 0, len(m.InjectedSecrets))
+
+	for _, secret := range m.InjectedSecrets {
+		value, err := secret.Plaintext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve a registered secret for redaction: %w", err)
+		}
+
+		values = append(values, value)
+	}
+
+	m.redactor = newSecretRedactor(values)
+
+	return m.redactor, nil
+}
+
+// redact scrubs every registered secret value, and its common encodings, from the text.
+// Redaction still happens when the context is cancelled, and if the secrets cannot be resolved,
+// the text is withheld rather than returned unredacted.
+func (m *Infra) redact(ctx context.Context, text string) string {
+	if len(m.InjectedSecrets) == 0 || text == "" {
+		return text
+	}
+
+	redactor, err := m.getSecretRedactor(context.WithoutCancel(ctx))
+	if err != nil {
+		return "(output withheld: secrets could not be resolved for redaction)"
+	}
+
+	return redactor.redact(text)
+}
+
+// redactError returns an error whose message has every registered secret value scrubbed.
+// The error chain is flattened into a single ModuleError, so the original, unredacted message
+// cannot be recovered by unwrapping it.
+func (m *Infra) redactError(ctx context.Context, err error) error {
+	if err == nil || len(m.InjectedSecrets) == 0 {
+		return err
+	}
+
+	message := err.Error()
+
+	var me *ModuleError
+	if errors.As(err, &me) {
+		message = strings.TrimPrefix(message, fmt.Sprintf("%s [%s] ", ErrorEmoji, ModuleName))
+	}
+
+	return NewError(m.redact(ctx, message))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// terraformTokenEnvVarPrefix is the prefix of the environment variables Terraform reads
+	// registry credentials from.
+	terraformTokenEnvVarPrefix = "TF_TOKEN_"
+)
+
+// RegistryToken is the API token of a private Terraform registry host.
+type RegistryToken struct {
+	// Host is the registry hostname, in its normalized (punycode, lower case) form.
+	Host string
+	// Token is the API token sent to the registry.
+	Token *dagger.Secret
+}
+
+// normalizeRegistryHost returns the form of a registry hostname Terraform uses to look up
+// credentials: lower case, with internationalized labels converted to punycode.
+func normalizeRegistryHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimSpace(host), ".")
+	if host == "" {
+		return "", Errorf("registry host must not be empty")
+	}
+
+	if strings.Contains(host, "://") || strings.ContainsAny(host, ":/ ") {
+		return "", Errorf("invalid registry host %q, expected a bare hostname without scheme, port or path", host)
+	}
+
+	normalized, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", WrapErrorf(err, "invalid registry host %q", host)
+	}
+
+	return strings.ToLower(normalized), nil
+}
+
+// getTerraformTokenEnvVarName returns the TF_TOKEN_<host> environment variable name for a
+// registry host, following Terraform's encoding rules: internationalized hosts are converted to
+// punycode, dashes are encoded as double underscores, and dots as single underscores.
+// E.g. "app.terraform.io" -> "TF_TOKEN_app_terraform_io", "café.fr" -> "TF_TOKEN_xn____caf__dma_fr".
+func getTerraformTokenEnvVarName(host string) (string, error) {
+	normalized, err := normalizeRegistryHost(host)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := strings.NewReplacer("-", "__", ".", "_").Replace(normalized)
+
+	return terraformTokenEnvVarPrefix + encoded, nil
+}
+
+// WithRegistryToken sets the API token of a private Terraform registry host.
+//
+// The token is set as the TF_TOKEN_<host> environment variable, encoded following Terraform's
+// rules for dots, dashes and internationalized hosts. A token for a host that is already
+// configured replaces the previous one.
+//
+// Parameters:
+//   - host: The registry hostname (e.g. "registry.example.com")
+//   - token: The API token sent to the registry
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the registry token set
+//   - error: An error if the host is invalid
+func (m *Infra) WithRegistryToken(
+	// host is the registry hostname, e.g. "registry.example.com".
+	host string,
+	// token is the API token sent to the registry.
+	token *dagger.Secret,
+) (*Infra, error) {
+	if token == nil {
+		return nil, Errorf("registry token for host %q must not be empty", host)
+	}
+
+	envVarName, err := getTerraformTokenEnvVarName(host)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, _ := normalizeRegistryHost(host)
+
+	m.Ctr = m.Ctr.WithSecretVariable(envVarName, token)
+	m.registerSecret(token)
+
+	for _, registryToken := range m.RegistryTokens {
+		if registryToken.Host == normalized {
+			registryToken.Token = token
+
+			return m, nil
+		}
+	}
+
+	m.RegistryTokens = append(m.RegistryTokens, &RegistryToken{Host: normalized, Token: token})
+
+	return m, nil
+}
+
+// WithRegistryTokens sets the API tokens of several private Terraform registry hosts.
+//
+// Each host is paired with the token at the same position in 'tokens'. See WithRegistryToken
+// for the encoding of each host.
+//
+// Parameters:
+//   - hosts: The registry hostnames
+//   - tokens: The API tokens, in the same order as the hosts
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the registry tokens set
+//   - error: An error if a host is invalid or hosts and tokens do not match
+func (m *Infra) WithRegistryTokens(
+	// hosts are the registry hostnames, e.g. "registry.example.com".
+	hosts []string,
+	// tokens are the API tokens, in the same order as the hosts.
+	tokens []*dagger.Secret,
+) (*Infra, error) {
+	if len(hosts) != len(tokens) {
+		return nil, Errorf("got %d registry hosts but %d tokens, each host needs exactly one token",
+			len(hosts), len(tokens))
+	}
+
+	for i, host := range hosts {
+		if _, err := m.WithRegistryToken(host, tokens[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// WithRegistryCredentialsCLIConfig emits the credentials of every registry token configured with
+// WithRegistryToken in the generated CLI configuration (see WithTerraformCLIConfigCredentials).
+//
+// It is meant for tools that read the CLI configuration but not the TF_TOKEN_<host> variables.
+// The credentials are mounted as a secret, so the tokens never enter the layer cache.
+//
+// Parameters:
+//   - ctx: The context for the Dagger container
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the CLI configuration mounted
+//   - error: An error if no registry token is configured or a token cannot be resolved
+func (m *Infra) WithRegistryCredentialsCLIConfig(
+	// ctx is the context for the Dagger container.
+	// +optional
+	ctx context.Context,
+) (*Infra, error) {
+	if len(m.RegistryTokens) == 0 {
+		return nil, Errorf("no registry token configured, use WithRegistryToken first")
+	}
+
+	if _, err := m.withTerraformCLICredentials(ctx); err != nil {
+		return nil, err
+	}
+
+	return m.writeTerraformCLIConfig(), nil
+}
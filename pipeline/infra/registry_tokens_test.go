@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// TestGetTerraformTokenEnvVarName verifies that registry hosts are encoded following Terraform's
+// rules for dots, dashes, case and internationalized hosts.
+func TestGetTerraformTokenEnvVarName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{name: "dots", host: "app.terraform.io", want: "TF_TOKEN_app_terraform_io"},
+		{name: "dashes", host: "my-registry.example.com", want: "TF_TOKEN_my__registry_example_com"},
+		{name: "upper case and trailing dot", host: " Registry.Example.COM. ", want: "TF_TOKEN_registry_example_com"},
+		{name: "internationalized host", host: "café.fr", want: "TF_TOKEN_xn____caf__dma_fr"},
+		{name: "empty host", host: "  ", wantErr: true},
+		{name: "scheme", host: "https://registry.example.com", wantErr: true},
+		{name: "port", host: "registry.example.com:8443", wantErr: true},
+		{name: "path", host: "registry.example.com/v1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := getTerraformTokenEnvVarName(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getTerraformTokenEnvVarName(%q) error = %v, wantErr %v", tt.host, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("getTerraformTokenEnvVarName(%q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultResultCacheVolume is the cache volume the passes of the actions are stored in.
+	defaultResultCacheVolume = "terraform-module-results"
+	// configResultCachePath is where the result cache volume is mounted.
+	configResultCachePath = "/results"
+)
+
+// ResultCache skips the actions whose inputs did not change since they last passed.
+type ResultCache struct {
+	// CacheVolume is the name of the cache volume the passes are stored in.
+	CacheVolume string
+	// Force runs every action, and records the passes.
+	Force bool
+}
+
+// WithResultCache skips the static analysis, lint, docs and version compatibility actions of a
+// module when their inputs did not change since they last passed.
+//
+// The inputs are hashed: the files of the module (its .tf files, lock file and tool
+// configuration), the files of the local modules it calls, recursively, the Terraform version,
+// and the versions of the tools the action installs. A pass is stored in the cache volume under
+// the hash, with its report, and a later run with the same hash reports a "cached pass", followed
+// by the last passing output, instead of running the action. Failures are not stored. Credentials and environment variables are not inputs, so the
+// result cache is meant for checks that do not depend on them.
+//
+// Parameters:
+//   - cacheVolume: The name of the cache volume the passes are stored in (defaults to "terraform-module-results")
+//   - force: Whether to run every action regardless of the stored passes
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the result cache enabled
+func (m *Infra) WithResultCache(
+	// cacheVolume is the name of the cache volume the passes are stored in.
+	// +optional
+	cacheVolume string,
+	// force is a flag to run every action regardless of the stored passes, e.g. for a nightly full run.
+	// +optional
+	force bool,
+) *Infra {
+	if cacheVolume == "" {
+		cacheVolume = defaultResultCacheVolume
+	}
+
+	m.ResultCache = &ResultCache{CacheVolume: cacheVolume, Force: force}
+
+	return m
+}
+
+// getLocalModuleDirs returns the directory of a module and of the local modules it calls,
+// recursively, in order. Local sources outside of the source directory are not followed.
+func (m *Infra) getLocalModuleDirs(ctx context.Context, moduleDir string) ([]string, error) {
+	var dirs []string
+
+	pending := []string{filepath.Clean(moduleDir)}
+
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+
+		if contains(dirs, dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			continue
+		}
+
+		tfFiles, err := m.Src.Glob(ctx, filepath.Join(dir, "*.tf"))
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to list the Terraform files of %s", dir)
+		}
+
+		if len(tfFiles) == 0 {
+			continue
+		}
+
+		dirs = append(dirs, dir)
+
+		files, err := m.readModuleTerraformFiles(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := parseTerraformVersionsTarget(dir, files)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the module calls of %s", dir)
+		}
+
+		pending = append(pending, target.calls...)
+	}
+
+	sort.Strings(dirs)
+
+	return dirs, nil
+}
+
+// getTerraformVersion returns the version of Terraform installed in the container.
+func (m *Infra) getTerraformVersion(ctx context.Context) (string, error) {
+	output, err := m.Ctr.
+		WithEnvVariable("CHECKPOINT_DISABLE", "1").
+		WithExec([]string{"terraform", "version", "-json"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", WrapError(err, "failed to get the Terraform version")
+	}
+
+	var terraformVersion struct {
+		Version string `json:"terraform_version"`
+	}
+
+	if err := json.Unmarshal([]byte(output), &terraformVersion); err != nil {
+		return "", Errorf("invalid output of 'terraform version -json': %v", err)
+	}
+
+	return terraformVersion.Version, nil
+}
+
+// getModuleInputsHash returns the content hash of the inputs of an action on a module.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - tfModulePath: The module, e.g. "default"
+//   - action: The name of the action, e.g. "lint"
+//   - toolVersions: The versions of the tools the action uses, e.g. "tflint=0.58.0"
+//
+// Returns:
+//   - string: The hex-encoded SHA-256 of the inputs
+//   - error: An error if the files of the modules cannot be read
+func (m *Infra) getModuleInputsHash(ctx context.Context, tfModulePath, action string, toolVersions []string) (string, error) {
+	terraformVersion, err := m.getTerraformVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	dirs, err := m.getLocalModuleDirs(ctx, getTerraformModulesExecutionPath(tfModulePath))
+	if err != nil {
+		return "", err
+	}
+
+	inputs := []string{"action=" + action, "terraform=" + terraformVersion}
+	for _, toolVersion := range toolVersions {
+		inputs = append(inputs, "tool="+toolVersion)
+	}
+
+	for _, dir := range dirs {
+		digest, err := m.Src.Directory(dir).Digest(ctx)
+		if err != nil {
+			return "", WrapErrorf(err, "failed to hash %s", dir)
+		}
+
+		inputs = append(inputs, "dir="+dir+"@"+digest)
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(inputs, "\n")))
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// cachedPass is the pass of an action stored in the result cache, under the hash of its inputs.
+type cachedPass struct {
+	// PassedAt is the time of the pass, in RFC 3339 format.
+	PassedAt string `json:"passed_at"`
+	// Report is the report of the pass, with secret values redacted.
+	Report string `json:"report"`
+}
+
+// getCachedPass returns the stored pass of an action, or nil when there is none. Entries that
+// cannot be read are ignored, so the action runs again and replaces them.
+func (m *Infra) getCachedPass(ctx context.Context, passPath string) (*cachedPass, error) {
+	stored, err := dag.Container().
+		From("alpine:latest").
+		WithMountedCache(configResultCachePath, dag.CacheVolume(m.ResultCache.CacheVolume)).
+		// The lookup must not be served from the layer cache, as the volume changes.
+		WithEnvVariable("DAGGER_OPT_CACHE_BUSTER", fmt.Sprintf("%d", time.Now().UnixNano())).
+		WithExec([]string{"sh", "-c", `cat "$1" 2>/dev/null || true`, "sh", passPath}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to look up %s in the result cache", passPath)
+	}
+
+	var pass cachedPass
+	if err := json.Unmarshal([]byte(stored), &pass); err != nil || pass.PassedAt == "" {
+		return nil, nil
+	}
+
+	return &pass, nil
+}
+
+// storePass records the pass of an action in the result cache, together with its report.
+func (m *Infra) storePass(ctx context.Context, passPath, report string) error {
+	pass, err := json.Marshal(cachedPass{PassedAt: time.Now().UTC().Format(time.RFC3339), Report: report})
+	if err != nil {
+		return WrapErrorf(err, "failed to encode %s", passPath)
+	}
+
+	if _, err := dag.Container().
+		From("alpine:latest").
+		WithMountedCache(configResultCachePath, dag.CacheVolume(m.ResultCache.CacheVolume)).
+		WithNewFile("/tmp/pass", string(pass)).
+		WithExec([]string{"sh", "-c", `mkdir -p "$(dirname "$1")" && cp /tmp/pass "$1"`, "sh", passPath}).
+		Sync(ctx); err != nil {
+		return WrapErrorf(err, "failed to store %s in the result cache", passPath)
+	}
+
+	return nil
+}
+
+// formatCachedPass renders the output of a cached pass: when the inputs last passed, followed by
+// the report of that pass.
+func formatCachedPass(hash string, pass *cachedPass) string {
+	return fmt.Sprintf("cached pass: inputs %s unchanged since the pass of %s\n\nLast passing output:\n%s",
+		hash[:12], pass.PassedAt, pass.Report)
+}
+
+// runWithResultCache runs an action on a module, unless the result cache holds a pass for the
+// same inputs, in which case a "cached pass" is reported instead. Passes are recorded. A module
+// that is not affected (see WithAffectedModules) is reported as skipped.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - tfModulePath: The module, e.g. "default"
+//   - action: The name of the action, e.g. "lint"
+//   - toolVersions: The versions of the tools the action uses, e.g. "tflint=0.58.0"
+//   - noCache: Whether the caller disabled caching, which forces the run
+//   - run: The action, returning its report
+//
+// Returns:
+//   - string: The report of the action, or of the cached pass
+//   - error: An error if the action fails, or the inputs cannot be hashed
+func (m *Infra) runWithResultCache(
+	ctx context.Context,
+	tfModulePath, action string,
+	toolVersions []string,
+	noCache bool,
+	run func() (string, error),
+) (string, error) {
+	if !m.isModuleAffected(tfModulePath) {
+		return getNotAffectedReport(tfModulePath, action)
+	}
+
+	if m.ResultCache == nil {
+		return run()
+	}
+
+	hash, err := m.getModuleInputsHash(ctx, tfModulePath, action, toolVersions)
+	if err != nil {
+		return "", m.redactError(ctx, WrapErrorf(err, "failed to hash the inputs of %s.%s", tfModulePath, action))
+	}
+
+	passPath := filepath.Join(configResultCachePath, action, tfModulePath, hash)
+
+	if !m.ResultCache.Force && !noCache {
+		pass, err := m.getCachedPass(ctx, passPath)
+		if err != nil {
+			return "", m.redactError(ctx, err)
+		}
+
+		if pass != nil {
+			return ProcessActionSyncResults([]JobResult{{
+				WorkDir: tfModulePath + "." + action,
+				Output:  formatCachedPass(hash, pass),
+			}})
+		}
+	}
+
+	report, err := run()
+	if err != nil {
+		return report, err
+	}
+
+	if err := m.storePass(ctx, passPath, report); err != nil {
+		return report + fmt.Sprintf("warning: the pass was not cached: %v\n", err), nil
+	}
+
+	return report, nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Defaults for the retry policy applied to steps that download providers or modules.
+	defaultRetryAttempts          = 3
+	defaultRetryBackoffSeconds    = 2
+	defaultRetryMaxBackoffSeconds = 30
+	defaultRetryJitterPercent     = 20
+	// retryAttemptEnvVar is set on retried steps so Dagger does not reuse the result of a failed attempt.
+	retryAttemptEnvVar = "TF_PIPELINE_RETRY_ATTEMPT"
+)
+
+// RetryPolicy describes how steps that download providers or modules are retried
+// when they fail with a transient error (flaky registry, GitHub or network failures).
+type RetryPolicy struct {
+	// Attempts is the total number of attempts for a step, including the first one.
+	Attempts int
+	// BackoffSeconds is the wait before the first retry. It doubles on every subsequent retry.
+	BackoffSeconds int
+	// MaxBackoffSeconds caps the exponential backoff between attempts.
+	MaxBackoffSeconds int
+	// JitterPercent randomises every wait by up to this percentage (0-100).
+	JitterPercent int
+}
+
+// StepAttempt records a single execution attempt of a command within a job.
+type StepAttempt struct {
+	Command     string        // Command is the command line that was executed.
+	Attempt     int           // Attempt is the 1-based attempt number.
+	ExitCode    int           // ExitCode is the exit code of the attempt, or -1 if it is unknown.
+	Transient   bool          // Transient reports whether the failure was classified as transient.
+	Reason      string        // Reason is the diagnostic that classified the failure.
+	Backoff     time.Duration // Backoff is the wait applied before the next attempt.
+	Duration    time.Duration // Duration is how long the attempt ran.
+	TimedOut    bool          // TimedOut reports whether the attempt was stopped by a timeout.
+	OutputBytes int           // OutputBytes is the amount of output the attempt produced before failing.
+}
+
+// String returns a single-line, human-readable representation of the attempt.
+func (a StepAttempt) String() string {
+	if a.Reason == "" {
+		return fmt.Sprintf("[%d] %s: succeeded in %s", a.Attempt, a.Command, a.Duration.Round(time.Millisecond))
+	}
+
+	if a.TimedOut {
+		line := fmt.Sprintf("[%d] %s: TIMED OUT after %s with %d bytes of output (%s: %s)",
+			a.Attempt, a.Command, a.Duration.Round(time.Second), a.OutputBytes, failureClassification(a.Transient), a.Reason)
+		if a.Backoff > 0 {
+			line += fmt.Sprintf(", retrying in %s", a.Backoff)
+		}
+
+		return line
+	}
+
+	line := fmt.Sprintf("[%d] %s: exit code %d (%s: %s)", a.Attempt, a.Command, a.ExitCode, failureClassification(a.Transient), a.Reason)
+	if a.Backoff > 0 {
+		line += fmt.Sprintf(", retrying in %s", a.Backoff)
+	}
+
+	return line
+}
+
+// transientFailurePatterns match Terraform diagnostics and HTTP/network errors that are worth retrying.
+var transientFailurePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)bad response code: (5\d\d|429)`),
+	regexp.MustCompile(`(?i)\b(500 internal server error|502 bad gateway|503 service unavailable|504 gateway timeout|429 too many requests)\b`),
+	regexp.MustCompile(`(?i)the requested url returned error: (5\d\d|429)`),
+	regexp.MustCompile(`(?i)failed to request discovery document`),
+	regexp.MustCompile(`(?i)could not connect to registry`),
+	regexp.MustCompile(`(?i)client\.timeout exceeded while awaiting headers`),
+	regexp.MustCompile(`(?i)tls handshake timeout`),
+	regexp.MustCompile(`(?i)i/o timeout`),
+	regexp.MustCompile(`(?i)connection reset by peer`),
+	regexp.MustCompile(`(?i)connection refused`),
+	regexp.MustCompile(`(?i)temporary failure in name resolution`),
+	regexp.MustCompile(`(?i)unexpected eof`),
+	regexp.MustCompile(`(?i)the remote end hung up unexpectedly`),
+	regexp.MustCompile(`(?i)early eof`),
+	regexp.MustCompile(`(?i)rpc failed`),
+}
+
+// permanentFailurePatterns match failures that will not go away by retrying. They take
+// precedence over transientFailurePatterns.
+var permanentFailurePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)no available releases match`),
+	regexp.MustCompile(`(?i)does not have a provider named`),
+	regexp.MustCompile(`(?i)bad response code: 40[134]`),
+	regexp.MustCompile(`(?i)\b(401 unauthorized|403 forbidden|404 not found)\b`),
+	regexp.MustCompile(`(?i)permission denied \(publickey\)`),
+	regexp.MustCompile(`(?i)authentication failed`),
+}
+
+// retryableSteps are the commands that download providers, modules or plugins, and are
+// therefore the only ones subject to the retry policy.
+var retryableSteps = []DaggerCMD{
+	{"terraform", "init"},
+	{"terraform", "get"},
+	{"terraform", "providers", "mirror"},
+	{"terraform", "providers", "lock"},
+	{"tflint", "--init"},
+}
+
+// newRetryPolicy builds a RetryPolicy, applying defaults to unset values and validating the result.
+func newRetryPolicy(attempts, backoffSeconds, maxBackoffSeconds, jitterPercent int) (*RetryPolicy, error) {
+	policy := &RetryPolicy{
+		Attempts:          attempts,
+		BackoffSeconds:    backoffSeconds,
+		MaxBackoffSeconds: maxBackoffSeconds,
+		JitterPercent:     jitterPercent,
+	}
+
+	if policy.Attempts == 0 {
+		policy.Attempts = defaultRetryAttempts
+	}
+
+	if policy.BackoffSeconds == 0 {
+		policy.BackoffSeconds = defaultRetryBackoffSeconds
+	}
+
+	if policy.MaxBackoffSeconds == 0 {
+		policy.MaxBackoffSeconds = defaultRetryMaxBackoffSeconds
+	}
+
+	if policy.Attempts < 1 {
+		return nil, Errorf("retry attempts must be at least 1, got %d", policy.Attempts)
+	}
+
+	if policy.BackoffSeconds < 0 || policy.MaxBackoffSeconds < 0 {
+		return nil, Errorf("retry backoff must not be negative, got %ds (max %ds)", policy.BackoffSeconds, policy.MaxBackoffSeconds)
+	}
+
+	if policy.MaxBackoffSeconds < policy.BackoffSeconds {
+		return nil, Errorf("retry max backoff (%ds) must not be lower than the initial backoff (%ds)", policy.MaxBackoffSeconds, policy.BackoffSeconds)
+	}
+
+	if policy.JitterPercent < 0 || policy.JitterPercent > 100 {
+		return nil, Errorf("retry jitter must be a percentage between 0 and 100, got %d", policy.JitterPercent)
+	}
+
+	return policy, nil
+}
+
+// backoff returns the wait before the attempt that follows the given (1-based) failed attempt.
+// The wait grows exponentially from BackoffSeconds, is capped at MaxBackoffSeconds, and is
+// randomised by up to JitterPercent in either direction.
+func (p *RetryPolicy) backoff(failedAttempt int) time.Duration {
+	wait := time.Duration(p.BackoffSeconds) * time.Second
+	maxWait := time.Duration(p.MaxBackoffSeconds) * time.Second
+
+	for i := 1; i < failedAttempt && wait < maxWait; i++ {
+		wait *= 2
+	}
+
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	if p.JitterPercent > 0 && wait > 0 {
+		spread := int64(wait) * int64(p.JitterPercent) / 100
+		//nolint:gosec // Jitter does not need a cryptographically secure source.
+		wait += time.Duration(rand.Int64N(2*spread+1) - spread)
+	}
+
+	return wait
+}
+
+// isRetryableStep reports whether a command downloads providers, modules or plugins.
+func isRetryableStep(cmd DaggerCMD) bool {
+	for _, step := range retryableSteps {
+		if len(cmd) >= len(step) && strings.Join(cmd[:len(step)], " ") == strings.Join(step, " ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// classifyStepFailure inspects the output of a failed step and reports whether the failure
+// is transient, together with the diagnostic that matched.
+func classifyStepFailure(output string) (transient bool, reason string) {
+	for _, pattern := range permanentFailurePatterns {
+		if match := pattern.FindString(output); match != "" {
+			return false, match
+		}
+	}
+
+	for _, pattern := range transientFailurePatterns {
+		if match := pattern.FindString(output); match != "" {
+			return true, match
+		}
+	}
+
+	return false, "no transient failure detected"
+}
+
+// failureClassification returns the label used in reports for a failure classification.
+func failureClassification(transient bool) string {
+	if transient {
+		return "transient"
+	}
+
+	return "permanent"
+}
+
+// WithRetryPolicy configures the retry policy used for steps that download providers and modules
+// (terraform init, terraform get, terraform providers mirror/lock and tflint --init).
+//
+// Only failures matching known transient Terraform diagnostics and HTTP/network errors are retried.
+// Unset values fall back to 3 attempts, a 2s initial backoff, a 30s maximum backoff and no jitter.
+//
+// Parameters:
+//   - attempts: Total number of attempts for a step, including the first one
+//   - backoffSeconds: Wait before the first retry, doubled on every subsequent retry
+//   - maxBackoffSeconds: Upper bound for the wait between attempts
+//   - jitterPercent: Randomises every wait by up to this percentage
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the retry policy set
+//   - error: An error if the policy is invalid
+func (m *Infra) WithRetryPolicy(
+	// attempts is the total number of attempts for a step, including the first one.
+	// +optional
+	attempts int,
+	// backoffSeconds is the wait before the first retry, doubled on every subsequent retry.
+	// +optional
+	backoffSeconds int,
+	// maxBackoffSeconds is the upper bound for the wait between attempts.
+	// +optional
+	maxBackoffSeconds int,
+	// jitterPercent randomises every wait by up to this percentage.
+	// +optional
+	jitterPercent int,
+) (*Infra, error) {
+	policy, err := newRetryPolicy(attempts, backoffSeconds, maxBackoffSeconds, jitterPercent)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to configure the retry policy")
+	}
+
+	m.RetryPolicy = policy
+
+	return m, nil
+}
+
+// WithDefaultRetryPolicy configures the retry policy with its default values and jitter enabled.
+func (m *Infra) WithDefaultRetryPolicy() *Infra {
+	m.RetryPolicy = &RetryPolicy{
+		Attempts:          defaultRetryAttempts,
+		BackoffSeconds:    defaultRetryBackoffSeconds,
+		MaxBackoffSeconds: defaultRetryMaxBackoffSeconds,
+		JitterPercent:     defaultRetryJitterPercent,
+	}
+
+	return m
+}
+
+// execDaggerCMDWithRetry executes a single command, retrying it while it fails with a
+// transient error and attempts remain. A step that exceeds its step timeout is considered
+// transient, as it usually means a download hung. Every attempt is returned, and when it gives
+// up the returned error lists them all.
+func (m *Infra) execDaggerCMDWithRetry(
+	ctx context.Context,
+	container *dagger.Container,
+	command DaggerCMD,
+) (*dagger.Container, []StepAttempt, error) {
+	policy := m.RetryPolicy
+	commandLine := strings.Join(command, " ")
+
+	var attempts []StepAttempt
+
+	for attempt := 1; ; attempt++ {
+		attemptCtr := container
+		if attempt > 1 {
+			attemptCtr = attemptCtr.
+				WithEnvVariable(retryAttemptEnvVar, fmt.Sprintf("%d-%d", attempt, time.Now().UnixNano()))
+		}
+
+		started := time.Now()
+		attemptCtr, err := m.execStep(ctx, attemptCtr, command)
+
+		if err == nil {
+			attempts = append(attempts, StepAttempt{Command: commandLine, Attempt: attempt, Duration: time.Since(started)})
+
+			return attemptCtr, attempts, nil
+		}
+
+		record := newFailedStepAttempt(commandLine, attempt, err)
+
+		if ctx.Err() != nil || !record.Transient || attempt >= policy.Attempts {
+			attempts = append(attempts, record)
+
+			return nil, attempts, WrapErrorf(err, "step '%s' failed after %d attempt(s)\n%s", commandLine, attempt, formatStepAttempts(attempts))
+		}
+
+		record.Backoff = policy.backoff(attempt)
+		attempts = append(attempts, record)
+
+		select {
+		case <-ctx.Done():
+			return nil, attempts, WrapErrorf(ctx.Err(), "step '%s' cancelled while waiting to retry\n%s", commandLine, formatStepAttempts(attempts))
+		case <-time.After(record.Backoff):
+		}
+	}
+}
+
+// newFailedStepAttempt builds the record of a failed attempt, classifying the failure.
+func newFailedStepAttempt(commandLine string, attempt int, err error) StepAttempt {
+	record := StepAttempt{
+		Command:  commandLine,
+		Attempt:  attempt,
+		ExitCode: -1,
+	}
+
+	var stepErr *stepError
+	if !errors.As(err, &stepErr) {
+		record.Reason = err.Error()
+
+		return record
+	}
+
+	record.ExitCode = stepErr.exitCode
+	record.Duration = stepErr.elapsed
+	record.TimedOut = stepErr.timedOut
+	record.OutputBytes = len(stepErr.output)
+
+	switch {
+	case stepErr.cancelled:
+		record.Reason = "cancelled by the action context"
+	case stepErr.timedOut:
+		record.Transient, record.Reason = true, fmt.Sprintf("step timed out after %s", stepErr.limit)
+	default:
+		record.Transient, record.Reason = classifyStepFailure(stepErr.output)
+	}
+
+	return record
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestClassifyStepFailure verifies that transient registry and network failures are retried,
+// and that permanent failures take precedence over transient ones.
+func TestClassifyStepFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		output        string
+		wantTransient bool
+		wantReason    string
+	}{
+		{
+			name:          "registry server error",
+			output:        "Error: Failed to query available provider packages\n\nbad response code: 503",
+			wantTransient: true,
+			wantReason:    "bad response code: 503",
+		},
+		{
+			name:          "rate limited",
+			output:        "the requested URL returned error: 429",
+			wantTransient: true,
+			wantReason:    "the requested URL returned error: 429",
+		},
+		{
+			name:          "network reset",
+			output:        "read tcp 10.0.0.2:443: connection reset by peer",
+			wantTransient: true,
+			wantReason:    "connection reset by peer",
+		},
+		{
+			name:          "unknown provider version",
+			output:        "no available releases match the given constraints >= 99.0",
+			wantTransient: false,
+			wantReason:    "no available releases match",
+		},
+		{
+			name:          "permanent failure wins over a transient one",
+			output:        "bad response code: 404\nconnection refused",
+			wantTransient: false,
+			wantReason:    "bad response code: 404",
+		},
+		{
+			name:          "unrelated failure",
+			output:        "Error: Unsupported argument",
+			wantTransient: false,
+			wantReason:    "no transient failure detected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			transient, reason := classifyStepFailure(tt.output)
+			if transient != tt.wantTransient || reason != tt.wantReason {
+				t.Errorf("classifyStepFailure() = (%v, %q), want (%v, %q)", transient, reason, tt.wantTransient, tt.wantReason)
+			}
+		})
+	}
+}
+
+// TestRetryPolicyBackoff verifies that the backoff doubles on every retry and is capped by the
+// maximum backoff.
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := &RetryPolicy{Attempts: 6, BackoffSeconds: 2, MaxBackoffSeconds: 10}
+
+	tests := []struct {
+		failedAttempt int
+		want          time.Duration
+	}{
+		{failedAttempt: 1, want: 2 * time.Second},
+		{failedAttempt: 2, want: 4 * time.Second},
+		{failedAttempt: 3, want: 8 * time.Second},
+		{failedAttempt: 4, want: 10 * time.Second},
+		{failedAttempt: 5, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.backoff(tt.failedAttempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failedAttempt, got, tt.want)
+		}
+	}
+}
+
+// TestRetryPolicyBackoffJitter verifies that the jitter keeps the backoff within its percentage.
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	t.Parallel()
+
+	policy := &RetryPolicy{Attempts: 3, BackoffSeconds: 10, MaxBackoffSeconds: 10, JitterPercent: 20}
+
+	for range 100 {
+		if got := policy.backoff(1); got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("backoff(1) = %s, want between 8s and 12s", got)
+		}
+	}
+}
+
+// TestNewRetryPolicy verifies the defaults and the validation of the retry policy.
+func TestNewRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                                                string
+		attempts, backoffSeconds, maxBackoffSeconds, jitter int
+		wantErr                                             bool
+	}{
+		{name: "defaults"},
+		{name: "custom", attempts: 5, backoffSeconds: 1, maxBackoffSeconds: 4, jitter: 10},
+		{name: "no attempt", attempts: -1, wantErr: true},
+		{name: "negative backoff", backoffSeconds: -1, wantErr: true},
+		{name: "max lower than initial", backoffSeconds: 10, maxBackoffSeconds: 5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			policy, err := newRetryPolicy(tt.attempts, tt.backoffSeconds, tt.maxBackoffSeconds, tt.jitter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRetryPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && policy.Attempts < 1 {
+				t.Errorf("newRetryPolicy() attempts = %d, want at least 1", policy.Attempts)
+			}
+		})
+	}
+}
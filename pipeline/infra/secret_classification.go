@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// secretSourceEnvVars is the source recorded for the variables passed as envVars.
+	secretSourceEnvVars = "envVars"
+	// Rules recorded in the secret classification report
+	secretRuleSecretKey   = "secret-key"
+	secretRuleFilePattern = "file"
+	secretRuleKeyPattern  = "key-pattern"
+)
+
+var (
+	// defaultSecretKeyPatterns are the key patterns classified as secret when no classification is
+	// configured.
+	defaultSecretKeyPatterns = []string{
+		"*TOKEN*", "*SECRET*", "*PASSWORD*", "*PASSWD*", "*PRIVATE_KEY*", "*ACCESS_KEY*", "*API_KEY*", "*CREDENTIAL*",
+	}
+	// defaultSecretFilePatterns are the dotenv file name patterns whose variables are all secret.
+	defaultSecretFilePatterns = []string{"*secret*"}
+)
+
+// SecretClassification decides which variables passed as plain strings (envVars, dotenv files)
+// are set as Dagger secrets instead of environment variables, which land in the layer cache.
+//
+// Rules apply in this order: PlainKeys, SecretKeys, FilePatterns, then KeyPatterns. Key rules are
+// case-insensitive; file patterns match the base name of the dotenv file.
+type SecretClassification struct {
+	// KeyPatterns are the key patterns classified as secret, e.g. "*_TOKEN" or "AWS_SECRET_*".
+	KeyPatterns []string
+	// SecretKeys are the keys always classified as secret.
+	SecretKeys []string
+	// PlainKeys are the keys never classified as secret, whatever the other rules say.
+	PlainKeys []string
+	// FilePatterns are the dotenv file name patterns whose variables are all secret.
+	FilePatterns []string
+}
+
+// SecretClassificationResult records a variable classified as secret, and why. The value is
+// never recorded.
+type SecretClassificationResult struct {
+	// Key is the variable name.
+	Key string
+	// Source is where the variable came from: "envVars" or the dotenv file.
+	Source string
+	// Rule is the rule that classified the variable, e.g. "key-pattern:*_TOKEN".
+	Rule string
+}
+
+// getDefaultSecretClassification returns the classification used when none is configured.
+func getDefaultSecretClassification() *SecretClassification {
+	return &SecretClassification{
+		KeyPatterns:  append([]string(nil), defaultSecretKeyPatterns...),
+		FilePatterns: append([]string(nil), defaultSecretFilePatterns...),
+	}
+}
+
+// validateSecretPatterns checks that every pattern is a valid glob pattern.
+func validateSecretPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			return Errorf("empty secret classification pattern")
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Errorf("invalid secret classification pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// matchSecretPattern returns the first pattern matching a value, or "".
+func matchSecretPattern(patterns []string, value string) string {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(value)); matched {
+			return pattern
+		}
+	}
+
+	return ""
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, candidate := range keys {
+		if strings.EqualFold(candidate, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// classify reports whether a variable is secret, and the rule that decided it. file is the dotenv
+// file the variable comes from, or "" for envVars.
+func (c *SecretClassification) classify(key, file string) (bool, string) {
+	if containsKey(c.PlainKeys, key) {
+		return false, ""
+	}
+
+	if containsKey(c.SecretKeys, key) {
+		return true, secretRuleSecretKey
+	}
+
+	if file != "" {
+		if pattern := matchSecretPattern(c.FilePatterns, filepath.Base(file)); pattern != "" {
+			return true, secretRuleFilePattern + ":" + pattern
+		}
+	}
+
+	if pattern := matchSecretPattern(c.KeyPatterns, key); pattern != "" {
+		return true, secretRuleKeyPattern + ":" + pattern
+	}
+
+	return false, ""
+}
+
+// WithSecretClassification configures which variables passed as envVars or dotenv files are set
+// as secrets. The rules apply to every later WithEnvVars and WithDotEnvFile call.
+//
+// Parameters:
+//   - keyPatterns: Key patterns classified as secret, e.g. "*_TOKEN" (optional)
+//   - secretKeys: Keys always classified as secret (optional)
+//   - plainKeys: Keys never classified as secret (optional)
+//   - filePatterns: Dotenv file name patterns whose variables are all secret (optional)
+//   - replaceDefaults: Whether the patterns replace the default ones instead of extending them
+//
+// Returns:
+//   - *Infra: The updated Infra instance
+//   - error: An error if a pattern is invalid
+func (m *Infra) WithSecretClassification(
+	// keyPatterns are the key patterns classified as secret, e.g. "*_TOKEN" or "AWS_SECRET_*".
+	// +optional
+	keyPatterns []string,
+	// secretKeys are the keys always classified as secret.
+	// +optional
+	secretKeys []string,
+	// plainKeys are the keys never classified as secret.
+	// +optional
+	plainKeys []string,
+	// filePatterns are the dotenv file name patterns whose variables are all secret, e.g. "*.secret.env".
+	// +optional
+	filePatterns []string,
+	// replaceDefaults replaces the default patterns instead of extending them.
+	// +optional
+	replaceDefaults bool,
+) (*Infra, error) {
+	if err := validateSecretPatterns(append(append([]string(nil), keyPatterns...), filePatterns...)); err != nil {
+		return nil, err
+	}
+
+	classification := &SecretClassification{}
+	if !replaceDefaults {
+		classification = getDefaultSecretClassification()
+	}
+
+	classification.KeyPatterns = append(classification.KeyPatterns, keyPatterns...)
+	classification.FilePatterns = append(classification.FilePatterns, filePatterns...)
+	classification.SecretKeys = secretKeys
+	classification.PlainKeys = plainKeys
+
+	m.SecretClassification = classification
+
+	return m, nil
+}
+
+// classifySecret classifies a variable with the configured rules, or the default ones. It returns
+// whether the variable is secret, and the rule that decided it. Callers record the variables they
+// actually set as secrets with recordClassifiedSecret.
+func (m *Infra) classifySecret(key, file string) (bool, string) {
+	classification := m.SecretClassification
+	if classification == nil {
+		classification = getDefaultSecretClassification()
+	}
+
+	return classification.classify(key, file)
+}
+
+// recordClassifiedSecret records a secret variable, replacing any earlier record of the same key.
+func (m *Infra) recordClassifiedSecret(result *SecretClassificationResult) {
+	for i, existing := range m.ClassifiedSecrets {
+		if existing.Key == result.Key {
+			m.ClassifiedSecrets[i] = result
+
+			return
+		}
+	}
+
+	m.ClassifiedSecrets = append(m.ClassifiedSecrets, result)
+}
+
+// forgetClassifiedSecret removes the record of a variable that is set again as a plain variable.
+func (m *Infra) forgetClassifiedSecret(key string) {
+	for i, existing := range m.ClassifiedSecrets {
+		if existing.Key == key {
+			m.ClassifiedSecrets = append(m.ClassifiedSecrets[:i], m.ClassifiedSecrets[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// SecretClassificationReport lists the variables set as secrets, with their source and the rule
+// that classified them. Values are not shown.
+//
+// Returns:
+//   - string: One line per secret variable
+func (m *Infra) SecretClassificationReport() string {
+	if len(m.ClassifiedSecrets) == 0 {
+		return "no variable classified as secret"
+	}
+
+	sorted := append([]*SecretClassificationResult(nil), m.ClassifiedSecrets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	var builder strings.Builder
+
+	builder.WriteString("variables set as secrets (values hidden):\n")
+
+	for _, result := range sorted {
+		builder.WriteString(fmt.Sprintf("  %s from %s (%s)\n", result.Key, result.Source, result.Rule))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+// TestSecretClassificationClassify verifies the order in which the classification rules apply:
+// plain keys, secret keys, file patterns, then key patterns.
+func TestSecretClassificationClassify(t *testing.T) {
+	t.Parallel()
+
+	classification := getDefaultSecretClassification()
+	classification.KeyPatterns = append(classification.KeyPatterns, "*_DSN")
+	classification.SecretKeys = []string{"license"}
+	classification.PlainKeys = []string{"TF_TOKEN_TTL"}
+
+	tests := []struct {
+		name       string
+		key        string
+		file       string
+		wantSecret bool
+		wantRule   string
+	}{
+		{name: "default key pattern", key: "GITHUB_TOKEN", wantSecret: true, wantRule: "key-pattern:*TOKEN*"},
+		{name: "key patterns are case-insensitive", key: "db_password", wantSecret: true, wantRule: "key-pattern:*PASSWORD*"},
+		{name: "custom key pattern", key: "DATABASE_DSN", wantSecret: true, wantRule: "key-pattern:*_DSN"},
+		{name: "secret key", key: "LICENSE", wantSecret: true, wantRule: "secret-key"},
+		{name: "plain key wins over the key patterns", key: "TF_TOKEN_TTL", wantSecret: false},
+		{name: "plain variable", key: "AWS_REGION", wantSecret: false},
+		{name: "secret file", key: "AWS_REGION", file: "modules/default/.secret.env", wantSecret: true, wantRule: "file:*secret*"},
+		{name: "plain key wins over the file patterns", key: "TF_TOKEN_TTL", file: ".secret.env", wantSecret: false},
+		{name: "file patterns do not apply to envVars", key: "SECRET_LEVEL_NAME", wantSecret: true, wantRule: "key-pattern:*SECRET*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			secret, rule := classification.classify(tt.key, tt.file)
+			if secret != tt.wantSecret || rule != tt.wantRule {
+				t.Errorf("classify(%q, %q) = (%v, %q), want (%v, %q)", tt.key, tt.file, secret, rule, tt.wantSecret, tt.wantRule)
+			}
+		})
+	}
+}
+
+// TestWithSecretClassification verifies that the configured patterns extend or replace the default
+// ones, and that invalid patterns are rejected.
+func TestWithSecretClassification(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		keyPatterns     []string
+		replaceDefaults bool
+		key             string
+		wantSecret      bool
+		wantErr         bool
+	}{
+		{name: "extends the defaults", keyPatterns: []string{"*_DSN"}, key: "API_TOKEN", wantSecret: true},
+		{name: "replaces the defaults", keyPatterns: []string{"*_DSN"}, replaceDefaults: true, key: "API_TOKEN"},
+		{name: "invalid pattern", keyPatterns: []string{"[invalid"}, wantErr: true},
+		{name: "empty pattern", keyPatterns: []string{" "}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m, err := (&Infra{}).WithSecretClassification(tt.keyPatterns, nil, nil, nil, tt.replaceDefaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithSecretClassification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if secret, _ := m.classifySecret(tt.key, ""); secret != tt.wantSecret {
+				t.Errorf("classifySecret(%q) = %v, want %v", tt.key, secret, tt.wantSecret)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	_ "embed"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// Image used to compile the Go services shipped with this module.
+	defaultGoImage = "golang:1.23-alpine"
+	// Image used to run the compiled Go services.
+	defaultServiceImage = "alpine:latest"
+	// Flaky stand-in server
+	flakyServerName     = "flakyserver"
+	flakyServerHostname = "flaky"
+	flakyServerPort     = 8080
+)
+
+//go:embed services/flakyserver/main.go
+var flakyServerSource string
+
+// buildGoServiceContainer compiles a single-file Go program shipped with this module and
+// returns a minimal container with the resulting binary set as its entrypoint.
+//
+// Parameters:
+//   - name: The name of the binary, used as the file name in /usr/local/bin
+//   - source: The contents of the Go program (package main)
+//
+// Returns:
+//   - *dagger.Container: A container ready to be turned into a Dagger service
+func buildGoServiceContainer(name, source string) *dagger.Container {
+	binaryPath := "/out/" + name
+
+	binary := dag.Container().
+		From(defaultGoImage).
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithNewFile("/src/main.go", source).
+		WithWorkdir("/src").
+		WithExec([]string{"go", "build", "-o", binaryPath, "main.go"}).
+		File(binaryPath)
+
+	return dag.Container().
+		From(defaultServiceImage).
+		WithFile("/usr/local/bin/"+name, binary).
+		WithEntrypoint([]string{"/usr/local/bin/" + name})
+}
+
+// FlakyServerService returns a stand-in HTTP server that deliberately fails.
+//
+// The server exposes a minimal Terraform module archive at http://<host>:8080/module.zip, and
+// answers the first 'failures' requests with 'statusCode'. It is meant to exercise the retry
+// policy configured with WithRetryPolicy.
+//
+// Parameters:
+//   - failures: Number of requests that fail before the server recovers (defaults to 2)
+//   - statusCode: HTTP status code returned by failing requests (defaults to 503)
+//
+// Returns:
+//   - *dagger.Service: The stand-in server as a Dagger service
+func (m *Infra) FlakyServerService(
+	// failures is the number of requests that fail before the server recovers.
+	// +default=2
+	failures int,
+	// statusCode is the HTTP status code returned by failing requests.
+	// +default=503
+	statusCode int,
+) *dagger.Service {
+	return buildGoServiceContainer(flakyServerName, flakyServerSource).
+		WithEnvVariable("FLAKY_FAILURES", strconv.Itoa(failures)).
+		WithEnvVariable("FLAKY_STATUS_CODE", strconv.Itoa(statusCode)).
+		WithEnvVariable("PORT", strconv.Itoa(flakyServerPort)).
+		WithExposedPort(flakyServerPort).
+		AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true})
+}
+
+// ActionTerraformRetryPolicyVerification verifies the retry policy against the flaky stand-in server.
+//
+// It runs 'terraform init' on a configuration whose only module is downloaded from
+// FlakyServerService, which fails the first 'failures' requests. With a retry policy that allows
+// more attempts than failures, the init succeeds and the report lists every attempt.
+// When no retry policy is configured, one that allows failures+1 attempts is used.
+func (m *Infra) ActionTerraformRetryPolicyVerification(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// failures is the number of requests the stand-in server fails before recovering.
+	// +default=2
+	failures int,
+	// statusCode is the HTTP status code returned by failing requests.
+	// +default=503
+	statusCode int,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	if len(registryHosts) > 0 || len(registryTokens) > 0 {
+		if _, err := m.WithRegistryTokens(registryHosts, registryTokens); err != nil {
+			return "", WrapErrorf(err, "failed to set the registry tokens")
+		}
+	}
+
+	if m.RetryPolicy == nil {
+		policy, err := newRetryPolicy(failures+1, 1, defaultRetryMaxBackoffSeconds, 0)
+		if err != nil {
+			return "", WrapErrorf(err, "failed to configure the retry policy for the verification")
+		}
+
+		m.RetryPolicy = policy
+	}
+
+	probeConfig := fmt.Sprintf(`module "flaky" {
+  source = "http://%s:%d/module.zip"
+}
+`, flakyServerHostname, flakyServerPort)
+
+	probeDir := "/tmp/retry-policy-verification"
+
+	// The server is started explicitly so its request counter survives across attempts.
+	flakyServer, err := m.FlakyServerService(failures, statusCode).Start(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to start the flaky stand-in server")
+	}
+
+	defer func() {
+		_, _ = flakyServer.Stop(ctx)
+	}()
+
+	probeCtr := m.Ctr.
+		WithServiceBinding(flakyServerHostname, flakyServer).
+		WithNewFile(probeDir+"/main.tf", probeConfig).
+		WithWorkdir(probeDir)
+
+	probeCtr, err = m.runActionCMDs(ctx, probeCtr, DaggerCMD{"terraform", "init", "-backend=false"})
+	if err != nil {
+		return "", WrapErrorf(err, "retry policy verification failed")
+	}
+
+	return m.processActionResult(ctx, "retry-policy.init", probeCtr)
+}
@@ -0,0 +1,96 @@
+// Package main implements a stand-in HTTP server that deliberately fails.
+//
+// It serves a minimal Terraform module archive at /module.zip, but answers the first
+// FLAKY_FAILURES requests with FLAKY_STATUS_CODE (503 by default). It is used by the
+// Infra pipeline to verify that transient download failures are retried.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const moduleSource = `output "retried" {
+  description = "Always true, the module was downloaded after the stand-in server recovered."
+  value       = true
+}
+`
+
+func main() {
+	failures := getEnvInt("FLAKY_FAILURES", 2)
+	statusCode := getEnvInt("FLAKY_STATUS_CODE", http.StatusServiceUnavailable)
+	port := os.Getenv("PORT")
+
+	if port == "" {
+		port = "8080"
+	}
+
+	archive, err := buildModuleArchive()
+	if err != nil {
+		log.Fatalf("failed to build the module archive: %v", err)
+	}
+
+	var requests atomic.Int64
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/module.zip", func(w http.ResponseWriter, r *http.Request) {
+		request := requests.Add(1)
+		if request <= int64(failures) {
+			log.Printf("request %d for %s: failing deliberately with %d", request, r.URL.Path, statusCode)
+			http.Error(w, http.StatusText(statusCode), statusCode)
+
+			return
+		}
+
+		log.Printf("request %d for %s: serving module archive", request, r.URL.Path)
+		w.Header().Set("Content-Type", "application/zip")
+		_, _ = w.Write(archive)
+	})
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("flaky server listening on :%s, failing the first %d request(s) with %d", port, failures, statusCode)
+	log.Fatal(server.ListenAndServe())
+}
+
+// buildModuleArchive returns a zip archive containing a single-file Terraform module.
+func buildModuleArchive() ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer := zip.NewWriter(&buf)
+
+	file, err := writer.Create("main.tf")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Write([]byte(moduleSource)); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// getEnvInt reads an integer environment variable, falling back to a default value.
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,345 @@
+// Package main implements a Terraform HTTP state backend.
+//
+// States are served at /state/<name>: GET reads a state, POST writes it, DELETE removes it, and
+// LOCK / UNLOCK take and release its lock, as expected by Terraform's "http" backend with its
+// default methods. States and locks are stored as files under DATA_DIR, so they survive restarts
+// when the directory is a cache volume. When HTTP_BACKEND_USERNAME and HTTP_BACKEND_PASSWORD are
+// set, requests must use basic authentication. It is used by the Infra pipeline to run modules
+// end to end against a real backend, with locking, without a cloud account.
+package main
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // Terraform sends Content-MD5 to detect corrupted uploads.
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stateFileName = "terraform.tfstate"
+	lockFileName  = "lock.json"
+	// maxStateSize bounds the size of a state upload.
+	maxStateSize = 64 << 20
+)
+
+// statePathPattern matches state names: slash-separated segments that do not start with a dot.
+var statePathPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// lockInfo is the part of Terraform's lock information used by the server.
+type lockInfo struct {
+	ID string `json:"ID"`
+}
+
+// backend serves the states stored under a directory. A single mutex serialises every request,
+// which is enough for integration runs.
+type backend struct {
+	mu       sync.Mutex
+	dataDir  string
+	username string
+	password string
+}
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "/var/lib/tfstate"
+	}
+
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		log.Fatalf("failed to create the data directory: %v", err)
+	}
+
+	b := &backend{
+		dataDir:  dataDir,
+		username: os.Getenv("HTTP_BACKEND_USERNAME"),
+		password: os.Getenv("HTTP_BACKEND_PASSWORD"),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state/", b.handleState)
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("http backend listening on :%s, storing states in %s", port, dataDir)
+	log.Fatal(server.ListenAndServe())
+}
+
+// handleState dispatches a request on a state to its method handler.
+func (b *backend) handleState(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="terraform"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/state/")
+	if !statePathPattern.MatchString(name) {
+		http.Error(w, "invalid state name", http.StatusBadRequest)
+
+		return
+	}
+
+	dir := filepath.Join(b.dataDir, filepath.FromSlash(name))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		b.getState(w, dir)
+	case http.MethodPost:
+		b.putState(w, r, dir)
+	case http.MethodDelete:
+		b.deleteState(w, r, dir)
+	case "LOCK":
+		b.lockState(w, r, dir)
+	case "UNLOCK":
+		b.unlockState(w, r, dir)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	log.Printf("%s %s", r.Method, name)
+}
+
+// authorized checks the basic authentication credentials, when the server requires them.
+func (b *backend) authorized(r *http.Request) bool {
+	if b.username == "" && b.password == "" {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(b.username)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(b.password)) == 1
+
+	return userMatch && passwordMatch
+}
+
+// getState returns the stored state. Terraform reads a 404 as "no state yet".
+func (b *backend) getState(w http.ResponseWriter, dir string) {
+	state, err := os.ReadFile(filepath.Join(dir, stateFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(state)
+}
+
+// putState stores a state. When the state is locked, the request must carry the lock ID.
+func (b *backend) putState(w http.ResponseWriter, r *http.Request, dir string) {
+	if !b.holdsLock(w, dir, r.URL.Query().Get("ID")) {
+		return
+	}
+
+	state, err := io.ReadAll(io.LimitReader(r.Body, maxStateSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if len(state) > maxStateSize {
+		http.Error(w, "state too large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if checksum := r.Header.Get("Content-MD5"); checksum != "" {
+		sum := md5.Sum(state) //nolint:gosec // Integrity check only.
+		if checksum != base64.StdEncoding.EncodeToString(sum[:]) {
+			http.Error(w, "Content-MD5 mismatch", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	if err := writeFile(dir, stateFileName, state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteState removes a state. When the state is locked, the request must carry the lock ID.
+func (b *backend) deleteState(w http.ResponseWriter, r *http.Request, dir string) {
+	if !b.holdsLock(w, dir, r.URL.Query().Get("ID")) {
+		return
+	}
+
+	if err := os.Remove(filepath.Join(dir, stateFileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// lockState takes the lock of a state. When it is already held, the server answers 423 with the
+// current lock, which Terraform shows to the user.
+func (b *backend) lockState(w http.ResponseWriter, r *http.Request, dir string) {
+	body, info, err := readLockInfo(r)
+	if err != nil || info.ID == "" {
+		http.Error(w, "invalid lock information", http.StatusBadRequest)
+
+		return
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, lockFileName))
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusLocked)
+		_, _ = w.Write(current)
+
+		return
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := writeFile(dir, lockFileName, body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// unlockState releases the lock of a state. The lock ID must match, except for a request without
+// a body, which is what 'terraform force-unlock' sends.
+func (b *backend) unlockState(w http.ResponseWriter, r *http.Request, dir string) {
+	body, info, err := readLockInfo(r)
+	if err != nil {
+		http.Error(w, "invalid lock information", http.StatusBadRequest)
+
+		return
+	}
+
+	if len(body) > 0 && !b.holdsLock(w, dir, info.ID) {
+		return
+	}
+
+	if err := os.Remove(filepath.Join(dir, lockFileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// holdsLock checks that a state is unlocked, or locked with the given ID. Otherwise it answers
+// 409 with the current lock, and returns false.
+func (b *backend) holdsLock(w http.ResponseWriter, dir, id string) bool {
+	current, err := os.ReadFile(filepath.Join(dir, lockFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return false
+	}
+
+	var info lockInfo
+	if err := json.Unmarshal(current, &info); err == nil && info.ID == id {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	_, _ = w.Write(current)
+
+	return false
+}
+
+// readLockInfo reads the lock information sent with LOCK and UNLOCK requests.
+func readLockInfo(r *http.Request) ([]byte, lockInfo, error) {
+	var info lockInfo
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, info, err
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, info, nil
+	}
+
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, info, err
+	}
+
+	return body, info, nil
+}
+
+// writeFile writes a file atomically, so a crash never leaves a truncated state behind.
+func writeFile(dir, name string, contents []byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/md5" //nolint:gosec // Terraform sends Content-MD5 to detect corrupted uploads.
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testState = `{"version": 4, "serial": 1}`
+	testLock  = `{"ID": "lock-1", "Operation": "OperationTypeApply"}`
+)
+
+// testRequest is a request sent to the backend, with the status and body expected in return.
+type testRequest struct {
+	method     string
+	path       string
+	body       string
+	header     map[string]string
+	wantStatus int
+	wantBody   string
+}
+
+// contentMD5 returns the Content-MD5 header value of a body.
+func contentMD5(body string) string {
+	sum := md5.Sum([]byte(body)) //nolint:gosec // Integrity check only.
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// TestHandleState verifies the state and lock operations used by Terraform's http backend,
+// including lock conflicts and force-unlock.
+func TestHandleState(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		requests []testRequest
+	}{
+		{
+			name: "missing state",
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/state/app", wantStatus: http.StatusNotFound},
+			},
+		},
+		{
+			name: "write and read",
+			requests: []testRequest{
+				{method: http.MethodPost, path: "/state/app", body: testState, wantStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/state/app", wantStatus: http.StatusOK, wantBody: testState},
+				{method: http.MethodGet, path: "/state/other", wantStatus: http.StatusNotFound},
+			},
+		},
+		{
+			name: "nested state name",
+			requests: []testRequest{
+				{method: http.MethodPost, path: "/state/env/dev/app", body: testState, wantStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/state/env/dev/app", wantStatus: http.StatusOK, wantBody: testState},
+			},
+		},
+		{
+			name: "invalid state names",
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/state/", wantStatus: http.StatusBadRequest},
+				{method: http.MethodGet, path: "/state/.hidden", wantStatus: http.StatusBadRequest},
+				{method: http.MethodGet, path: "/state/app/../other", wantStatus: http.StatusBadRequest},
+			},
+		},
+		{
+			name: "matching checksum",
+			requests: []testRequest{
+				{
+					method:     http.MethodPost,
+					path:       "/state/app",
+					body:       testState,
+					header:     map[string]string{"Content-MD5": contentMD5(testState)},
+					wantStatus: http.StatusOK,
+				},
+			},
+		},
+		{
+			name: "checksum mismatch",
+			requests: []testRequest{
+				{
+					method:     http.MethodPost,
+					path:       "/state/app",
+					body:       testState,
+					header:     map[string]string{"Content-MD5": contentMD5("{}")},
+					wantStatus: http.StatusBadRequest,
+				},
+				{method: http.MethodGet, path: "/state/app", wantStatus: http.StatusNotFound},
+			},
+		},
+		{
+			name: "delete",
+			requests: []testRequest{
+				{method: http.MethodPost, path: "/state/app", body: testState, wantStatus: http.StatusOK},
+				{method: http.MethodDelete, path: "/state/app", wantStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/state/app", wantStatus: http.StatusNotFound},
+				{method: http.MethodDelete, path: "/state/app", wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "write with the lock held",
+			requests: []testRequest{
+				{method: "LOCK", path: "/state/app", body: testLock, wantStatus: http.StatusOK},
+				{method: http.MethodPost, path: "/state/app?ID=lock-1", body: testState, wantStatus: http.StatusOK},
+				{method: "UNLOCK", path: "/state/app", body: testLock, wantStatus: http.StatusOK},
+				{method: http.MethodPost, path: "/state/app", body: testState, wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "write without the lock",
+			requests: []testRequest{
+				{method: "LOCK", path: "/state/app", body: testLock, wantStatus: http.StatusOK},
+				{method: http.MethodPost, path: "/state/app", body: testState, wantStatus: http.StatusConflict, wantBody: testLock},
+				{method: http.MethodPost, path: "/state/app?ID=lock-2", body: testState, wantStatus: http.StatusConflict},
+				{method: http.MethodDelete, path: "/state/app?ID=lock-2", wantStatus: http.StatusConflict},
+			},
+		},
+		{
+			name: "lock already held",
+			requests: []testRequest{
+				{method: "LOCK", path: "/state/app", body: testLock, wantStatus: http.StatusOK},
+				{method: "LOCK", path: "/state/app", body: `{"ID": "lock-2"}`, wantStatus: http.StatusLocked, wantBody: testLock},
+				{method: "LOCK", path: "/state/other", body: `{"ID": "lock-2"}`, wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "invalid lock information",
+			requests: []testRequest{
+				{method: "LOCK", path: "/state/app", wantStatus: http.StatusBadRequest},
+				{method: "LOCK", path: "/state/app", body: `{"Operation": "apply"}`, wantStatus: http.StatusBadRequest},
+				{method: "LOCK", path: "/state/app", body: `not json`, wantStatus: http.StatusBadRequest},
+				{method: "UNLOCK", path: "/state/app", body: `not json`, wantStatus: http.StatusBadRequest},
+			},
+		},
+		{
+			name: "unlock with another ID",
+			requests: []testRequest{
+				{method: "LOCK", path: "/state/app", body: testLock, wantStatus: http.StatusOK},
+				{method: "UNLOCK", path: "/state/app", body: `{"ID": "lock-2"}`, wantStatus: http.StatusConflict, wantBody: testLock},
+				{method: "LOCK", path: "/state/app", body: `{"ID": "lock-2"}`, wantStatus: http.StatusLocked},
+			},
+		},
+		{
+			name: "force unlock",
+			requests: []testRequest{
+				{method: "LOCK", path: "/state/app", body: testLock, wantStatus: http.StatusOK},
+				{method: "UNLOCK", path: "/state/app", wantStatus: http.StatusOK},
+				{method: "LOCK", path: "/state/app", body: `{"ID": "lock-2"}`, wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "unsupported method",
+			requests: []testRequest{
+				{method: http.MethodPut, path: "/state/app", body: testState, wantStatus: http.StatusMethodNotAllowed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := &backend{dataDir: t.TempDir()}
+
+			for i, req := range tt.requests {
+				r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+				for key, value := range req.header {
+					r.Header.Set(key, value)
+				}
+
+				w := httptest.NewRecorder()
+				b.handleState(w, r)
+
+				if w.Code != req.wantStatus {
+					t.Fatalf("request %d: %s %s status = %d, want %d (body: %s)",
+						i, req.method, req.path, w.Code, req.wantStatus, w.Body.String())
+				}
+
+				if req.wantBody != "" && w.Body.String() != req.wantBody {
+					t.Errorf("request %d: %s %s body = %q, want %q", i, req.method, req.path, w.Body.String(), req.wantBody)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleStateAuthentication verifies that basic authentication is enforced when credentials
+// are configured.
+func TestHandleStateAuthentication(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		auth       bool
+		wantStatus int
+	}{
+		{name: "valid credentials", username: "terraform", password: "secret", auth: true, wantStatus: http.StatusNotFound},
+		{name: "wrong password", username: "terraform", password: "guess", auth: true, wantStatus: http.StatusUnauthorized},
+		{name: "wrong username", username: "admin", password: "secret", auth: true, wantStatus: http.StatusUnauthorized},
+		{name: "no credentials", wantStatus: http.StatusUnauthorized},
+	}
+
+	b := &backend{dataDir: t.TempDir(), username: "terraform", password: "secret"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/state/app", nil)
+			if tt.auth {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+
+			w := httptest.NewRecorder()
+			b.handleState(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("WWW-Authenticate header not set")
+			}
+		})
+	}
+}
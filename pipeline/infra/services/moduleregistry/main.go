@@ -0,0 +1,230 @@
+// Package main implements a Terraform module registry.
+//
+// It serves every directory of MODULES_DIR as the module <REGISTRY_NAMESPACE>/<directory>/<REGISTRY_SYSTEM>
+// at version MODULE_VERSION, following the Module Registry Protocol: service discovery at
+// /.well-known/terraform.json, the versions of a module, and its download, as a .tar.gz archive
+// built on the fly. Terraform only discovers registries over HTTPS, so the server listens with the
+// certificate in TLS_CERT_FILE and TLS_KEY_FILE. It is used by the Infra pipeline to test how
+// consumers pull the modules through registry addresses.
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// registry serves the modules stored under a directory.
+type registry struct {
+	modulesDir string
+	namespace  string
+	system     string
+	version    string
+}
+
+func main() {
+	port := getEnv("PORT", "443")
+
+	r := &registry{
+		modulesDir: getEnv("MODULES_DIR", "/modules"),
+		namespace:  getEnv("REGISTRY_NAMESPACE", "local"),
+		system:     getEnv("REGISTRY_SYSTEM", "generic"),
+		version:    getEnv("MODULE_VERSION", "1.0.0"),
+	}
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           logRequests(r.routes()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("module registry listening on :%s, serving %s as %s/<name>/%s %s",
+		port, r.modulesDir, r.namespace, r.system, r.version)
+	log.Fatal(server.ListenAndServeTLS(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")))
+}
+
+// routes returns the handler of the Module Registry Protocol endpoints.
+func (r *registry) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/terraform.json", r.handleDiscovery)
+	mux.HandleFunc("GET /v1/modules/{namespace}/{name}/{system}/versions", r.handleVersions)
+	mux.HandleFunc("GET /v1/modules/{namespace}/{name}/{system}/{version}/download", r.handleDownload)
+	mux.HandleFunc("GET /v1/archives/{namespace}/{name}/{system}/{archive}", r.handleArchive)
+
+	return mux
+}
+
+// handleDiscovery answers the service discovery of the registry host.
+func (r *registry) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]string{"modules.v1": "/v1/modules/"})
+}
+
+// handleVersions lists the versions of a module. Every module has a single version.
+func (r *registry) handleVersions(w http.ResponseWriter, req *http.Request) {
+	if _, ok := r.moduleDir(w, req); !ok {
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"modules": []any{
+			map[string]any{
+				"versions": []any{map[string]string{"version": r.version}},
+			},
+		},
+	})
+}
+
+// handleDownload points Terraform at the archive of a module version, with X-Terraform-Get.
+func (r *registry) handleDownload(w http.ResponseWriter, req *http.Request) {
+	if _, ok := r.moduleDir(w, req); !ok {
+		return
+	}
+
+	if req.PathValue("version") != r.version {
+		http.Error(w, "unknown version", http.StatusNotFound)
+
+		return
+	}
+
+	archiveURL := fmt.Sprintf("https://%s/v1/archives/%s/%s/%s/%s.tar.gz",
+		req.Host, r.namespace, req.PathValue("name"), r.system, r.version)
+
+	w.Header().Set("X-Terraform-Get", archiveURL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleArchive streams a module directory as a .tar.gz archive.
+func (r *registry) handleArchive(w http.ResponseWriter, req *http.Request) {
+	dir, ok := r.moduleDir(w, req)
+	if !ok {
+		return
+	}
+
+	if req.PathValue("archive") != r.version+".tar.gz" {
+		http.Error(w, "unknown version", http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+
+	if err := writeArchive(w, dir); err != nil {
+		log.Printf("failed to archive %s: %v", dir, err)
+	}
+}
+
+// moduleDir returns the directory of the module addressed by a request, or answers 404.
+func (r *registry) moduleDir(w http.ResponseWriter, req *http.Request) (string, bool) {
+	name := req.PathValue("name")
+
+	if req.PathValue("namespace") != r.namespace || req.PathValue("system") != r.system ||
+		name == "" || name != filepath.Base(name) || name[0] == '.' {
+		http.Error(w, "unknown module", http.StatusNotFound)
+
+		return "", false
+	}
+
+	dir := filepath.Join(r.modulesDir, name)
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		http.Error(w, "unknown module", http.StatusNotFound)
+
+		return "", false
+	}
+
+	return dir, true
+}
+
+// writeArchive writes the regular files of a directory as a .tar.gz archive, skipping
+// .terraform directories.
+func writeArchive(w io.Writer, dir string) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && entry.Name() == ".terraform" {
+			return filepath.SkipDir
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(rel)
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		_, err = io.Copy(tarWriter, file)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// logRequests logs every request.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getEnv reads an environment variable, falling back to a default value.
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
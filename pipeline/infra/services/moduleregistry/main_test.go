@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newTestRegistry returns a registry serving a "network" module, with a nested file and a
+// .terraform directory, a hidden ".cache" directory and a README.md file.
+func newTestRegistry(t *testing.T) *registry {
+	t.Helper()
+
+	modulesDir := t.TempDir()
+
+	files := map[string]string{
+		"network/main.tf":                 `resource "null_resource" "this" {}`,
+		"network/modules/subnet/main.tf":  `variable "cidr" {}`,
+		"network/.terraform/modules.json": `{}`,
+		".cache/main.tf":                  ``,
+		"README.md":                       `# Modules`,
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(modulesDir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &registry{modulesDir: modulesDir, namespace: "local", system: "generic", version: "1.0.0"}
+}
+
+// TestRoutes verifies the answers of the Module Registry Protocol endpoints, and that unknown
+// modules, versions and names escaping the modules directory are not found.
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+
+	handler := newTestRegistry(t).routes()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantGet    string
+	}{
+		{
+			name:       "service discovery",
+			path:       "/.well-known/terraform.json",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"modules.v1":"/v1/modules/"}`,
+		},
+		{
+			name:       "versions",
+			path:       "/v1/modules/local/network/generic/versions",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"modules":[{"versions":[{"version":"1.0.0"}]}]}`,
+		},
+		{
+			name:       "download",
+			path:       "/v1/modules/local/network/generic/1.0.0/download",
+			wantStatus: http.StatusNoContent,
+			wantGet:    "https://example.com/v1/archives/local/network/generic/1.0.0.tar.gz",
+		},
+		{
+			name:       "download of an unknown version",
+			path:       "/v1/modules/local/network/generic/2.0.0/download",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "archive of an unknown version",
+			path:       "/v1/archives/local/network/generic/2.0.0.tar.gz",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "unknown module",
+			path:       "/v1/modules/local/storage/generic/versions",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "other namespace",
+			path:       "/v1/modules/acme/network/generic/versions",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "other system",
+			path:       "/v1/modules/local/network/aws/versions",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "hidden directory",
+			path:       "/v1/modules/local/.cache/generic/versions",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "escaped parent directory",
+			path:       "/v1/modules/local/..%2Fnetwork/generic/versions",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "file instead of a directory",
+			path:       "/v1/modules/local/README.md/generic/versions",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d (body: %s)", tt.path, w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			if body := strings.TrimSpace(w.Body.String()); tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("GET %s body = %s, want %s", tt.path, body, tt.wantBody)
+			}
+
+			if got := w.Header().Get("X-Terraform-Get"); got != tt.wantGet {
+				t.Errorf("GET %s X-Terraform-Get = %q, want %q", tt.path, got, tt.wantGet)
+			}
+		})
+	}
+}
+
+// TestHandleArchive verifies that the archive holds the files of the module, including nested
+// ones, without the .terraform directory.
+func TestHandleArchive(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	newTestRegistry(t).routes().ServeHTTP(w,
+		httptest.NewRequest(http.MethodGet, "/v1/archives/local/network/generic/1.0.0.tar.gz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body: %s)", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	gzipReader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+
+	tarReader := tar.NewReader(gzipReader)
+	files := map[string]string{}
+
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("tar.Reader.Next() error = %v", err)
+		}
+
+		contents, err := io.ReadAll(tarReader)
+		if err != nil {
+			t.Fatalf("reading %s: %v", header.Name, err)
+		}
+
+		files[header.Name] = string(contents)
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if want := []string{"main.tf", "modules/subnet/main.tf"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("archive files = %v, want %v", names, want)
+	}
+
+	if want := `variable "cidr" {}`; files["modules/subnet/main.tf"] != want {
+		t.Errorf("modules/subnet/main.tf = %q, want %q", files["modules/subnet/main.tf"], want)
+	}
+}
@@ -0,0 +1,307 @@
+// Package main implements a minimal AWS STS client for the web identity flow.
+//
+// It exchanges an OIDC token for temporary credentials with AssumeRoleWithWebIdentity and,
+// when CHAINED_ROLE_ARN is set, assumes a second role with AssumeRole (signed with SigV4),
+// applying the session duration and tags. It is used by the Infra pipeline both as a
+// pre-flight check ("check" mode) and to obtain the credentials injected into the Terraform
+// container ("credentials" mode). The credentials are written as JSON to CREDENTIALS_FILE, never
+// to stdout, which ends up in logs and traces.
+//
+// Configuration is read from the environment: STS_ENDPOINT, AWS_REGION, ROLE_ARN,
+// WEB_IDENTITY_TOKEN_FILE, ROLE_SESSION_NAME, DURATION_SECONDS, CHAINED_ROLE_ARN,
+// SESSION_TAGS ("key=value,key=value") and CREDENTIALS_FILE.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const stsAPIVersion = "2011-06-15"
+
+// credentials are the temporary credentials returned by STS.
+type credentials struct {
+	AccessKeyID     string `xml:"AccessKeyId" json:"accessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey" json:"secretAccessKey"`
+	SessionToken    string `xml:"SessionToken" json:"sessionToken"`
+	Expiration      string `xml:"Expiration" json:"expiration"`
+}
+
+// assumeRoleResult is the common part of the AssumeRole and AssumeRoleWithWebIdentity results.
+type assumeRoleResult struct {
+	Credentials     credentials `xml:"Credentials"`
+	AssumedRoleUser struct {
+		Arn string `xml:"Arn"`
+	} `xml:"AssumedRoleUser"`
+}
+
+// assumeRoleResponse wraps the result of both actions, whose element names only differ by prefix.
+type assumeRoleResponse struct {
+	WebIdentityResult *assumeRoleResult `xml:"AssumeRoleWithWebIdentityResult"`
+	Result            *assumeRoleResult `xml:"AssumeRoleResult"`
+}
+
+// errorResponse is the error document returned by STS.
+type errorResponse struct {
+	Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+// output is what the "credentials" mode writes to CREDENTIALS_FILE.
+type output struct {
+	credentials
+	AssumedRoleArn string `json:"assumedRoleArn"`
+}
+
+func main() {
+	mode := "check"
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+
+	result, err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sts: %v\n", err)
+		os.Exit(1)
+	}
+
+	if mode == "credentials" {
+		if err := writeCredentials(os.Getenv("CREDENTIALS_FILE"), result); err != nil {
+			fmt.Fprintf(os.Stderr, "sts: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("assumed %s (credentials expire at %s)\n", result.AssumedRoleUser.Arn, result.Credentials.Expiration)
+}
+
+// writeCredentials writes the credentials as JSON to a file readable by its owner only.
+func writeCredentials(path string, result *assumeRoleResult) error {
+	if path == "" {
+		return fmt.Errorf("CREDENTIALS_FILE is not set")
+	}
+
+	encoded, err := json.Marshal(output{credentials: result.Credentials, AssumedRoleArn: result.AssumedRoleUser.Arn})
+	if err != nil {
+		return fmt.Errorf("failed to encode the credentials: %w", err)
+	}
+
+	if err := os.WriteFile(path, encoded, 0o600); err != nil {
+		return fmt.Errorf("failed to write the credentials: %w", err)
+	}
+
+	return nil
+}
+
+func run() (*assumeRoleResult, error) {
+	endpoint := strings.TrimSuffix(os.Getenv("STS_ENDPOINT"), "/")
+	region := getEnv("AWS_REGION", "us-east-1")
+	chainedRoleARN := os.Getenv("CHAINED_ROLE_ARN")
+	duration := os.Getenv("DURATION_SECONDS")
+
+	if endpoint == "" {
+		return nil, fmt.Errorf("STS_ENDPOINT is not set")
+	}
+
+	token, err := os.ReadFile(os.Getenv("WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the web identity token: %w", err)
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {stsAPIVersion},
+		"RoleArn":          {os.Getenv("ROLE_ARN")},
+		"RoleSessionName":  {os.Getenv("ROLE_SESSION_NAME")},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	// The duration applies to the final session: the chained one when role chaining is used.
+	if duration != "" && chainedRoleARN == "" {
+		form.Set("DurationSeconds", duration)
+	}
+
+	result, err := call(endpoint, form, nil, region)
+	if err != nil {
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity for %s failed: %w", form.Get("RoleArn"), err)
+	}
+
+	if chainedRoleARN == "" {
+		return result, nil
+	}
+
+	form = url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {stsAPIVersion},
+		"RoleArn":         {chainedRoleARN},
+		"RoleSessionName": {os.Getenv("ROLE_SESSION_NAME")},
+	}
+
+	if duration != "" {
+		form.Set("DurationSeconds", duration)
+	}
+
+	if err := addSessionTags(form, os.Getenv("SESSION_TAGS")); err != nil {
+		return nil, err
+	}
+
+	chained, err := call(endpoint, form, &result.Credentials, region)
+	if err != nil {
+		return nil, fmt.Errorf("AssumeRole for chained role %s failed: %w", chainedRoleARN, err)
+	}
+
+	return chained, nil
+}
+
+// addSessionTags adds the "key=value" session tags to an AssumeRole request.
+func addSessionTags(form url.Values, tags string) error {
+	if tags == "" {
+		return nil
+	}
+
+	for i, tag := range strings.Split(tags, ",") {
+		key, value, found := strings.Cut(tag, "=")
+		if !found || key == "" {
+			return fmt.Errorf("invalid session tag %q, expected key=value", tag)
+		}
+
+		form.Set(fmt.Sprintf("Tags.member.%d.Key", i+1), key)
+		form.Set(fmt.Sprintf("Tags.member.%d.Value", i+1), value)
+	}
+
+	return nil
+}
+
+// call sends an STS request. Requests made with credentials are signed with SigV4.
+func call(endpoint string, form url.Values, creds *credentials, region string) (*assumeRoleResult, error) {
+	body := form.Encode()
+
+	request, err := http.NewRequest(http.MethodPost, endpoint+"/", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	if creds != nil {
+		signRequest(request, body, creds, region, time.Now().UTC())
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("STS endpoint %s is unreachable: %w", endpoint, err)
+	}
+	defer response.Body.Close()
+
+	payload, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var stsErr errorResponse
+		if xml.Unmarshal(payload, &stsErr) == nil && stsErr.Error.Code != "" {
+			return nil, fmt.Errorf("%s: %s (HTTP %d from %s)", stsErr.Error.Code, stsErr.Error.Message, response.StatusCode, endpoint)
+		}
+
+		return nil, fmt.Errorf("HTTP %d from %s", response.StatusCode, endpoint)
+	}
+
+	var parsed assumeRoleResponse
+	if err := xml.Unmarshal(payload, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid STS response from %s: %w", endpoint, err)
+	}
+
+	result := parsed.Result
+	if result == nil {
+		result = parsed.WebIdentityResult
+	}
+
+	if result == nil || result.Credentials.AccessKeyID == "" {
+		return nil, fmt.Errorf("STS response from %s has no credentials", endpoint)
+	}
+
+	return result, nil
+}
+
+// signRequest signs an STS request with AWS Signature Version 4.
+func signRequest(request *http.Request, body string, creds *credentials, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/sts/aws4_request"
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+
+	headers := map[string]string{
+		"content-type":         request.Header.Get("Content-Type"),
+		"host":                 request.URL.Host,
+		"x-amz-date":           amzDate,
+		"x-amz-security-token": creds.SessionToken,
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		request.Method, "/", "", canonicalHeaders.String(), signedHeaders, hashHex(body),
+	}, "\n")
+
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hashHex(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "sts")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hashHex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+
+	return mac.Sum(nil)
+}
+
+func getEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,284 @@
+// Package main implements a local stand-in for the AWS STS API.
+//
+// It supports AssumeRoleWithWebIdentity and AssumeRole (role chaining), with the validations
+// that matter to the Infra pipeline: the web identity token (STS_EXPECTED_TOKEN, when set),
+// the role ARN format, the session duration, the session tags, and the SigV4 signature of
+// AssumeRole requests made with previously issued credentials.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minDurationSeconds        = 900
+	maxDurationSeconds        = 43200
+	maxChainedDurationSeconds = 3600
+	maxSessionTags            = 50
+)
+
+var (
+	roleARNPattern     = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}):role/[\w+=,.@/-]+$`)
+	sessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+	credentialPattern  = regexp.MustCompile(`Credential=([^/]+)/([^,]+), SignedHeaders=([^,]+), Signature=([0-9a-f]+)`)
+)
+
+// session is a set of issued temporary credentials.
+type session struct {
+	secretAccessKey string
+	sessionToken    string
+}
+
+type standIn struct {
+	expectedToken string
+	mu            sync.Mutex
+	sessions      map[string]session
+}
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	sts := &standIn{expectedToken: os.Getenv("STS_EXPECTED_TOKEN"), sessions: map[string]session{}}
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           http.HandlerFunc(sts.handle),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("STS stand-in listening on :%s", port)
+
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("server stopped: %v", err)
+	}
+}
+
+func (s *standIn) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "failed to read the request body")
+
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedInput", "the request body is not form encoded")
+
+		return
+	}
+
+	action := form.Get("Action")
+	log.Printf("%s %s", action, form.Get("RoleArn"))
+
+	switch action {
+	case "AssumeRoleWithWebIdentity":
+		s.assumeRoleWithWebIdentity(w, form)
+	case "AssumeRole":
+		s.assumeRole(w, r, string(body), form)
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("action %q is not supported by the stand-in", action))
+	}
+}
+
+func (s *standIn) assumeRoleWithWebIdentity(w http.ResponseWriter, form url.Values) {
+	token := form.Get("WebIdentityToken")
+	if token == "" || (s.expectedToken != "" && token != s.expectedToken) {
+		writeError(w, http.StatusBadRequest, "InvalidIdentityToken", "the web identity token is not valid for this stand-in")
+
+		return
+	}
+
+	s.issue(w, "AssumeRoleWithWebIdentity", form, maxDurationSeconds)
+}
+
+func (s *standIn) assumeRole(w http.ResponseWriter, r *http.Request, body string, form url.Values) {
+	matches := credentialPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if matches == nil {
+		writeError(w, http.StatusForbidden, "MissingAuthenticationToken", "AssumeRole requests must be signed with SigV4")
+
+		return
+	}
+
+	accessKeyID, scope, signedHeaders, signature := matches[1], matches[2], matches[3], matches[4]
+
+	s.mu.Lock()
+	issued, found := s.sessions[accessKeyID]
+	s.mu.Unlock()
+
+	if !found || r.Header.Get("X-Amz-Security-Token") != issued.sessionToken {
+		writeError(w, http.StatusForbidden, "InvalidClientTokenId", "the security token included in the request is invalid")
+
+		return
+	}
+
+	if expected := sign(r, body, scope, signedHeaders, issued.secretAccessKey); !hmac.Equal([]byte(expected), []byte(signature)) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", "the request signature does not match")
+
+		return
+	}
+
+	if err := validateTags(form); err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+
+		return
+	}
+
+	// Role chaining limits the session to one hour, as STS does.
+	s.issue(w, "AssumeRole", form, maxChainedDurationSeconds)
+}
+
+// issue validates the common parameters and returns a new set of temporary credentials.
+func (s *standIn) issue(w http.ResponseWriter, action string, form url.Values, maxDuration int) {
+	roleARN := form.Get("RoleArn")
+	sessionName := form.Get("RoleSessionName")
+
+	if !roleARNPattern.MatchString(roleARN) {
+		writeError(w, http.StatusBadRequest, "ValidationError", fmt.Sprintf("invalid role ARN %q", roleARN))
+
+		return
+	}
+
+	if !sessionNamePattern.MatchString(sessionName) {
+		writeError(w, http.StatusBadRequest, "ValidationError", fmt.Sprintf("invalid role session name %q", sessionName))
+
+		return
+	}
+
+	duration := 3600
+	if value := form.Get("DurationSeconds"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < minDurationSeconds || parsed > maxDuration {
+			writeError(w, http.StatusBadRequest, "ValidationError",
+				fmt.Sprintf("DurationSeconds must be between %d and %d, got %q", minDurationSeconds, maxDuration, value))
+
+			return
+		}
+
+		duration = parsed
+	}
+
+	accessKeyID := "ASIA" + strings.ToUpper(randomHex(8))
+	issued := session{secretAccessKey: randomHex(20), sessionToken: randomHex(32)}
+
+	s.mu.Lock()
+	s.sessions[accessKeyID] = issued
+	s.mu.Unlock()
+
+	account := roleARNPattern.FindStringSubmatch(roleARN)[1]
+	roleName := roleARN[strings.LastIndex(roleARN, "/")+1:]
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[2]s</AccessKeyId>
+      <SecretAccessKey>%[3]s</SecretAccessKey>
+      <SessionToken>%[4]s</SessionToken>
+      <Expiration>%[5]s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::%[6]s:assumed-role/%[7]s/%[8]s</Arn>
+    </AssumedRoleUser>
+  </%[1]sResult>
+</%[1]sResponse>
+`, action, accessKeyID, issued.secretAccessKey, issued.sessionToken,
+		time.Now().UTC().Add(time.Duration(duration)*time.Second).Format(time.RFC3339), account, roleName, sessionName)
+}
+
+// validateTags checks the session tags of an AssumeRole request.
+func validateTags(form url.Values) error {
+	for i := 1; ; i++ {
+		key := form.Get(fmt.Sprintf("Tags.member.%d.Key", i))
+		if key == "" {
+			return nil
+		}
+
+		if i > maxSessionTags {
+			return fmt.Errorf("at most %d session tags are allowed", maxSessionTags)
+		}
+
+		value := form.Get(fmt.Sprintf("Tags.member.%d.Value", i))
+		if len(key) > 128 || len(value) > 256 {
+			return fmt.Errorf("session tag %q exceeds the key (128) or value (256) length limit", key)
+		}
+	}
+}
+
+// sign computes the SigV4 signature of a request, given its scope and signed headers.
+func sign(r *http.Request, body, scope, signedHeaders, secretAccessKey string) string {
+	var canonicalHeaders strings.Builder
+
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method, "/", "", canonicalHeaders.String(), signedHeaders, hashHex(body),
+	}, "\n")
+
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", r.Header.Get("X-Amz-Date"), scope, hashHex(canonicalRequest)}, "\n")
+
+	// The scope is "<date>/<region>/<service>/aws4_request".
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	log.Printf("error %s: %s", code, message)
+
+	var escaped strings.Builder
+	_ = xml.EscapeText(&escaped, []byte(message))
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error></ErrorResponse>`,
+		code, escaped.String())
+}
+
+func hashHex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+
+	return mac.Sum(nil)
+}
+
+func randomHex(size int) string {
+	buffer := make([]byte, size)
+	_, _ = rand.Read(buffer)
+
+	return hex.EncodeToString(buffer)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"dagger/infra/internal/dagger"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Paths used to decrypt SOPS files and to mount the decrypted var files
+	configSOPSAgeKeyPath   = "/run/secrets/sops/age.key"
+	configSOPSWorkPath     = "/sops"
+	configSOPSVarFilesPath = "/run/secrets/sops/var-files"
+	configSOPSOutputPath   = "/run/sops/plaintext"
+	// SOPS input and output types
+	sopsTypeDotEnv = "dotenv"
+	sopsTypeYAML   = "yaml"
+	sopsTypeJSON   = "json"
+	sopsTypeBinary = "binary"
+)
+
+var (
+	// sopsDotEnvMetadataPattern matches the metadata SOPS appends to an encrypted dotenv file.
+	sopsDotEnvMetadataPattern = regexp.MustCompile(`(?m)^sops_version=`)
+	// sopsYAMLMetadataPattern matches the metadata block of an encrypted YAML file.
+	sopsYAMLMetadataPattern = regexp.MustCompile(`(?m)^sops:\s*$`)
+	// terraformVarFileCommands are the Terraform commands that accept -var-file.
+	terraformVarFileCommands = []string{"plan", "apply", "destroy", "import", "refresh", "console"}
+)
+
+// getSOPSType detects whether a file is SOPS-encrypted, and returns its SOPS input type.
+//
+// Dotenv, YAML and JSON files are encrypted value by value. Other files, such as HCL .tfvars, are
+// encrypted as a whole ("binary"), which SOPS stores as a JSON document with 'data' and 'sops'
+// keys.
+func getSOPSType(file, contents string) (string, bool) {
+	if !strings.Contains(contents, "ENC[") {
+		return "", false
+	}
+
+	base := strings.ToLower(filepath.Base(file))
+
+	switch {
+	case strings.HasSuffix(base, ".env"):
+		return sopsTypeDotEnv, sopsDotEnvMetadataPattern.MatchString(contents)
+	case strings.HasSuffix(base, ".yaml"), strings.HasSuffix(base, ".yml"):
+		return sopsTypeYAML, sopsYAMLMetadataPattern.MatchString(contents)
+	}
+
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(contents), &document); err != nil {
+		return "", false
+	}
+
+	if _, ok := document["sops"]; !ok {
+		return "", false
+	}
+
+	if strings.HasSuffix(base, ".json") {
+		return sopsTypeJSON, true
+	}
+
+	return sopsTypeBinary, true
+}
+
+// WithSOPSAgeKey sets the age private key used to decrypt SOPS-encrypted files: dotenv files
+// loaded with WithDotEnvFile, var files added with WithSOPSVarFiles, and build fixtures.
+//
+// Files are decrypted in a dedicated container. Decrypted values are only injected as secret
+// variables or as files mounted with WithMountedSecret, so they never land in a layer of the
+// Terraform container.
+//
+// Parameters:
+//   - ageKey: The age private key (the contents of an age key file, or SOPS_AGE_KEY)
+//   - sopsVersion: The SOPS version used to decrypt files (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance
+func (m *Infra) WithSOPSAgeKey(
+	// ageKey is the age private key, e.g. file:~/.config/sops/age/keys.txt.
+	ageKey *dagger.Secret,
+	// sopsVersion is the SOPS version used to decrypt files.
+	// +optional
+	sopsVersion string,
+) *Infra {
+	m.SOPSAgeKey = ageKey
+	m.SOPSVersion = sopsVersion
+
+	return m
+}
+
+// decryptSOPSFile decrypts the contents of a SOPS-encrypted file, and returns the plaintext.
+//
+// SOPS writes the plaintext to a file of the decryption container, which is read back: exec
+// outputs are kept in the engine cache and sent to logs and traces, so they never hold it.
+//
+// Parameters:
+//   - ctx: Context for the Dagger operations
+//   - file: The name of the file, used for the errors and the input type
+//   - contents: The encrypted contents
+//   - inputType: The SOPS input type
+//   - outputType: The SOPS output type
+//
+// Returns:
+//   - string: The decrypted contents
+//   - error: An error if no age key is set or the decryption fails
+func (m *Infra) decryptSOPSFile(ctx context.Context, file, contents, inputType, outputType string) (string, error) {
+	if m.SOPSAgeKey == nil {
+		return "", Errorf("%s is SOPS-encrypted, but no age key is set: use WithSOPSAgeKey", file)
+	}
+
+	encryptedPath := filepath.Join(configSOPSWorkPath, filepath.Base(file))
+
+	plaintext, err := dag.Container().
+		From("alpine:latest").
+		WithExec([]string{"/bin/sh", "-c", getSOPSInstallCmd(m.SOPSVersion)}).
+		WithMountedSecret(configSOPSAgeKeyPath, m.SOPSAgeKey).
+		WithEnvVariable("SOPS_AGE_KEY_FILE", configSOPSAgeKeyPath).
+		WithNewFile(encryptedPath, contents).
+		WithExec([]string{"mkdir", "-p", filepath.Dir(configSOPSOutputPath)}).
+		WithExec([]string{
+			"sops", "--decrypt", "--input-type", inputType, "--output-type", outputType,
+			"--output", configSOPSOutputPath, encryptedPath,
+		}).
+		File(configSOPSOutputPath).
+		Contents(ctx)
+	if err != nil {
+		return "", WrapErrorf(err, "failed to decrypt %s with SOPS", file)
+	}
+
+	return plaintext, nil
+}
+
+// getSOPSVarFileName returns the name of a decrypted var file. Value-encrypted YAML and JSON files
+// are decrypted to JSON, which Terraform reads as '.tfvars.json'.
+func getSOPSVarFileName(file, outputType string) string {
+	base := filepath.Base(file)
+	if outputType == sopsTypeBinary {
+		return base
+	}
+
+	for _, suffix := range []string{".json", ".yaml", ".yml", ".sops", ".tfvars"} {
+		base = strings.TrimSuffix(base, suffix)
+	}
+
+	return base + ".tfvars.json"
+}
+
+// decryptSOPSVarFile decrypts a SOPS-encrypted var file and mounts it as a secret file.
+//
+// Returns:
+//   - *dagger.Container: The container with the decrypted var file mounted
+//   - string: The path of the mounted var file
+//   - error: An error if the file is not SOPS-encrypted or cannot be decrypted
+func (m *Infra) decryptSOPSVarFile(
+	ctx context.Context,
+	ctr *dagger.Container,
+	file, contents string,
+) (*dagger.Container, string, error) {
+	inputType, encrypted := getSOPSType(file, contents)
+	if !encrypted || inputType == sopsTypeDotEnv {
+		return nil, "", Errorf("%s is not a SOPS-encrypted var file", file)
+	}
+
+	outputType := sopsTypeJSON
+	if inputType == sopsTypeBinary {
+		outputType = sopsTypeBinary
+	}
+
+	plaintext, err := m.decryptSOPSFile(ctx, file, contents, inputType, outputType)
+	if err != nil {
+		return nil, "", err
+	}
+
+	digest := sha256.Sum256([]byte(file))
+	varFilePath := filepath.Join(configSOPSVarFilesPath, hex.EncodeToString(digest[:4]), getSOPSVarFileName(file, outputType))
+
+	varFileSecret := newDigestNamedSecret("sops-var-file", plaintext)
+	m.registerSecret(varFileSecret)
+
+	return ctr.WithMountedSecret(varFilePath, varFileSecret, dagger.ContainerWithMountedSecretOpts{
+		Mode: credentialFileMode,
+	}), varFilePath, nil
+}
+
+// WithSOPSVarFiles decrypts SOPS-encrypted var files (HCL .tfvars, JSON or YAML) and passes them
+// to Terraform.
+//
+// Every file is mounted as a secret under /run/secrets/sops/var-files, and passed with -var-file
+// through TF_CLI_ARGS_<command> to the commands that accept it (plan, apply, destroy, import,
+// refresh and console). YAML and JSON files are decrypted to '.tfvars.json'.
+//
+// Parameters:
+//   - ctx: Context for the Dagger operations
+//   - files: The encrypted var files, relative to the source directory
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the var files mounted
+//   - error: An error if a file cannot be read, is not SOPS-encrypted, or cannot be decrypted
+func (m *Infra) WithSOPSVarFiles(
+	// ctx is the context for the Dagger operations.
+	// +optional
+	ctx context.Context,
+	// files are the encrypted var files, relative to the source directory, e.g. "modules/default/fixtures/prod.sops.json".
+	files []string,
+) (*Infra, error) {
+	if m.Src == nil {
+		return nil, NewError("failed to load the SOPS var files, the source directory is nil")
+	}
+
+	for _, file := range files {
+		contents, err := m.Src.File(file).Contents(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the SOPS var file %s", file)
+		}
+
+		ctr, varFilePath, err := m.decryptSOPSVarFile(ctx, m.Ctr, file, contents)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Ctr = ctr
+		m.SOPSVarFiles = append(m.SOPSVarFiles, varFilePath)
+	}
+
+	varFileArgs := make([]string, 0, len(m.SOPSVarFiles))
+	for _, varFilePath := range m.SOPSVarFiles {
+		varFileArgs = append(varFileArgs, "-var-file="+varFilePath)
+	}
+
+	for _, command := range terraformVarFileCommands {
+		m = m.WithTerraformCLIArgsForCommand(command, strings.Join(varFileArgs, " "))
+	}
+
+	return m, nil
+}
+
+// getFixtureVarFile returns the var file passed to Terraform for a build fixture. A SOPS-encrypted
+// fixture is decrypted and mounted as a secret file; a plaintext fixture is used as is.
+//
+// Parameters:
+//   - ctx: Context for the Dagger operations
+//   - ctr: The container running the build
+//   - tfModulePath: The module the fixture belongs to
+//   - fixturePath: The fixture path, relative to the module directory
+//
+// Returns:
+//   - *dagger.Container: The container, with the decrypted fixture mounted if it was encrypted
+//   - string: The path to pass with -var-file
+//   - error: An error if the fixture cannot be read or decrypted
+func (m *Infra) getFixtureVarFile(
+	ctx context.Context,
+	ctr *dagger.Container,
+	tfModulePath, fixturePath string,
+) (*dagger.Container, string, error) {
+	file := filepath.Join(getTerraformModulesExecutionPath(tfModulePath), fixturePath)
+
+	contents, err := m.Src.File(file).Contents(ctx)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "failed to read the fixture %s", file)
+	}
+
+	if _, encrypted := getSOPSType(file, contents); !encrypted {
+		return ctr, fixturePath, nil
+	}
+
+	ctr, varFilePath, err := m.decryptSOPSVarFile(ctx, ctr, file, contents)
+	if err != nil {
+		return nil, "", WrapErrorf(err, "failed to decrypt the fixture %s", fixturePath)
+	}
+
+	return ctr, varFilePath, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import "testing"
+
+// TestGetSOPSType verifies that SOPS-encrypted files are detected from their metadata, with the
+// input type matching their format, and that plaintext files are not.
+func TestGetSOPSType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		file          string
+		contents      string
+		wantType      string
+		wantEncrypted bool
+	}{
+		{
+			name:          "dotenv",
+			file:          "modules/default/secrets.env",
+			contents:      "DB_PASSWORD=ENC[AES256_GCM,data:abc=,type:str]\nsops_version=3.9.0\n",
+			wantType:      sopsTypeDotEnv,
+			wantEncrypted: true,
+		},
+		{
+			name:     "dotenv without metadata",
+			file:     "secrets.env",
+			contents: "DB_PASSWORD=ENC[AES256_GCM,data:abc=,type:str]\n",
+			wantType: sopsTypeDotEnv,
+		},
+		{
+			name:          "yaml",
+			file:          "fixtures/prod.sops.yaml",
+			contents:      "password: ENC[AES256_GCM,data:abc=,type:str]\nsops:\n  version: 3.9.0\n",
+			wantType:      sopsTypeYAML,
+			wantEncrypted: true,
+		},
+		{
+			name:          "yml in upper case",
+			file:          "fixtures/PROD.YML",
+			contents:      "password: ENC[AES256_GCM,data:abc=,type:str]\nsops:\n  version: 3.9.0\n",
+			wantType:      sopsTypeYAML,
+			wantEncrypted: true,
+		},
+		{
+			name:     "yaml with a nested sops key",
+			file:     "fixtures/prod.yaml",
+			contents: "app:\n  password: ENC[AES256_GCM,data:abc=,type:str]\n  sops:\n    enabled: true\n",
+			wantType: sopsTypeYAML,
+		},
+		{
+			name:          "json",
+			file:          "fixtures/prod.sops.json",
+			contents:      `{"password": "ENC[AES256_GCM,data:abc=,type:str]", "sops": {"version": "3.9.0"}}`,
+			wantType:      sopsTypeJSON,
+			wantEncrypted: true,
+		},
+		{
+			name:          "binary tfvars",
+			file:          "fixtures/prod.tfvars",
+			contents:      `{"data": "ENC[AES256_GCM,data:abc=,type:str]", "sops": {"version": "3.9.0"}}`,
+			wantType:      sopsTypeBinary,
+			wantEncrypted: true,
+		},
+		{
+			name:     "json without metadata",
+			file:     "fixtures/prod.json",
+			contents: `{"password": "ENC[AES256_GCM,data:abc=,type:str]"}`,
+		},
+		{
+			name:     "plaintext tfvars",
+			file:     "fixtures/prod.tfvars",
+			contents: "region = \"eu-west-1\"\n",
+		},
+		{
+			name:     "plaintext dotenv",
+			file:     "default.env",
+			contents: "TF_VAR_region=eu-west-1\n",
+		},
+		{
+			name:     "plaintext json with a sops key",
+			file:     "fixtures/prod.json",
+			contents: `{"sops": {"version": "3.9.0"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gotType, gotEncrypted := getSOPSType(tt.file, tt.contents)
+			if gotType != tt.wantType || gotEncrypted != tt.wantEncrypted {
+				t.Errorf("getSOPSType(%q) = (%q, %v), want (%q, %v)",
+					tt.file, gotType, gotEncrypted, tt.wantType, tt.wantEncrypted)
+			}
+		})
+	}
+}
+
+// TestGetSOPSVarFileName verifies that value-encrypted files are named as JSON var files, and
+// that files encrypted as a whole keep their name.
+func TestGetSOPSVarFileName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		file       string
+		outputType string
+		want       string
+	}{
+		{file: "fixtures/prod.sops.json", outputType: sopsTypeJSON, want: "prod.tfvars.json"},
+		{file: "fixtures/prod.sops.yaml", outputType: sopsTypeJSON, want: "prod.tfvars.json"},
+		{file: "fixtures/prod.yml", outputType: sopsTypeJSON, want: "prod.tfvars.json"},
+		{file: "fixtures/prod.tfvars.json", outputType: sopsTypeJSON, want: "prod.tfvars.json"},
+		{file: "fixtures/prod.tfvars", outputType: sopsTypeBinary, want: "prod.tfvars"},
+		{file: "fixtures/prod.sops.tfvars", outputType: sopsTypeBinary, want: "prod.sops.tfvars"},
+	}
+
+	for _, tt := range tests {
+		if got := getSOPSVarFileName(tt.file, tt.outputType); got != tt.want {
+			t.Errorf("getSOPSVarFileName(%q, %q) = %q, want %q", tt.file, tt.outputType, got, tt.want)
+		}
+	}
+}
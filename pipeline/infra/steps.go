@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// stepOutputTailLines is the number of trailing output lines included in step failure reports.
+const stepOutputTailLines = 20
+
+// stepError describes the failure of a single, eagerly executed step.
+type stepError struct {
+	command   string        // command is the command line of the step.
+	exitCode  int           // exitCode is the exit code of the step, or -1 if it is unknown.
+	output    string        // output is the combined stdout and stderr the step produced.
+	timedOut  bool          // timedOut reports whether the step exceeded its step or action timeout.
+	cancelled bool          // cancelled reports whether the step was stopped by the action context.
+	elapsed   time.Duration // elapsed is how long the step ran before failing.
+	limit     time.Duration // limit is the timeout that applied to the step, if any.
+	err       error         // err is the underlying error returned by Dagger.
+}
+
+// Error returns a description of the failure that names the step, and for timeouts, how much
+// output it produced before being stopped.
+func (e *stepError) Error() string {
+	switch {
+	case e.cancelled && e.timedOut:
+		return fmt.Sprintf("step '%s' was stopped after %s by the action timeout (%s), no output could be captured",
+			e.command, e.elapsed.Round(time.Second), e.limit)
+	case e.cancelled:
+		return fmt.Sprintf("step '%s' was cancelled after %s: %v", e.command, e.elapsed.Round(time.Second), e.err)
+	case e.timedOut:
+		return fmt.Sprintf("step '%s' timed out after %s (limit %s) and produced %s of output%s",
+			e.command, e.elapsed.Round(time.Second), e.limit, describeOutputSize(e.output), formatOutputTail(e.output))
+	default:
+		return fmt.Sprintf("step '%s' failed with exit code %d%s", e.command, e.exitCode, formatOutputTail(e.output))
+	}
+}
+
+// Unwrap returns the underlying Dagger error.
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// getExecFailureDetails extracts the exit code and the combined output from an error returned
+// by Dagger when a command fails.
+func getExecFailureDetails(err error) (exitCode int, output string) {
+	var execErr *dagger.ExecError
+	if errors.As(err, &execErr) {
+		return execErr.ExitCode, strings.TrimSpace(execErr.Stdout + "\n" + execErr.Stderr)
+	}
+
+	return -1, ""
+}
+
+// describeOutputSize returns a short description of the size of a command output.
+func describeOutputSize(output string) string {
+	if output == "" {
+		return "no bytes"
+	}
+
+	return fmt.Sprintf("%d bytes (%d lines)", len(output), strings.Count(output, "\n")+1)
+}
+
+// formatOutputTail returns the last lines of a command output, indented, to be appended to
+// a failure description. It returns an empty string when there is no output.
+func formatOutputTail(output string) string {
+	if output == "" {
+		return ""
+	}
+
+	lines := strings.Split(output, "\n")
+	if len(lines) > stepOutputTailLines {
+		lines = lines[len(lines)-stepOutputTailLines:]
+	}
+
+	return fmt.Sprintf(", last %d line(s):\n    %s", len(lines), strings.Join(lines, "\n    "))
+}
+
+// execStep eagerly executes a single command on a Dagger container, bounded by the step timeout
+// configured for it, through the 'timeout' utility and the context deadline of the exec. Failures
+// are reported as a *stepError.
+func (m *Infra) execStep(ctx context.Context, container *dagger.Container, command DaggerCMD) (*dagger.Container, error) {
+	limit := m.getStepTimeout(command)
+
+	execCMD := command
+	if limit > 0 {
+		execCMD = wrapWithStepTimeout(command, limit)
+	}
+
+	// The context deadline bounds the step even when the image has no 'timeout' utility.
+	stepCtx := ctx
+
+	if limit > 0 {
+		var cancel context.CancelFunc
+
+		stepCtx, cancel = context.WithTimeout(ctx, limit+stepTimeoutGracePeriod)
+		defer cancel()
+	}
+
+	started := time.Now()
+
+	execCtr, err := container.
+		WithExec(execCMD).
+		Sync(stepCtx)
+
+	if err == nil {
+		return execCtr, nil
+	}
+
+	failure := &stepError{
+		command: strings.Join(command, " "),
+		elapsed: time.Since(started),
+		limit:   limit,
+		err:     err,
+	}
+
+	failure.exitCode, failure.output = getExecFailureDetails(err)
+
+	switch {
+	case ctx.Err() != nil:
+		failure.cancelled = true
+		failure.timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+		failure.limit = time.Duration(m.ActionTimeoutSeconds) * time.Second
+	case limit > 0 && stepCtx.Err() != nil:
+		failure.timedOut = true
+	case isStepTimeout(failure.exitCode, failure.elapsed, limit):
+		failure.timedOut = true
+	}
+
+	return nil, failure
+}
+
+// runDaggerCMDs executes a series of commands on a Dagger container, applying the configured
+// retry policy and timeouts.
+//
+// When neither a retry policy nor timeouts are configured, it is equivalent to addDaggerCMDs and
+// commands are only chained. Otherwise, commands are executed eagerly one by one, so that a failure,
+// a timeout or a cancellation is attributed to the exact step that caused it. Steps that download
+// providers or modules are retried according to the RetryPolicy.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle and cancellation
+//   - container: The base Dagger container to execute commands on
+//   - commands: The commands to execute, in order
+//
+// Returns:
+//   - *dagger.Container: The container after executing all commands
+//   - []StepAttempt: The attempts recorded for the retried steps
+//   - error: An error naming the step that failed, timed out or was cancelled
+func (m *Infra) runDaggerCMDs(
+	ctx context.Context,
+	container *dagger.Container,
+	commands ...DaggerCMD,
+) (*dagger.Container, []StepAttempt, error) {
+	if m.RetryPolicy == nil && !m.hasTimeouts() {
+		return addDaggerCMDs(container, commands...), nil, nil
+	}
+
+	var attempts []StepAttempt
+
+	modifiedContainer := container
+
+	for _, command := range commands {
+		if err := ctx.Err(); err != nil {
+			return nil, attempts, WrapErrorf(err, "step '%s' was not started, the action was cancelled", strings.Join(command, " "))
+		}
+
+		if m.RetryPolicy != nil && isRetryableStep(command) {
+			retriedContainer, commandAttempts, err := m.execDaggerCMDWithRetry(ctx, modifiedContainer, command)
+			attempts = append(attempts, commandAttempts...)
+
+			if err != nil {
+				return nil, attempts, err
+			}
+
+			modifiedContainer = retriedContainer
+
+			continue
+		}
+
+		execContainer, err := m.execStep(ctx, modifiedContainer, command)
+		if err != nil {
+			return nil, attempts, WrapErrorf(err, "failed to run step")
+		}
+
+		modifiedContainer = execContainer
+	}
+
+	return modifiedContainer, attempts, nil
+}
+
+// runActionCMDs behaves like runDaggerCMDs, and records the attempts of the retried steps on the
+// Infra instance so they are included in the report of the current action. Registered secret
+// values are redacted from the returned error.
+func (m *Infra) runActionCMDs(
+	ctx context.Context,
+	container *dagger.Container,
+	commands ...DaggerCMD,
+) (*dagger.Container, error) {
+	modifiedContainer, attempts, err := m.runDaggerCMDs(ctx, container, commands...)
+	m.stepAttempts = append(m.stepAttempts, attempts...)
+
+	return modifiedContainer, m.redactError(ctx, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"dagger/infra/internal/dagger"
 	"path/filepath"
+	"strings"
 )
 
 // JobTerraformStaticCheck performs static analysis checks on Terraform code.
@@ -16,18 +17,6 @@ func (m *Infra) ActionTerraformStaticAnalysis(
 	ctx context.Context,
 	// tfModulePath is the path to the Terraform modules.
 	tfModulePath string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -40,6 +29,9 @@ func (m *Infra) ActionTerraformStaticAnalysis(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -61,19 +53,22 @@ func (m *Infra) ActionTerraformStaticAnalysis(
 	// terraformDocsVersion is the terraform-docs version to use.
 	// +optional
 	terraformDocsVersion string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (*dagger.Container, error) {
 	// Get the base container using JobTerraform
 	baseContainer, err := m.JobTerraform(
 		ctx,
 		tfModulePath,
-		awsAccessKeyID,
-		awsSecretAccessKey,
-		awsSessionToken,
-		awsRegion,
 		tfRegistryGitlabToken,
 		gitHubToken,
 		gitlabToken,
 		loadDotEnvFile,
+		example,
 		noCache,
 		envVars,
 		gitSSH,
@@ -81,6 +76,31 @@ func (m *Infra) ActionTerraformStaticAnalysis(
 		dotTerraformVersion,
 		tflintVersion,
 		terraformDocsVersion,
+		nil,
+		nil,
+		registryHosts,
+		registryTokens,
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		nil,
+		"",
+		"",
 	)
 
 	if err != nil {
@@ -94,8 +114,11 @@ func (m *Infra) ActionTerraformStaticAnalysis(
 		{"terraform", "fmt", "-check", "-diff"},
 	}
 
-	// Execute static checks using the reusable function
-	baseContainer = addDaggerCMDs(baseContainer, actionCMDs...)
+	// Execute static checks using the reusable function, applying the retry policy and timeouts
+	baseContainer, err = m.runActionCMDs(ctx, baseContainer, actionCMDs...)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to run static analysis checks")
+	}
 
 	return baseContainer, nil
 }
@@ -108,18 +131,6 @@ func (m *Infra) ActionTerraformStaticAnalysisExec(
 	ctx context.Context,
 	// tfModulePath is the path to the Terraform modules.
 	tfModulePath string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -132,6 +143,9 @@ func (m *Infra) ActionTerraformStaticAnalysisExec(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -153,38 +167,42 @@ func (m *Infra) ActionTerraformStaticAnalysisExec(
 	// terraformDocsVersion is the terraform-docs version to use.
 	// +optional
 	terraformDocsVersion string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (string, error) {
-	action, actionErr := m.ActionTerraformStaticAnalysis(
-		ctx,
-		tfModulePath,
-		awsAccessKeyID,
-		awsSecretAccessKey,
-		awsSessionToken,
-		awsRegion,
-		tfRegistryGitlabToken,
-		gitHubToken,
-		gitlabToken,
-		loadDotEnvFile,
-		noCache,
-		envVars,
-		gitSSH,
-		logLevel,
-		dotTerraformVersion,
-		tflintVersion,
-		terraformDocsVersion,
-	)
-
-	if actionErr != nil {
-		return "", WrapErrorf(actionErr, "failed to create base Terraform container")
-	}
-
-	actionOutput, actionOutputErr := action.Stdout(ctx)
-
-	if actionOutputErr != nil {
-		return "", WrapErrorf(actionOutputErr, "failed to get action output")
-	}
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	return m.runWithResultCache(ctx, tfModulePath, "static-analysis", []string{"terraform-version-file=" + dotTerraformVersion}, noCache, func() (string, error) {
+		action, actionErr := m.ActionTerraformStaticAnalysis(
+			ctx,
+			tfModulePath,
+			tfRegistryGitlabToken,
+			gitHubToken,
+			gitlabToken,
+			loadDotEnvFile,
+			example,
+			noCache,
+			envVars,
+			gitSSH,
+			logLevel,
+			dotTerraformVersion,
+			tflintVersion,
+			terraformDocsVersion,
+			registryHosts,
+			registryTokens,
+		)
+
+		if actionErr != nil {
+			return "", m.redactError(ctx, WrapErrorf(actionErr, "failed to create base Terraform container"))
+		}
 
-	return actionOutput, nil
+		return m.processActionResult(ctx, tfModulePath+".static-analysis", action)
+	})
 }
 
 // ActionTerraformVersionCompatibilityVerification performs compatibility checks across multiple Terraform versions.
@@ -196,18 +214,6 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerification(
 	ctx context.Context,
 	// tfModulePath is the path to the Terraform modules.
 	tfModulePath string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -220,6 +226,9 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerification(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -244,6 +253,12 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerification(
 	// tfVersionsToVerify is the list of Terraform versions to verify.
 	// +optional
 	tfVersionsToVerify []string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (*dagger.Container, error) {
 	// Define Terraform versions to test against
 	versions := []string{
@@ -259,14 +274,11 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerification(
 	baseContainer, err := m.JobTerraform(
 		ctx,
 		tfModulePath,
-		awsAccessKeyID,
-		awsSecretAccessKey,
-		awsSessionToken,
-		awsRegion,
 		tfRegistryGitlabToken,
 		gitHubToken,
 		gitlabToken,
 		loadDotEnvFile,
+		example,
 		noCache,
 		envVars,
 		gitSSH,
@@ -274,6 +286,31 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerification(
 		dotTerraformVersion,
 		tflintVersion,
 		terraformDocsVersion,
+		nil,
+		nil,
+		registryHosts,
+		registryTokens,
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		nil,
+		"",
+		"",
 	)
 
 	if err != nil {
@@ -292,8 +329,11 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerification(
 			{"terraform", "validate"},
 		}
 
-		// Execute compatibility checks using the reusable function
-		versionContainer = addDaggerCMDs(versionContainer, versionCMDs...)
+		// Execute compatibility checks using the reusable function, applying the retry policy and timeouts
+		versionContainer, err = m.runActionCMDs(ctx, versionContainer, versionCMDs...)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to run compatibility checks for Terraform version %s", version)
+		}
 
 		// Update base container for next iteration
 		baseContainer = versionContainer
@@ -310,18 +350,6 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerificationExec(
 	ctx context.Context,
 	// tfModulePath is the path to the Terraform modules.
 	tfModulePath string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -334,6 +362,9 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerificationExec(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -358,39 +389,43 @@ func (m *Infra) ActionTerraformVersionCompatibilityVerificationExec(
 	// tfVersionsToVerify is the list of Terraform versions to verify.
 	// +optional
 	tfVersionsToVerify []string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (string, error) {
-	action, actionErr := m.ActionTerraformVersionCompatibilityVerification(
-		ctx,
-		tfModulePath,
-		awsAccessKeyID,
-		awsSecretAccessKey,
-		awsSessionToken,
-		awsRegion,
-		tfRegistryGitlabToken,
-		gitHubToken,
-		gitlabToken,
-		loadDotEnvFile,
-		noCache,
-		envVars,
-		gitSSH,
-		logLevel,
-		dotTerraformVersion,
-		tflintVersion,
-		terraformDocsVersion,
-		tfVersionsToVerify,
-	)
-
-	if actionErr != nil {
-		return "", WrapErrorf(actionErr, "failed to create base Terraform container")
-	}
-
-	actionOutput, actionOutputErr := action.Stdout(ctx)
-
-	if actionOutputErr != nil {
-		return "", WrapErrorf(actionOutputErr, "failed to get action output")
-	}
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	return m.runWithResultCache(ctx, tfModulePath, "version-compatibility", []string{"terraform-versions=" + strings.Join(tfVersionsToVerify, ",")}, noCache, func() (string, error) {
+		action, actionErr := m.ActionTerraformVersionCompatibilityVerification(
+			ctx,
+			tfModulePath,
+			tfRegistryGitlabToken,
+			gitHubToken,
+			gitlabToken,
+			loadDotEnvFile,
+			example,
+			noCache,
+			envVars,
+			gitSSH,
+			logLevel,
+			dotTerraformVersion,
+			tflintVersion,
+			terraformDocsVersion,
+			tfVersionsToVerify,
+			registryHosts,
+			registryTokens,
+		)
+
+		if actionErr != nil {
+			return "", m.redactError(ctx, WrapErrorf(actionErr, "failed to create base Terraform container"))
+		}
 
-	return actionOutput, nil
+		return m.processActionResult(ctx, tfModulePath+".version-compatibility", action)
+	})
 }
 
 // ActionTerraformFileVerification verifies the presence of mandatory Terraform module files.
@@ -408,10 +443,18 @@ func (m *Infra) ActionTerraformFileVerification(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
-	// envVars are the environment variables to set in the container.
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (*dagger.Container, error) {
 	mandatoryTFModuleFiles := []string{
 		"main.tf",
@@ -437,18 +480,40 @@ func (m *Infra) ActionTerraformFileVerification(
 		nil,
 		nil,
 		nil,
+		loadDotEnvFile,
+		example,
+		noCache,
+		nil,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		registryHosts,
+		registryTokens,
 		"",
 		nil,
 		nil,
 		nil,
-		loadDotEnvFile,
-		noCache,
 		nil,
 		nil,
 		"",
 		"",
 		"",
 		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		nil,
+		"",
+		"",
 	)
 
 	if err != nil {
@@ -538,29 +603,38 @@ func (m *Infra) ActionTerraformFileVerificationExec(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
 	action, actionErr := m.ActionTerraformFileVerification(
 		ctx,
 		tfModulePath,
 		files,
 		loadDotEnvFile,
+		example,
 		noCache,
+		registryHosts,
+		registryTokens,
 	)
 
 	if actionErr != nil {
-		return "", WrapErrorf(actionErr, "failed to create base Terraform container")
-	}
-
-	actionOutput, actionOutputErr := action.Stdout(ctx)
-
-	if actionOutputErr != nil {
-		return "", WrapErrorf(actionOutputErr, "failed to get action output")
+		return "", m.redactError(ctx, WrapErrorf(actionErr, "failed to create base Terraform container"))
 	}
 
-	return actionOutput, nil
+	return m.processActionResult(ctx, tfModulePath+".file-verification", action)
 }
 
 // ActionTerraformBuild performs a Terraform build operation including initialization and planning.
@@ -574,18 +648,6 @@ func (m *Infra) ActionTerraformBuild(
 	// fixture is the fixture to use for the build, meaning, the file.tfvars file to use.
 	// +optional
 	fixture string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -598,6 +660,9 @@ func (m *Infra) ActionTerraformBuild(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -610,19 +675,26 @@ func (m *Infra) ActionTerraformBuild(
 	// logLevel is the Terraform log level to use.
 	// +optional
 	logLevel string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
+	// useBackend is a flag to initialize the backend configured with WithBackend, so the plan runs
+	// against the remote state. Without it, or without a backend, the plan runs backend-less.
+	// +optional
+	useBackend bool,
 ) (*dagger.Container, error) {
 	// Get the base container using JobTerraform
 	baseContainer, err := m.JobTerraform(
 		ctx,
 		tfModulePath,
-		awsAccessKeyID,
-		awsSecretAccessKey,
-		awsSessionToken,
-		awsRegion,
 		tfRegistryGitlabToken,
 		gitHubToken,
 		gitlabToken,
 		loadDotEnvFile,
+		example,
 		noCache,
 		envVars,
 		gitSSH,
@@ -630,24 +702,63 @@ func (m *Infra) ActionTerraformBuild(
 		"",
 		"",
 		"",
+		nil,
+		nil,
+		registryHosts,
+		registryTokens,
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		nil,
+		"",
+		"",
 	)
 
 	if err != nil {
 		return nil, WrapErrorf(err, "failed to create base Terraform container")
 	}
 
+	if useBackend {
+		baseContainer = m.withBackendOverride(baseContainer)
+	}
+
 	buildTFCommands := []DaggerCMD{
-		{"terraform", "init", "-backend=false"},
+		m.getTerraformInitCmd(useBackend),
 	}
 
 	if fixture != "" {
-		fixturePath := filepath.Join(configTerraformFixturesPath, fixture)
+		// Encrypted fixtures are decrypted and mounted as secret files.
+		ctrWithFixture, fixturePath, err := m.getFixtureVarFile(
+			ctx, baseContainer, tfModulePath, filepath.Join(configTerraformFixturesPath, fixture))
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to load the fixture")
+		}
+
+		baseContainer = ctrWithFixture
 		buildTFCommands = append(buildTFCommands, DaggerCMD{"terraform", "plan", "-var-file=" + fixturePath})
 	} else {
 		buildTFCommands = append(buildTFCommands, DaggerCMD{"terraform", "plan"})
 	}
 
-	baseContainer = addDaggerCMDs(baseContainer, buildTFCommands...)
+	baseContainer, err = m.runActionCMDs(ctx, baseContainer, buildTFCommands...)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to run build commands")
+	}
 
 	return baseContainer, nil
 }
@@ -663,18 +774,6 @@ func (m *Infra) ActionTerraformBuildExec(
 	// fixture is the fixture to use for the build, meaning, the file.tfvars file to use.
 	// +optional
 	fixture string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -687,6 +786,9 @@ func (m *Infra) ActionTerraformBuildExec(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -699,36 +801,43 @@ func (m *Infra) ActionTerraformBuildExec(
 	// logLevel is the Terraform log level to use.
 	// +optional
 	logLevel string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
+	// useBackend is a flag to initialize the backend configured with WithBackend, so the plan runs
+	// against the remote state. Without it, or without a backend, the plan runs backend-less.
+	// +optional
+	useBackend bool,
 ) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
 	action, actionErr := m.ActionTerraformBuild(
 		ctx,
 		tfModulePath,
 		fixture,
-		awsAccessKeyID,
-		awsSecretAccessKey,
-		awsSessionToken,
-		awsRegion,
 		tfRegistryGitlabToken,
 		gitHubToken,
 		gitlabToken,
 		loadDotEnvFile,
+		example,
 		noCache,
 		envVars,
 		gitSSH,
 		logLevel,
+		registryHosts,
+		registryTokens,
+		useBackend,
 	)
 
 	if actionErr != nil {
-		return "", WrapErrorf(actionErr, "failed to create base Terraform container")
-	}
-
-	actionOutput, actionOutputErr := action.Stdout(ctx)
-
-	if actionOutputErr != nil {
-		return "", WrapErrorf(actionOutputErr, "failed to get action output")
+		return "", m.redactError(ctx, WrapErrorf(actionErr, "failed to create base Terraform container"))
 	}
 
-	return actionOutput, nil
+	return m.processActionResult(ctx, tfModulePath+".build", action)
 }
 
 // ActionTerraformDocs generates Terraform documentation using terraform-docs.
@@ -743,12 +852,21 @@ func (m *Infra) ActionTerraformDocs(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
 	// terraformDocsVersion is the terraform-docs version to use.
 	// +optional
 	terraformDocsVersion string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (*dagger.Container, error) {
 	tfDocsConfigFile := ".terraform-docs.yml"
 
@@ -766,11 +884,8 @@ func (m *Infra) ActionTerraformDocs(
 		nil,
 		nil,
 		nil,
-		"",
-		nil,
-		nil,
-		nil,
 		loadDotEnvFile,
+		example,
 		noCache,
 		nil,
 		nil,
@@ -780,6 +895,31 @@ func (m *Infra) ActionTerraformDocs(
 		"",
 		// FIXME: This is not working as expected, the terraform-docs version is not being set.
 		// terraformDocsVersion,
+		nil,
+		nil,
+		registryHosts,
+		registryTokens,
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		nil,
+		"",
+		"",
 	)
 
 	if err != nil {
@@ -802,32 +942,47 @@ func (m *Infra) ActionTerraformDocsExec(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
 	// terraformDocsVersion is the terraform-docs version to use.
 	// +optional
 	terraformDocsVersion string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (string, error) {
-	action, actionErr := m.ActionTerraformDocs(
-		ctx,
-		tfModulePath,
-		loadDotEnvFile,
-		noCache,
-		terraformDocsVersion,
-	)
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
 
-	if actionErr != nil {
-		return "", WrapErrorf(actionErr, "failed to create base Terraform container")
+	if terraformDocsVersion == "" {
+		terraformDocsVersion = defaultTerraformDocsVersion
 	}
 
-	actionOutput, actionOutputErr := action.Stdout(ctx)
-
-	if actionOutputErr != nil {
-		return "", WrapErrorf(actionOutputErr, "failed to get action output")
-	}
+	return m.runWithResultCache(ctx, tfModulePath, "docs", []string{"terraform-docs=" + terraformDocsVersion}, noCache, func() (string, error) {
+		action, actionErr := m.ActionTerraformDocs(
+			ctx,
+			tfModulePath,
+			loadDotEnvFile,
+			example,
+			noCache,
+			terraformDocsVersion,
+			registryHosts,
+			registryTokens,
+		)
+
+		if actionErr != nil {
+			return "", m.redactError(ctx, WrapErrorf(actionErr, "failed to create base Terraform container"))
+		}
 
-	return actionOutput, nil
+		return m.processActionResult(ctx, tfModulePath+".docs", action)
+	})
 }
 
 // ActionTerraformLint performs linting checks on Terraform code using TFLint.
@@ -842,12 +997,21 @@ func (m *Infra) ActionTerraformLint(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
 	// tflintVersion is the TFLint version to use.
 	// +optional
 	tflintVersion string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (*dagger.Container, error) {
 	tfLintConfigFile := ".tflint.hcl"
 
@@ -866,25 +1030,50 @@ func (m *Infra) ActionTerraformLint(
 		nil,
 		nil,
 		nil,
+		loadDotEnvFile,
+		example,
+		noCache,
+		nil,
+		nil,
+		"",
+		"",
+		"",
+		"",
+		nil,
+		nil,
+		registryHosts,
+		registryTokens,
 		"",
 		nil,
 		nil,
 		nil,
-		loadDotEnvFile,
-		noCache,
 		nil,
 		nil,
 		"",
 		"",
 		"",
 		"",
+		"",
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		"",
+		"",
+		nil,
+		"",
+		"",
 	)
 
 	if err != nil {
 		return nil, WrapErrorf(err, "failed to create base Terraform container")
 	}
 
-	baseContainer = addDaggerCMDs(baseContainer, tfLintCommands...)
+	baseContainer, err = m.runActionCMDs(ctx, baseContainer, tfLintCommands...)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to run lint commands")
+	}
 
 	return baseContainer, nil
 }
@@ -900,30 +1089,45 @@ func (m *Infra) ActionTerraformLintExec(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
 	// tflintVersion is the TFLint version to use.
 	// +optional
 	tflintVersion string,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
 ) (string, error) {
-	action, actionErr := m.ActionTerraformLint(
-		ctx,
-		tfModulePath,
-		loadDotEnvFile,
-		noCache,
-		tflintVersion,
-	)
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
 
-	if actionErr != nil {
-		return "", WrapErrorf(actionErr, "failed to create base Terraform container")
+	if tflintVersion == "" {
+		tflintVersion = defaultTFLintVersion
 	}
 
-	actionOutput, actionOutputErr := action.Stdout(ctx)
-
-	if actionOutputErr != nil {
-		return "", WrapErrorf(actionOutputErr, "failed to get action output")
-	}
+	return m.runWithResultCache(ctx, tfModulePath, "lint", []string{"tflint=" + tflintVersion}, noCache, func() (string, error) {
+		action, actionErr := m.ActionTerraformLint(
+			ctx,
+			tfModulePath,
+			loadDotEnvFile,
+			example,
+			noCache,
+			tflintVersion,
+			registryHosts,
+			registryTokens,
+		)
+
+		if actionErr != nil {
+			return "", m.redactError(ctx, WrapErrorf(actionErr, "failed to create base Terraform container"))
+		}
 
-	return actionOutput, nil
+		return m.processActionResult(ctx, tfModulePath+".lint", action)
+	})
 }
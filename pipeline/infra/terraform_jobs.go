@@ -4,6 +4,7 @@ import (
 	"context"
 	"dagger/infra/internal/dagger"
 	"path/filepath"
+	"strings"
 )
 
 // JobTerraform performs a command on Terraform by:
@@ -14,18 +15,6 @@ func (m *Infra) JobTerraform(
 	// tfModulePath is the path to the Terraform modules.
 	// +optional
 	tfModulePath string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -38,6 +27,9 @@ func (m *Infra) JobTerraform(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -59,6 +51,81 @@ func (m *Infra) JobTerraform(
 	// terraformDocsVersion is the terraform-docs version to use.
 	// +optional
 	terraformDocsVersion string,
+	// netrcMachines are the .netrc machines, as "host:login", e.g. "github.example.com:ci-bot".
+	// +optional
+	netrcMachines []string,
+	// netrcPasswords are the .netrc passwords or access tokens, in the same order as netrcMachines.
+	// +optional
+	netrcPasswords []*dagger.Secret,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
+	// backendType is the backend type, e.g. "s3" or "http", for modules without a backend block.
+	// +optional
+	backendType string,
+	// backendConfig are partial backend configuration values, as "key=value", passed with -backend-config.
+	// +optional
+	backendConfig []string,
+	// backendConfigFiles are backend configuration files (e.g. file:./prod.s3.tfbackend), mounted as secrets.
+	// +optional
+	backendConfigFiles []*dagger.Secret,
+	// awsAccessKeyID is the AWS access key ID.
+	// +optional
+	awsAccessKeyID *dagger.Secret,
+	// awsSecretAccessKey is the AWS secret access key.
+	// +optional
+	awsSecretAccessKey *dagger.Secret,
+	// awsSessionToken is the AWS session token.
+	// +optional
+	awsSessionToken *dagger.Secret,
+	// awsRegion is the AWS region.
+	// +optional
+	awsRegion string,
+	// awsProfile is the AWS profile to use, from the shared config mounted with WithAWSSharedConfig.
+	// +optional
+	awsProfile string,
+	// azureClientID is the client (application) ID of the Azure service principal or federated identity.
+	// +optional
+	azureClientID string,
+	// azureTenantID is the Azure AD tenant ID.
+	// +optional
+	azureTenantID string,
+	// azureSubscriptionID is the Azure subscription ID.
+	// +optional
+	azureSubscriptionID string,
+	// azureClientSecret is the client secret of the Azure service principal.
+	// +optional
+	azureClientSecret *dagger.Secret,
+	// azureClientCertificate is the client certificate of the Azure service principal, in PKCS#12 format.
+	// +optional
+	azureClientCertificate *dagger.Secret,
+	// azureClientCertificatePassword is the password of the Azure client certificate.
+	// +optional
+	azureClientCertificatePassword *dagger.Secret,
+	// azureOidcToken is the OIDC token exchanged for an Azure access token.
+	// +optional
+	azureOidcToken *dagger.Secret,
+	// gcpCredentials is the GCP service-account JSON key.
+	// +optional
+	gcpCredentials *dagger.Secret,
+	// gcpWorkloadIdentityProvider is the GCP workload identity provider resource name.
+	// +optional
+	gcpWorkloadIdentityProvider string,
+	// gcpServiceAccountEmail is the GCP service account impersonated with workload identity federation.
+	// +optional
+	gcpServiceAccountEmail string,
+	// gcpOidcToken is the OIDC token exchanged for a GCP access token.
+	// +optional
+	gcpOidcToken *dagger.Secret,
+	// gcpProject is the default GCP project.
+	// +optional
+	gcpProject string,
+	// gcpRegion is the default GCP region.
+	// +optional
+	gcpRegion string,
 ) (*dagger.Container, error) {
 	job := m
 
@@ -66,8 +133,68 @@ func (m *Infra) JobTerraform(
 		job = job.WithCacheBuster()
 	}
 
-	if awsAccessKeyID != nil && awsSecretAccessKey != nil {
-		job = job.WithAWSKeys(ctx, awsAccessKeyID, awsSecretAccessKey, awsRegion, awsSessionToken)
+	// Cloud credentials are applied through credential providers, which validate them, register
+	// their secrets for redaction and record what they inject for the report.
+	if awsAccessKeyID != nil || awsSecretAccessKey != nil {
+		mWithAWSKeys, err := job.applyAWSStaticKeysProvider(
+			newAWSStaticKeysProvider(awsAccessKeyID, awsSecretAccessKey, awsRegion, awsSessionToken))
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to set the AWS credentials")
+		}
+
+		job = mWithAWSKeys
+	}
+
+	if awsProfile != "" {
+		mWithAWSProfile, err := job.WithAWSProfile(awsProfile, awsRegion)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to select the AWS profile")
+		}
+
+		job = mWithAWSProfile
+	}
+
+	azureProvider, err := newAzureCredentialProvider(
+		azureClientID,
+		azureTenantID,
+		azureSubscriptionID,
+		azureClientSecret,
+		azureClientCertificate,
+		azureClientCertificatePassword,
+		azureOidcToken,
+	)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to configure the Azure credentials")
+	}
+
+	if azureProvider != nil {
+		mWithAzure, err := job.applyAzureCredentialProvider(azureProvider)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to set the Azure credentials")
+		}
+
+		job = mWithAzure
+	}
+
+	gcpProvider, err := newGCPCredentialProvider(
+		gcpCredentials,
+		gcpWorkloadIdentityProvider,
+		gcpServiceAccountEmail,
+		gcpOidcToken,
+		gcpProject,
+		gcpRegion,
+	)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to configure the GCP credentials")
+	}
+
+	if gcpProvider != nil {
+		mWithGCP, err := job.applyGCPCredentialProvider(gcpProvider)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to set the GCP credentials")
+		}
+
+		job = mWithGCP
 	}
 
 	if tfRegistryGitlabToken != nil {
@@ -118,8 +245,48 @@ func (m *Infra) JobTerraform(
 		job = job.WithSSHAuthSocket(gitSSH, "", "", false, true)
 	}
 
+	if len(registryHosts) > 0 || len(registryTokens) > 0 {
+		mWithRegistryTokens, err := job.WithRegistryTokens(registryHosts, registryTokens)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to set the registry tokens")
+		}
+
+		job = mWithRegistryTokens
+	}
+
+	if len(netrcMachines) > 0 || len(netrcPasswords) > 0 {
+		mWithNetrc, err := job.WithNetrcCredentials(ctx, netrcMachines, netrcPasswords)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to configure the .netrc credentials")
+		}
+
+		job = mWithNetrc
+	}
+
+	if backendType != "" || len(backendConfig) > 0 || len(backendConfigFiles) > 0 {
+		mWithBackend, err := job.WithBackend(ctx, backendType, backendConfig, nil, backendConfigFiles, nil, nil)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to configure the backend")
+		}
+
+		job = mWithBackend
+	}
+
 	if loadDotEnvFile {
-		mDecorated, err := job.WithDotEnvFile(ctx, job.Src)
+		var moduleDir, exampleDir string
+		if tfModulePath != "" {
+			moduleDir = getTerraformModulesExecutionPath(tfModulePath)
+		}
+
+		if example != "" {
+			if tfModulePath == "" || example != filepath.Base(example) || strings.HasPrefix(example, ".") {
+				return nil, Errorf("invalid example %q, expected the name of an example of the module, e.g. \"basic\"", example)
+			}
+
+			exampleDir = filepath.Join(configExamplesRootPath, tfModulePath, example)
+		}
+
+		mDecorated, err := job.WithDotEnvFile(ctx, job.Src, moduleDir, exampleDir)
 		if err != nil {
 			return nil, WrapErrorf(err, "failed to source .env files from the local directory")
 		}
@@ -144,18 +311,6 @@ func (m *Infra) JobTerraformExec(
 	// arguments are the optional arguments to pass to the Terraform command
 	// +optional
 	arguments []string,
-	// awsAccessKeyID is the AWS access key ID.
-	// +optional
-	awsAccessKeyID *dagger.Secret,
-	// awsSecretAccessKey is the AWS secret access key.
-	// +optional
-	awsSecretAccessKey *dagger.Secret,
-	// awsSessionToken is the AWS session token.
-	// +optional
-	awsSessionToken *dagger.Secret,
-	// awsRegion is the AWS region to use for the remote backend.
-	// +optional
-	awsRegion string,
 	// tfRegistryGitlabToken is the Terraform Gitlab token.
 	// +optional
 	tfRegistryGitlabToken *dagger.Secret,
@@ -168,6 +323,9 @@ func (m *Infra) JobTerraformExec(
 	// loadDotEnvFile is a flag to enable source .env files from the local directory.
 	// +optional
 	loadDotEnvFile bool,
+	// example is the example whose .env files are loaded last, e.g. "basic" for examples/<module>/basic.
+	// +optional
+	example string,
 	// NoCache is a flag to disable caching of the container.
 	// +optional
 	noCache bool,
@@ -189,19 +347,94 @@ func (m *Infra) JobTerraformExec(
 	// terraformDocsVersion is the terraform-docs version to use.
 	// +optional
 	terraformDocsVersion string,
+	// netrcMachines are the .netrc machines, as "host:login", e.g. "github.example.com:ci-bot".
+	// +optional
+	netrcMachines []string,
+	// netrcPasswords are the .netrc passwords or access tokens, in the same order as netrcMachines.
+	// +optional
+	netrcPasswords []*dagger.Secret,
+	// registryHosts are the private Terraform registry hostnames, e.g. "registry.example.com".
+	// +optional
+	registryHosts []string,
+	// registryTokens are the API tokens of the registries, in the same order as registryHosts.
+	// +optional
+	registryTokens []*dagger.Secret,
+	// backendType is the backend type, e.g. "s3" or "http", for modules without a backend block.
+	// +optional
+	backendType string,
+	// backendConfig are partial backend configuration values, as "key=value", passed with -backend-config.
+	// +optional
+	backendConfig []string,
+	// backendConfigFiles are backend configuration files (e.g. file:./prod.s3.tfbackend), mounted as secrets.
+	// +optional
+	backendConfigFiles []*dagger.Secret,
+	// awsAccessKeyID is the AWS access key ID.
+	// +optional
+	awsAccessKeyID *dagger.Secret,
+	// awsSecretAccessKey is the AWS secret access key.
+	// +optional
+	awsSecretAccessKey *dagger.Secret,
+	// awsSessionToken is the AWS session token.
+	// +optional
+	awsSessionToken *dagger.Secret,
+	// awsRegion is the AWS region.
+	// +optional
+	awsRegion string,
+	// awsProfile is the AWS profile to use, from the shared config mounted with WithAWSSharedConfig.
+	// +optional
+	awsProfile string,
+	// azureClientID is the client (application) ID of the Azure service principal or federated identity.
+	// +optional
+	azureClientID string,
+	// azureTenantID is the Azure AD tenant ID.
+	// +optional
+	azureTenantID string,
+	// azureSubscriptionID is the Azure subscription ID.
+	// +optional
+	azureSubscriptionID string,
+	// azureClientSecret is the client secret of the Azure service principal.
+	// +optional
+	azureClientSecret *dagger.Secret,
+	// azureClientCertificate is the client certificate of the Azure service principal, in PKCS#12 format.
+	// +optional
+	azureClientCertificate *dagger.Secret,
+	// azureClientCertificatePassword is the password of the Azure client certificate.
+	// +optional
+	azureClientCertificatePassword *dagger.Secret,
+	// azureOidcToken is the OIDC token exchanged for an Azure access token.
+	// +optional
+	azureOidcToken *dagger.Secret,
+	// gcpCredentials is the GCP service-account JSON key.
+	// +optional
+	gcpCredentials *dagger.Secret,
+	// gcpWorkloadIdentityProvider is the GCP workload identity provider resource name.
+	// +optional
+	gcpWorkloadIdentityProvider string,
+	// gcpServiceAccountEmail is the GCP service account impersonated with workload identity federation.
+	// +optional
+	gcpServiceAccountEmail string,
+	// gcpOidcToken is the OIDC token exchanged for a GCP access token.
+	// +optional
+	gcpOidcToken *dagger.Secret,
+	// gcpProject is the default GCP project.
+	// +optional
+	gcpProject string,
+	// gcpRegion is the default GCP region.
+	// +optional
+	gcpRegion string,
 ) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
 	// Get the base container using JobTerraform
 	container, err := m.JobTerraform(
 		ctx,
 		tfModulePath,
-		awsAccessKeyID,
-		awsSecretAccessKey,
-		awsSessionToken,
-		awsRegion,
 		tfRegistryGitlabToken,
 		gitHubToken,
 		gitlabToken,
 		loadDotEnvFile,
+		example,
 		noCache,
 		envVars,
 		gitSSH,
@@ -209,28 +442,61 @@ func (m *Infra) JobTerraformExec(
 		dotTerraformVersion,
 		tflintVersion,
 		terraformDocsVersion,
+		netrcMachines,
+		netrcPasswords,
+		registryHosts,
+		registryTokens,
+		backendType,
+		backendConfig,
+		backendConfigFiles,
+		awsAccessKeyID,
+		awsSecretAccessKey,
+		awsSessionToken,
+		awsRegion,
+		awsProfile,
+		azureClientID,
+		azureTenantID,
+		azureSubscriptionID,
+		azureClientSecret,
+		azureClientCertificate,
+		azureClientCertificatePassword,
+		azureOidcToken,
+		gcpCredentials,
+		gcpWorkloadIdentityProvider,
+		gcpServiceAccountEmail,
+		gcpOidcToken,
+		gcpProject,
+		gcpRegion,
 	)
 
 	if err != nil {
-		return "", WrapErrorf(err, "failed to create base Terraform container")
+		return "", m.redactError(ctx, WrapErrorf(err, "failed to create base Terraform container"))
 	}
 
 	// Build the terraform command with arguments
 	terraformCmd, err := buildTerraformCommand(command, arguments)
 	if err != nil {
-		return "", WrapErrorf(err, "failed to build Terraform command")
+		return "", m.redactError(ctx, WrapErrorf(err, "failed to build Terraform command"))
 	}
 
-	// Execute the terraform command
-	container = container.
-		WithExec(terraformCmd)
+	// With a backend configured, 'init' targets the backend, and other commands run after it.
+	terraformCmds := []DaggerCMD{terraformCmd}
+
+	if m.Backend != nil {
+		if command == "init" {
+			terraformCmds = []DaggerCMD{append(terraformCmd, m.getBackendInitArgs()...)}
+		} else {
+			terraformCmds = []DaggerCMD{m.getTerraformInitCmd(true), terraformCmd}
+		}
 
-	// Get the output of the container
-	output, err := container.Stdout(ctx)
+		container = m.withBackendOverride(container)
+	}
 
+	// Execute the terraform command, applying the retry policy and timeouts
+	container, err = m.runActionCMDs(ctx, container, terraformCmds...)
 	if err != nil {
-		return "", WrapErrorf(err, "failed to get output from Terraform container")
+		return "", m.redactError(ctx, WrapErrorf(err, "failed to execute Terraform command"))
 	}
 
-	return output, nil
+	return m.processActionOutput(ctx, tfModulePath+"."+command, container)
 }
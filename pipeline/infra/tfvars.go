@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Names of the var files generated for typed variables, loaded automatically by Terraform
+	generatedTFVarsFileName          = "pipeline.auto.tfvars.json"
+	generatedSensitiveTFVarsFileName = "pipeline-sensitive.auto.tfvars.json"
+)
+
+// terraformVariable is a variable declared by a module.
+type terraformVariable struct {
+	name      string
+	varType   cty.Type
+	sensitive bool
+	nullable  bool
+}
+
+// terraformVariableSchema is the part of a 'variable' block used to validate values.
+var terraformVariableSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "type"},
+		{Name: "sensitive"},
+		{Name: "nullable"},
+	},
+}
+
+// parseTerraformVariables parses the variables declared in the .tf files of a module.
+//
+// Parameters:
+//   - files: The contents of the .tf files, by file name
+//
+// Returns:
+//   - map[string]*terraformVariable: The declared variables, by name
+//   - error: An error if a file or a type constraint cannot be parsed
+func parseTerraformVariables(files map[string]string) (map[string]*terraformVariable, error) {
+	parser := hclparse.NewParser()
+	variables := map[string]*terraformVariable{}
+
+	for name, contents := range files {
+		file, diags := parser.ParseHCL([]byte(contents), name)
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		content, _, diags := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "variable", LabelNames: []string{"name"}}},
+		})
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			variable, err := parseTerraformVariable(block)
+			if err != nil {
+				return nil, err
+			}
+
+			variables[variable.name] = variable
+		}
+	}
+
+	return variables, nil
+}
+
+// parseTerraformVariable reads the type, sensitivity and nullability of a 'variable' block.
+func parseTerraformVariable(block *hcl.Block) (*terraformVariable, error) {
+	variable := &terraformVariable{name: block.Labels[0], varType: cty.DynamicPseudoType, nullable: true}
+
+	content, _, diags := block.Body.PartialContent(terraformVariableSchema)
+	if diags.HasErrors() {
+		return nil, Errorf("invalid variable %q: %s", variable.name, diags.Error())
+	}
+
+	if attr, ok := content.Attributes["type"]; ok {
+		varType, _, diags := typeexpr.TypeConstraintWithDefaults(attr.Expr)
+		if diags.HasErrors() {
+			return nil, Errorf("invalid type of variable %q: %s", variable.name, diags.Error())
+		}
+
+		variable.varType = varType
+	}
+
+	for attrName, target := range map[string]*bool{"sensitive": &variable.sensitive, "nullable": &variable.nullable} {
+		attr, ok := content.Attributes[attrName]
+		if !ok {
+			continue
+		}
+
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || value.Type() != cty.Bool || value.IsNull() {
+			return nil, Errorf("invalid %s attribute of variable %q, expected a literal bool", attrName, variable.name)
+		}
+
+		*target = value.True()
+	}
+
+	return variable, nil
+}
+
+// parseTypedVariables parses a JSON or YAML document holding variable values by name. JSON is a
+// subset of YAML, so both are read as YAML and normalised to JSON values.
+func parseTypedVariables(document string) (map[string]json.RawMessage, error) {
+	var decoded any
+	if err := yaml.Unmarshal([]byte(document), &decoded); err != nil {
+		return nil, Errorf("variables must be a JSON or YAML object: %v", err)
+	}
+
+	if decoded == nil {
+		return map[string]json.RawMessage{}, nil
+	}
+
+	if _, ok := decoded.(map[string]any); !ok {
+		return nil, Errorf("variables must be a JSON or YAML object, got %T", decoded)
+	}
+
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, Errorf("variables cannot be represented as JSON: %v", err)
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &values); err != nil {
+		return nil, Errorf("variables must be a JSON or YAML object: %v", err)
+	}
+
+	return values, nil
+}
+
+// validateTypedVariable checks a JSON value against the type declared for a variable.
+func validateTypedVariable(variable *terraformVariable, value json.RawMessage) error {
+	if string(value) == "null" {
+		if !variable.nullable {
+			return Errorf("variable %q is not nullable", variable.name)
+		}
+
+		return nil
+	}
+
+	impliedType, err := ctyjson.ImpliedType(value)
+	if err != nil {
+		return Errorf("invalid value for variable %q: %v", variable.name, err)
+	}
+
+	decoded, err := ctyjson.Unmarshal(value, impliedType)
+	if err != nil {
+		return Errorf("invalid value for variable %q: %v", variable.name, err)
+	}
+
+	if _, err := convert.Convert(decoded, variable.varType); err != nil {
+		return Errorf("invalid value for variable %q, expected %s: %v",
+			variable.name, typeexpr.TypeString(variable.varType), err)
+	}
+
+	return nil
+}
+
+// renderTFVarsJSON renders variable values as a .tfvars.json document, with sorted keys.
+func renderTFVarsJSON(values map[string]json.RawMessage) (string, error) {
+	rendered, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return "", Errorf("failed to render the variables: %v", err)
+	}
+
+	return string(rendered) + "\n", nil
+}
+
+// readModuleTerraformFiles reads the .tf files of a module from the source directory.
+func (m *Infra) readModuleTerraformFiles(ctx context.Context, moduleDir string) (map[string]string, error) {
+	tfFiles, err := m.Src.Glob(ctx, filepath.Join(moduleDir, "*.tf"))
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to list the Terraform files of %s", moduleDir)
+	}
+
+	if len(tfFiles) == 0 {
+		return nil, Errorf("no Terraform file found in %s", moduleDir)
+	}
+
+	files := make(map[string]string, len(tfFiles))
+
+	for _, tfFile := range tfFiles {
+		contents, err := m.Src.File(tfFile).Contents(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read %s", tfFile)
+		}
+
+		files[tfFile] = contents
+	}
+
+	return files, nil
+}
+
+// WithTypedTerraformVariables passes typed values (strings, numbers, bools, lists, maps and
+// objects) to the variables of a module.
+//
+// Values are given as a JSON or YAML object, by variable name. They are validated against the
+// types declared in the module's .tf files, then written to a 'pipeline.auto.tfvars.json' file in
+// the module directory, which Terraform loads automatically. Values of the variables declared
+// sensitive, and every value of sensitiveVariables, are written to a separate
+// 'pipeline-sensitive.auto.tfvars.json' file mounted as a secret.
+//
+// Parameters:
+//   - ctx: Context for the Dagger operations
+//   - tfModulePath: The module the variables belong to
+//   - variables: The values, as a JSON or YAML object (optional)
+//   - variablesFile: A JSON or YAML file with values, overridden by variables (optional)
+//   - sensitiveVariables: Sensitive values, as a JSON or YAML object (optional)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the var files generated
+//   - error: An error if a value is invalid, or a variable is not declared by the module
+func (m *Infra) WithTypedTerraformVariables(
+	// ctx is the context for the Dagger operations.
+	// +optional
+	ctx context.Context,
+	// tfModulePath is the path to the Terraform module, e.g. "default".
+	tfModulePath string,
+	// variables are the values, as a JSON or YAML object, e.g. '{"tags": {"team": "infra"}, "azs": ["a", "b"]}'.
+	// +optional
+	variables string,
+	// variablesFile is a JSON or YAML file with values. Values in variables take precedence.
+	// +optional
+	variablesFile *dagger.File,
+	// sensitiveVariables are sensitive values, as a JSON or YAML object.
+	// +optional
+	sensitiveVariables *dagger.Secret,
+) (*Infra, error) {
+	if m.Src == nil {
+		return nil, NewError("failed to set the typed variables, the source directory is nil")
+	}
+
+	moduleDir := getTerraformModulesExecutionPath(tfModulePath)
+
+	tfFiles, err := m.readModuleTerraformFiles(ctx, moduleDir)
+	if err != nil {
+		return nil, err
+	}
+
+	declared, err := parseTerraformVariables(tfFiles)
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to read the variables declared by %s", moduleDir)
+	}
+
+	values := map[string]json.RawMessage{}
+	sensitiveValues := map[string]json.RawMessage{}
+
+	var documents []string
+
+	if variablesFile != nil {
+		contents, err := variablesFile.Contents(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the variables file")
+		}
+
+		documents = append(documents, contents)
+	}
+
+	documents = append(documents, variables)
+
+	for _, document := range documents {
+		parsed, err := parseTypedVariables(document)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, value := range parsed {
+			values[name] = value
+		}
+	}
+
+	if sensitiveVariables != nil {
+		document, err := sensitiveVariables.Plaintext(ctx)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the sensitive variables")
+		}
+
+		sensitiveValues, err = parseTypedVariables(document)
+		if err != nil {
+			return nil, WrapErrorf(err, "invalid sensitive variables")
+		}
+	}
+
+	// Values of variables declared sensitive never go to the plain var file.
+	for name, value := range values {
+		if variable, ok := declared[name]; ok && variable.sensitive {
+			sensitiveValues[name] = value
+
+			delete(values, name)
+		}
+	}
+
+	var undeclared []string
+
+	for _, set := range []map[string]json.RawMessage{values, sensitiveValues} {
+		for name, value := range set {
+			variable, ok := declared[name]
+			if !ok {
+				undeclared = append(undeclared, name)
+
+				continue
+			}
+
+			if err := validateTypedVariable(variable, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(undeclared) > 0 {
+		sort.Strings(undeclared)
+
+		return nil, Errorf("variables not declared by %s: %s", moduleDir, strings.Join(undeclared, ", "))
+	}
+
+	moduleContainerPath := filepath.Join(defaultMntPath, moduleDir)
+
+	if len(values) > 0 {
+		rendered, err := renderTFVarsJSON(values)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Ctr = m.Ctr.WithNewFile(filepath.Join(moduleContainerPath, generatedTFVarsFileName), rendered)
+	}
+
+	if len(sensitiveValues) > 0 {
+		rendered, err := renderTFVarsJSON(sensitiveValues)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, value := range sensitiveValues {
+			var decoded any
+			if json.Unmarshal(value, &decoded) == nil {
+				m.registerSensitiveJSONValue(decoded)
+			}
+		}
+
+		m.Ctr = m.Ctr.WithMountedSecret(
+			filepath.Join(moduleContainerPath, generatedSensitiveTFVarsFileName),
+			newDigestNamedSecret("sensitive-tfvars", rendered),
+			dagger.ContainerWithMountedSecretOpts{Mode: credentialFileMode},
+		)
+	}
+
+	return m, nil
+}
+
+// registerSensitiveJSONValue registers every scalar of a sensitive value for redaction.
+func (m *Infra) registerSensitiveJSONValue(value any) {
+	switch typed := value.(type) {
+	case map[string]any:
+		for _, nested := range typed {
+			m.registerSensitiveJSONValue(nested)
+		}
+	case []any:
+		for _, nested := range typed {
+			m.registerSensitiveJSONValue(nested)
+		}
+	case string:
+		m.registerSecretValue(typed)
+	case nil, bool:
+		// Too short to be redacted meaningfully.
+	default:
+		m.registerSecretValue(fmt.Sprint(typed))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// testVariablesTF declares variables of every kind of type validated by validateTypedVariable.
+const testVariablesTF = `
+variable "name" {
+  type = string
+}
+
+variable "replicas" {
+  type     = number
+  nullable = false
+}
+
+variable "enabled" {
+  type = bool
+}
+
+variable "zones" {
+  type = list(string)
+}
+
+variable "tags" {
+  type = map(string)
+}
+
+variable "settings" {
+  type = object({
+    size   = number
+    labels = optional(list(string))
+  })
+}
+
+variable "anything" {}
+`
+
+// TestValidateTypedVariable verifies that JSON values are checked against the declared types,
+// with Terraform's conversion rules, and that null is only accepted for nullable variables.
+func TestValidateTypedVariable(t *testing.T) {
+	t.Parallel()
+
+	variables, err := parseTerraformVariables(map[string]string{"variables.tf": testVariablesTF})
+	if err != nil {
+		t.Fatalf("parseTerraformVariables() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		variable string
+		value    string
+		wantErr  bool
+	}{
+		{name: "string", variable: "name", value: `"web"`},
+		{name: "number converted to string", variable: "name", value: `42`},
+		{name: "object is not a string", variable: "name", value: `{"a": 1}`, wantErr: true},
+		{name: "number", variable: "replicas", value: `3`},
+		{name: "numeric string converted to number", variable: "replicas", value: `"3"`},
+		{name: "text is not a number", variable: "replicas", value: `"three"`, wantErr: true},
+		{name: "null for a non-nullable variable", variable: "replicas", value: `null`, wantErr: true},
+		{name: "null for a nullable variable", variable: "name", value: `null`},
+		{name: "bool", variable: "enabled", value: `true`},
+		{name: "list of strings", variable: "zones", value: `["a", "b"]`},
+		{name: "list of objects is not a list of strings", variable: "zones", value: `[{"a": 1}]`, wantErr: true},
+		{name: "map of strings", variable: "tags", value: `{"env": "dev", "team": "core"}`},
+		{name: "object with an optional attribute", variable: "settings", value: `{"size": 2}`},
+		{name: "object with every attribute", variable: "settings", value: `{"size": 2, "labels": ["x"]}`},
+		{name: "object without a required attribute", variable: "settings", value: `{"labels": ["x"]}`, wantErr: true},
+		{name: "untyped variable", variable: "anything", value: `{"nested": [1, "two", true]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			variable, ok := variables[tt.variable]
+			if !ok {
+				t.Fatalf("variable %q not parsed", tt.variable)
+			}
+
+			err := validateTypedVariable(variable, json.RawMessage(tt.value))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTypedVariable(%s, %s) error = %v, wantErr %v", tt.variable, tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Exit codes reported when a step is stopped by the 'timeout' utility: GNU coreutils reports 124,
+	// while busybox (alpine based images) reports the signal that stopped the process (SIGTERM).
+	stepTimeoutExitCode       = 124
+	stepTimeoutSignalExitCode = 143
+	// stepTimeoutGracePeriod is how long the exec of a step may outlive its timeout before it is
+	// cancelled through its context, e.g. when the image has no 'timeout' utility.
+	stepTimeoutGracePeriod = 10 * time.Second
+)
+
+// stepTimeoutScript runs a command under the 'timeout' utility when the image provides it, and
+// as is otherwise, in which case the step is bounded by the context deadline of its exec alone.
+const stepTimeoutScript = `limit="$1"; shift
+if command -v timeout >/dev/null 2>&1; then exec timeout -s TERM "$limit" "$@"; fi
+exec "$@"`
+
+// StepTimeout overrides the step timeout for the commands that start with a given prefix.
+type StepTimeout struct {
+	// Command is the command prefix the timeout applies to (e.g. "terraform init").
+	Command string
+	// Seconds is the maximum duration of a matching step.
+	Seconds int
+}
+
+// WithActionTimeout bounds the execution of every action.
+//
+// When the timeout expires, the action is cancelled through its context and the report names
+// the step that was running. A value of 0 disables the action timeout.
+//
+// Parameters:
+//   - timeoutSeconds: The maximum duration of an action, in seconds
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the action timeout set
+//   - error: An error if the timeout is negative
+func (m *Infra) WithActionTimeout(
+	// timeoutSeconds is the maximum duration of an action, in seconds.
+	timeoutSeconds int,
+) (*Infra, error) {
+	if timeoutSeconds < 0 {
+		return nil, Errorf("action timeout must not be negative, got %d", timeoutSeconds)
+	}
+
+	m.ActionTimeoutSeconds = timeoutSeconds
+
+	return m, nil
+}
+
+// WithStepTimeout bounds the execution of the steps run by the actions.
+//
+// Without a command, the timeout applies to every step. With a command (e.g. "terraform init"),
+// it only applies to the steps that start with it, and takes precedence over the default one.
+// Steps are stopped with the 'timeout' utility, so the report shows how much output the step
+// produced before it was stopped. Images without it are supported: the exec of the step is then
+// cancelled through its context shortly after the limit. A value of 0 removes the timeout.
+//
+// Parameters:
+//   - timeoutSeconds: The maximum duration of a step, in seconds
+//   - command: The command prefix the timeout applies to (optional, defaults to every step)
+//
+// Returns:
+//   - *Infra: The updated Infra instance with the step timeout set
+//   - error: An error if the timeout is negative
+func (m *Infra) WithStepTimeout(
+	// timeoutSeconds is the maximum duration of a step, in seconds.
+	timeoutSeconds int,
+	// command is the command prefix the timeout applies to, e.g. "terraform init".
+	// +optional
+	command string,
+) (*Infra, error) {
+	if timeoutSeconds < 0 {
+		return nil, Errorf("step timeout must not be negative, got %d", timeoutSeconds)
+	}
+
+	command = strings.Join(strings.Fields(command), " ")
+
+	if command == "" || command == "*" {
+		m.StepTimeoutSeconds = timeoutSeconds
+
+		return m, nil
+	}
+
+	for _, stepTimeout := range m.StepTimeouts {
+		if stepTimeout.Command == command {
+			stepTimeout.Seconds = timeoutSeconds
+
+			return m, nil
+		}
+	}
+
+	m.StepTimeouts = append(m.StepTimeouts, &StepTimeout{Command: command, Seconds: timeoutSeconds})
+
+	return m, nil
+}
+
+// hasTimeouts reports whether an action or step timeout is configured.
+func (m *Infra) hasTimeouts() bool {
+	return m.ActionTimeoutSeconds > 0 || m.StepTimeoutSeconds > 0 || len(m.StepTimeouts) > 0
+}
+
+// getStepTimeout returns the timeout that applies to a command: the override with the longest
+// matching command prefix, or the default step timeout. It returns 0 when no timeout applies.
+func (m *Infra) getStepTimeout(command DaggerCMD) time.Duration {
+	commandLine := strings.Join(command, " ")
+	timeoutSeconds := m.StepTimeoutSeconds
+	longestMatch := -1
+
+	for _, stepTimeout := range m.StepTimeouts {
+		matches := commandLine == stepTimeout.Command || strings.HasPrefix(commandLine, stepTimeout.Command+" ")
+		if matches && len(stepTimeout.Command) > longestMatch {
+			timeoutSeconds = stepTimeout.Seconds
+			longestMatch = len(stepTimeout.Command)
+		}
+	}
+
+	return time.Duration(timeoutSeconds) * time.Second
+}
+
+// withActionTimeout derives a context bounded by the configured action timeout. When no action
+// timeout is configured, the context is returned as is, with a no-op cancel function.
+func (m *Infra) withActionTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.ActionTimeoutSeconds <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, time.Duration(m.ActionTimeoutSeconds)*time.Second)
+}
+
+// wrapWithStepTimeout wraps a command so it is stopped with SIGTERM by the 'timeout' utility once
+// the limit is exceeded, when the image provides it (see stepTimeoutScript).
+func wrapWithStepTimeout(command DaggerCMD, limit time.Duration) DaggerCMD {
+	seconds := int(limit.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return append(DaggerCMD{"sh", "-c", stepTimeoutScript, "sh", strconv.Itoa(seconds)}, command...)
+}
+
+// isStepTimeout reports whether a step wrapped with wrapWithStepTimeout was stopped by the
+// 'timeout' utility: it exited with one of the codes the utility reports, after running for at
+// least its limit. A step that exits with 124 or 143 on its own, earlier, is a plain failure.
+func isStepTimeout(exitCode int, elapsed, limit time.Duration) bool {
+	if limit <= 0 || elapsed < limit {
+		return false
+	}
+
+	return exitCode == stepTimeoutExitCode || exitCode == stepTimeoutSignalExitCode
+}
@@ -5,6 +5,7 @@ import (
 	"dagger/infra/internal/dagger"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -61,6 +62,21 @@ func getTerraformDocsInstallCmd(terraformDocsVersion string) string {
 	return strings.TrimSpace(command)
 }
 
+// getSOPSInstallCmd generates the installation command for SOPS.
+// If version is empty, it uses the default version defined in constants.
+func getSOPSInstallCmd(sopsVersion string) string {
+	if sopsVersion == "" {
+		sopsVersion = defaultSOPSVersion
+	}
+
+	installDir := "/usr/local/bin/sops"
+	command := fmt.Sprintf(`apk add --no-cache curl &&
+	curl -Lo %[2]s https://github.com/getsops/sops/releases/download/v%[1]s/sops-v%[1]s.linux.amd64 &&
+	chmod +x %[2]s`, sopsVersion, installDir)
+
+	return strings.TrimSpace(command)
+}
+
 func isTfModuleDir(ctx context.Context, dir *dagger.Directory, extraFilesToCheck []string) error {
 	entries, err := dir.Entries(ctx)
 	if err != nil {
@@ -249,8 +265,8 @@ type EnvVarDagger struct {
 //
 // Validation Rules:
 //   - Environment variable strings cannot be empty or whitespace-only
-//   - Each string must contain exactly one '=' character
-//   - The format must be exactly "KEY=VALUE" with no additional '=' characters
+//   - Each string must contain a '=' character
+//   - The key ends at the first '='; the value can contain further '=' characters
 //   - Keys cannot be empty after trimming whitespace
 //
 // Example Usage:
@@ -264,7 +280,7 @@ type EnvVarDagger struct {
 // Error Cases:
 //   - Empty or whitespace-only strings: "environment variable cannot be empty"
 //   - Missing '=' separator: "environment variable must be in the format ENVARKEY=VALUE"
-//   - Multiple '=' characters: "environment variable must be in the format ENVARKEY=VALUE"
+//   - Empty key: "environment variable must be in the format ENVARKEY=VALUE"
 func getEnvVarsDaggerFromSlice(envVars []string) ([]EnvVarDagger, error) {
 	envVarsDagger := []EnvVarDagger{}
 	for _, envVar := range envVars {
@@ -277,8 +293,9 @@ func getEnvVarsDaggerFromSlice(envVars []string) ([]EnvVarDagger, error) {
 			return nil, NewError(fmt.Sprintf("environment variable must be in the format ENVARKEY=VALUE: %s", trimmedEnvVar))
 		}
 
-		parts := strings.Split(trimmedEnvVar, "=")
-		if len(parts) != 2 {
+		// Split on the first '=' only: values can contain '=' (e.g. base64, connection strings).
+		parts := strings.SplitN(trimmedEnvVar, "=", 2)
+		if strings.TrimSpace(parts[0]) == "" {
 			return nil, NewError(fmt.Sprintf("environment variable must be in the format ENVARKEY=VALUE: %s", trimmedEnvVar))
 		}
 
@@ -291,10 +308,29 @@ func getEnvVarsDaggerFromSlice(envVars []string) ([]EnvVarDagger, error) {
 	return envVarsDagger, nil
 }
 
-// parseDotEnvFiles processes .env files found by WithDotEnvFile.
-// It handles basic .env syntax including comments (#), empty lines,
-// KEY=VALUE pairs, whitespace trimming, and basic quote removal (' or ").
-func parseDotEnvFiles(ctx context.Context, container *dagger.Container, src *dagger.Directory, envFiles []string) (*dagger.Container, error) {
+// parseDotEnvFiles parses dotenv files, in order. Variables interpolated in a file are resolved
+// against the variables set by the same file and the files before it, then with lookup.
+// SOPS-encrypted files are decrypted first, and their entries are flagged as encrypted.
+//
+// Parameters:
+//   - ctx: Context for the Dagger operations
+//   - src: Directory containing the dotenv files
+//   - envFiles: The dotenv files to parse, relative to src, from the lowest to the highest precedence
+//   - lookup: Resolves the interpolated variables that no dotenv file sets
+//
+// Returns:
+//   - []dotEnvEntry: Every assignment, in order
+//   - error: An error if a file cannot be read or parsed
+func (m *Infra) parseDotEnvFiles(
+	ctx context.Context,
+	src *dagger.Directory,
+	envFiles []string,
+	lookup func(name string) (string, bool),
+) ([]dotEnvEntry, error) {
+	values := map[string]string{}
+
+	var entries []dotEnvEntry
+
 	for _, file := range envFiles {
 		fileContent, err := src.File(file).Contents(ctx)
 		if err != nil {
@@ -302,52 +338,34 @@ func parseDotEnvFiles(ctx context.Context, container *dagger.Container, src *dag
 			return nil, fmt.Errorf("failed to read dot env file '%s': %w", file, err)
 		}
 
-		lines := strings.Split(fileContent, "\n")
-
-		for lineNum, line := range lines {
-			trimmedLine := strings.TrimSpace(line)
-
-			// Skip empty lines and comments
-			if trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
-				continue
-			}
-
-			// Split line into key/value pair by the first '='
-			parts := strings.SplitN(trimmedLine, "=", 2)
-			if len(parts) != 2 {
-				// Return error for lines without '='
-				return nil, fmt.Errorf("invalid format in file '%s' on line %d: '%s'", file, lineNum+1, trimmedLine)
-			}
-
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-
-			// Check for empty key
-			if key == "" {
-				return nil, fmt.Errorf("empty key found in file '%s' on line %d: '%s'", file, lineNum+1, trimmedLine)
+		sopsType, encrypted := getSOPSType(file, fileContent)
+		if encrypted {
+			fileContent, err = m.decryptSOPSFile(ctx, file, fileContent, sopsType, sopsTypeDotEnv)
+			if err != nil {
+				return nil, err
 			}
+		}
 
-			// Trim surrounding quotes (basic handling)
-			if len(value) >= 2 {
-				if (value[0] == '"' && value[len(value)-1] == '"') || (value[0] == '\'' && value[len(value)-1] == '\'') {
-					value = value[1 : len(value)-1]
-				}
+		fileEntries, err := parseDotEnv(file, fileContent, func(name string) (string, bool) {
+			if value, ok := values[name]; ok {
+				return value, true
 			}
 
-			// Determine if it's a secret based on filename
-			isSecret := strings.Contains(file, "secret")
+			return lookup(name)
+		})
+		if err != nil {
+			return nil, err
+		}
 
-			if isSecret {
-				// Use a distinct name for the Dagger secret object itself
-				secretName := fmt.Sprintf("%s_secret_%s", key, file)
-				container = container.WithSecretVariable(key, dag.SetSecret(secretName, value))
-			} else {
-				container = container.WithEnvVariable(key, value)
-			}
+		for i := range fileEntries {
+			fileEntries[i].encrypted = encrypted
+			values[fileEntries[i].key] = fileEntries[i].value
 		}
+
+		entries = append(entries, fileEntries...)
 	}
 
-	return container, nil
+	return entries, nil
 }
 
 // parseVariablesFromSlice converts a slice of "KEY=VALUE" strings into a map[string]string.
@@ -535,22 +553,29 @@ func addDaggerCMDs(container *dagger.Container, commands ...DaggerCMD) *dagger.C
 //   - Commands are executed sequentially in the order provided
 //   - Container state is preserved between command executions
 //   - Only the final stdout output is captured and returned
+//   - The retry policy and the step timeouts configured on the Infra instance are applied
 //   - Errors during command execution are wrapped with context information
 //   - Results are sent asynchronously through the provided channel
 //
+// Cancellation:
+//   - If the context is already cancelled, no command is executed and the result reports it
+//   - If the context is cancelled while running, the result names the step that was interrupted
+//   - Exactly one result is always sent, so collectors can rely on the number of results
+//
 // Error Handling:
 //   - Command execution errors are captured and included in the JobResult
 //   - Errors are wrapped with the working directory context for debugging
+//   - Registered secret values are redacted from the output and the error
 //   - The function does not panic on errors but reports them through the result channel
 //
 // Example Usage:
 //
 //	resultChan := make(chan JobResult, 1)
-//	go executeDaggerCtrAsync(ctx, resultChan, container, "module1", [][]string{
+//	go m.executeDaggerCtrAsync(ctx, resultChan, container, "module1", [][]string{
 //	  {"terraform", "init"},
 //	  {"terraform", "validate"},
 //	})
-func executeDaggerCtrAsync(
+func (m *Infra) executeDaggerCtrAsync(
 	ctx context.Context,
 	resultChan chan<- JobResult,
 	baseCtr *dagger.Container,
@@ -559,10 +584,31 @@ func executeDaggerCtrAsync(
 ) {
 	jobRes := JobResult{WorkDir: tgWorkDir, Output: "", Err: nil}
 
-	execCtr := baseCtr
+	defer func() {
+		jobRes.Output = m.redact(ctx, jobRes.Output)
+		jobRes.Err = m.redactError(ctx, jobRes.Err)
+		resultChan <- jobRes
+	}()
+
+	if err := ctx.Err(); err != nil {
+		jobRes.Err = WrapErrorf(err, "dagger commands were not started on working directory %s, the context was cancelled", tgWorkDir)
+
+		return
+	}
+
+	daggerCMDs := make([]DaggerCMD, 0, len(commands))
 	for _, command := range commands {
-		execCtr = execCtr.
-			WithExec(command)
+		daggerCMDs = append(daggerCMDs, command)
+	}
+
+	execCtr, attempts, err := m.runDaggerCMDs(ctx, baseCtr, daggerCMDs...)
+	jobRes.Attempts = attempts
+	jobRes.Credentials = m.CredentialsInUse
+
+	if err != nil {
+		jobRes.Err = WrapErrorf(err, "dagger command failed on working directory: %s", tgWorkDir)
+
+		return
 	}
 
 	stdout, err := execCtr.Stdout(ctx)
@@ -571,6 +617,33 @@ func executeDaggerCtrAsync(
 	if err != nil {
 		jobRes.Err = WrapErrorf(err, "dagger command failed on working directory: %s", tgWorkDir)
 	}
+}
+
+// getTerraformModuleNames returns the names of the modules of the source directory: the
+// directories of modules/ holding at least one .tf file, sorted.
+func (m *Infra) getTerraformModuleNames(ctx context.Context) ([]string, error) {
+	if m.Src == nil {
+		return nil, NewError("failed to list the modules, the source directory is nil")
+	}
+
+	tfFiles, err := m.Src.Glob(ctx, filepath.Join(configTerraformModulesRootPath, "*", "*.tf"))
+	if err != nil {
+		return nil, WrapErrorf(err, "failed to list the modules")
+	}
+
+	seen := map[string]bool{}
+
+	var names []string
+
+	for _, tfFile := range tfFiles {
+		name := filepath.Base(filepath.Dir(tfFile))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
 
-	resultChan <- jobRes
+	return names, nil
 }
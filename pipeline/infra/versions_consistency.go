@@ -0,0 +1,837 @@
+package main
+
+import (
+	"context"
+	"dagger/infra/internal/dagger"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configTestTargetsRootPath is where the test targets of the modules live, one directory per
+	// target under tests/modules/<module>/target/.
+	configTestTargetsRootPath = "tests/modules"
+	// terraformCoreName is the name the required_version constraint is reported under.
+	terraformCoreName = "terraform"
+)
+
+// versionConstraintPattern matches a single version constraint, e.g. "~> 3.6.0".
+var versionConstraintPattern = regexp.MustCompile(`^\s*(=|!=|>=|<=|>|<|~>)?\s*v?([0-9][0-9A-Za-z.+-]*)\s*$`)
+
+// terraformVersionsTarget is a directory whose version constraints are checked: a module, an
+// example or a test target.
+type terraformVersionsTarget struct {
+	dir             string
+	requiredVersion string
+	providers       []*terraformRequiredProvider
+	// calls are the directories of the modules called with a local source.
+	calls []string
+}
+
+// VersionsPolicy is the single set of constraints every module, example and test target follows.
+type VersionsPolicy struct {
+	RequiredVersion   string                             `yaml:"required_version"`
+	RequiredProviders map[string]*VersionsPolicyProvider `yaml:"required_providers"`
+}
+
+// VersionsPolicyProvider is the constraint of a provider in a VersionsPolicy.
+type VersionsPolicyProvider struct {
+	Source  string `yaml:"source"`
+	Version string `yaml:"version"`
+}
+
+// versionRange is the range of versions a constraint allows. A nil bound is unbounded.
+type versionRange struct {
+	lower          *version.Version
+	lowerInclusive bool
+	upper          *version.Version
+	upperInclusive bool
+}
+
+// getVersionRange returns the range of versions allowed by a constraint such as ">= 1.2, < 2.0".
+// Exclusions ("!=") do not narrow the range.
+func getVersionRange(constraint string) (*versionRange, error) {
+	allowed := &versionRange{}
+
+	if strings.TrimSpace(constraint) == "" {
+		return allowed, nil
+	}
+
+	if _, err := version.NewConstraint(constraint); err != nil {
+		return nil, Errorf("invalid version constraint %q: %v", constraint, err)
+	}
+
+	for _, part := range strings.Split(constraint, ",") {
+		match := versionConstraintPattern.FindStringSubmatch(part)
+		if match == nil {
+			return nil, Errorf("invalid version constraint %q", part)
+		}
+
+		bound, err := version.NewVersion(match[2])
+		if err != nil {
+			return nil, Errorf("invalid version constraint %q: %v", part, err)
+		}
+
+		var partRange *versionRange
+
+		switch match[1] {
+		case "", "=":
+			partRange = &versionRange{lower: bound, lowerInclusive: true, upper: bound, upperInclusive: true}
+		case ">=":
+			partRange = &versionRange{lower: bound, lowerInclusive: true}
+		case ">":
+			partRange = &versionRange{lower: bound}
+		case "<=":
+			partRange = &versionRange{upper: bound, upperInclusive: true}
+		case "<":
+			partRange = &versionRange{upper: bound}
+		case "~>":
+			// The rightmost segment given may increase: "~> 1.2.3" is ">= 1.2.3, < 1.3.0", and
+			// "~> 1.2" is ">= 1.2, < 2.0".
+			given := len(strings.Split(strings.SplitN(strings.SplitN(match[2], "-", 2)[0], "+", 2)[0], "."))
+			segments := bound.Segments()
+			increased := max(given-2, 0)
+
+			upperSegments := make([]string, increased+1)
+			for i := range upperSegments {
+				upperSegments[i] = fmt.Sprintf("%d", segments[i])
+			}
+
+			upperSegments[increased] = fmt.Sprintf("%d", segments[increased]+1)
+
+			upper, err := version.NewVersion(strings.Join(upperSegments, "."))
+			if err != nil {
+				return nil, Errorf("invalid version constraint %q: %v", part, err)
+			}
+
+			partRange = &versionRange{lower: bound, lowerInclusive: true, upper: upper}
+		default:
+			continue
+		}
+
+		allowed = allowed.intersect(partRange)
+	}
+
+	return allowed, nil
+}
+
+// compareLower orders lower bounds, from the loosest to the strictest.
+func compareLower(a, b *versionRange) int {
+	switch {
+	case a.lower == nil && b.lower == nil:
+		return 0
+	case a.lower == nil:
+		return -1
+	case b.lower == nil:
+		return 1
+	}
+
+	if cmp := a.lower.Compare(b.lower); cmp != 0 {
+		return cmp
+	}
+
+	switch {
+	case a.lowerInclusive == b.lowerInclusive:
+		return 0
+	case a.lowerInclusive:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// compareUpper orders upper bounds, from the strictest to the loosest.
+func compareUpper(a, b *versionRange) int {
+	switch {
+	case a.upper == nil && b.upper == nil:
+		return 0
+	case a.upper == nil:
+		return 1
+	case b.upper == nil:
+		return -1
+	}
+
+	if cmp := a.upper.Compare(b.upper); cmp != 0 {
+		return cmp
+	}
+
+	switch {
+	case a.upperInclusive == b.upperInclusive:
+		return 0
+	case a.upperInclusive:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// intersect returns the versions allowed by both ranges.
+func (r *versionRange) intersect(other *versionRange) *versionRange {
+	intersection := *r
+
+	if compareLower(other, r) > 0 {
+		intersection.lower, intersection.lowerInclusive = other.lower, other.lowerInclusive
+	}
+
+	if compareUpper(other, r) < 0 {
+		intersection.upper, intersection.upperInclusive = other.upper, other.upperInclusive
+	}
+
+	return &intersection
+}
+
+// isEmpty reports whether the range allows no version.
+func (r *versionRange) isEmpty() bool {
+	if r.lower == nil || r.upper == nil {
+		return false
+	}
+
+	cmp := r.lower.Compare(r.upper)
+
+	return cmp > 0 || (cmp == 0 && !(r.lowerInclusive && r.upperInclusive))
+}
+
+// isNarrowerThan reports whether the range allows a strict subset of the versions of another.
+func (r *versionRange) isNarrowerThan(other *versionRange) bool {
+	lower, upper := compareLower(r, other), compareUpper(r, other)
+
+	return lower >= 0 && upper <= 0 && (lower != 0 || upper != 0)
+}
+
+// parseTerraformVersionsTarget reads the required_version, required_providers and local module
+// calls of the .tf files of a directory.
+func parseTerraformVersionsTarget(dir string, files map[string]string) (*terraformVersionsTarget, error) {
+	providers, err := parseRequiredProviders(files)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &terraformVersionsTarget{dir: dir, providers: providers}
+	parser := hclparse.NewParser()
+
+	var requiredVersions []string
+
+	for _, name := range sortedMapKeys(files) {
+		file, diags := parser.ParseHCL([]byte(files[name]), name)
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		content, _, diags := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+		})
+		if diags.HasErrors() {
+			return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+		}
+
+		for _, block := range content.Blocks {
+			blockContent, _, diags := block.Body.PartialContent(&hcl.BodySchema{
+				Attributes: []hcl.AttributeSchema{{Name: "required_version"}},
+			})
+			if diags.HasErrors() {
+				return nil, Errorf("failed to parse %s: %s", name, diags.Error())
+			}
+
+			attr, ok := blockContent.Attributes["required_version"]
+			if !ok {
+				continue
+			}
+
+			value, diags := attr.Expr.Value(nil)
+			if diags.HasErrors() || value.Type() != cty.String || value.IsNull() {
+				return nil, Errorf("the required_version in %s must be a literal string", name)
+			}
+
+			requiredVersions = append(requiredVersions, value.AsString())
+		}
+	}
+
+	calls, err := parseModuleCalls(dir, files)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, call := range calls {
+		if call.dir != "" {
+			target.calls = append(target.calls, call.dir)
+		}
+	}
+
+	// Terraform requires every required_version of a module to be met.
+	target.requiredVersion = strings.Join(requiredVersions, ", ")
+
+	return target, nil
+}
+
+// getConstraints returns the version constraints of a target, by provider name, with the
+// required_version under "terraform", and the sources of its providers.
+func (t *terraformVersionsTarget) getConstraints() (map[string]string, map[string]string) {
+	constraints := map[string]string{terraformCoreName: t.requiredVersion}
+	sources := map[string]string{}
+
+	for _, provider := range t.providers {
+		constraints[provider.name] = provider.constraint
+		sources[provider.name] = provider.source
+	}
+
+	return constraints, sources
+}
+
+// getTerraformVersionsTargets reads the modules, their examples and their test targets.
+func (m *Infra) getTerraformVersionsTargets(ctx context.Context) ([]*terraformVersionsTarget, error) {
+	if m.Src == nil {
+		return nil, NewError("failed to read the version constraints, the source directory is nil")
+	}
+
+	var tfFiles []string
+
+	for _, pattern := range []string{
+		filepath.Join(configTerraformModulesRootPath, "*", "*.tf"),
+		filepath.Join(configExamplesRootPath, "*", "*", "*.tf"),
+		filepath.Join(configTestTargetsRootPath, "*", "target", "*", "*.tf"),
+	} {
+		matches, err := m.Src.Glob(ctx, pattern)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to list %s", pattern)
+		}
+
+		tfFiles = append(tfFiles, matches...)
+	}
+
+	var dirs []string
+
+	for _, tfFile := range tfFiles {
+		if dir := filepath.Dir(tfFile); !contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	sort.Strings(dirs)
+
+	targets := make([]*terraformVersionsTarget, 0, len(dirs))
+
+	for _, dir := range dirs {
+		files, err := m.readModuleTerraformFiles(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := parseTerraformVersionsTarget(dir, files)
+		if err != nil {
+			return nil, WrapErrorf(err, "failed to read the version constraints of %s", dir)
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// checkTerraformVersionsConsistency returns the inconsistencies of the version constraints of the
+// targets:
+//   - a constraint that is invalid, or that no version can satisfy;
+//   - a provider whose local name maps to different sources;
+//   - modules whose constraints cannot be satisfied together;
+//   - a caller (e.g. an example) whose constraint cannot be satisfied together with the one of the
+//     module it calls, or that is narrower;
+//   - with a policy, a constraint that differs from the policy.
+func checkTerraformVersionsConsistency(targets []*terraformVersionsTarget, policy *VersionsPolicy) []string {
+	var findings []string
+
+	ranges := map[string]map[string]*versionRange{}
+	byDir := map[string]*terraformVersionsTarget{}
+
+	for _, target := range targets {
+		byDir[target.dir] = target
+		ranges[target.dir] = map[string]*versionRange{}
+
+		constraints, _ := target.getConstraints()
+
+		for _, name := range sortedMapKeys(constraints) {
+			allowed, err := getVersionRange(constraints[name])
+			if err != nil {
+				findings = append(findings, fmt.Sprintf("%s: %s: %v", target.dir, name, err))
+
+				continue
+			}
+
+			if allowed.isEmpty() {
+				findings = append(findings, fmt.Sprintf("%s: %s: %q allows no version", target.dir, name, constraints[name]))
+
+				continue
+			}
+
+			ranges[target.dir][name] = allowed
+		}
+	}
+
+	// Sources, and constraints of the modules, by provider.
+	sources := map[string]map[string][]string{}
+	moduleRanges := map[string]*versionRange{}
+	moduleRangeDirs := map[string][]string{}
+
+	for _, target := range targets {
+		_, targetSources := target.getConstraints()
+
+		for name, source := range targetSources {
+			if sources[name] == nil {
+				sources[name] = map[string][]string{}
+			}
+
+			sources[name][source] = append(sources[name][source], target.dir)
+		}
+
+		if !strings.HasPrefix(target.dir, configTerraformModulesRootPath+"/") {
+			continue
+		}
+
+		for name, allowed := range ranges[target.dir] {
+			if moduleRanges[name] == nil {
+				moduleRanges[name] = allowed
+			} else {
+				moduleRanges[name] = moduleRanges[name].intersect(allowed)
+			}
+
+			moduleRangeDirs[name] = append(moduleRangeDirs[name], target.dir)
+		}
+	}
+
+	for _, name := range sortedMapKeys(sources) {
+		if len(sources[name]) < 2 {
+			continue
+		}
+
+		var usages []string
+		for _, source := range sortedMapKeys(sources[name]) {
+			usages = append(usages, fmt.Sprintf("%s (%s)", source, strings.Join(sources[name][source], ", ")))
+		}
+
+		findings = append(findings, fmt.Sprintf("provider %q has conflicting sources: %s", name, strings.Join(usages, "; ")))
+	}
+
+	for _, name := range sortedMapKeys(moduleRanges) {
+		if moduleRanges[name].isEmpty() {
+			findings = append(findings, fmt.Sprintf("the %s constraints of %s cannot be satisfied together",
+				name, strings.Join(moduleRangeDirs[name], ", ")))
+		}
+	}
+
+	for _, target := range targets {
+		callerConstraints, _ := target.getConstraints()
+
+		for _, call := range target.calls {
+			callee, ok := byDir[call]
+			if !ok {
+				continue
+			}
+
+			calleeConstraints, _ := callee.getConstraints()
+
+			for _, name := range sortedMapKeys(callerConstraints) {
+				callerRange, calleeRange := ranges[target.dir][name], ranges[callee.dir][name]
+				if callerRange == nil || calleeRange == nil || calleeConstraints[name] == "" {
+					continue
+				}
+
+				switch {
+				case callerRange.intersect(calleeRange).isEmpty():
+					findings = append(findings, fmt.Sprintf("%s: %s: %q cannot be satisfied together with %q of %s",
+						target.dir, name, callerConstraints[name], calleeConstraints[name], callee.dir))
+				case callerRange.isNarrowerThan(calleeRange):
+					findings = append(findings, fmt.Sprintf("%s: %s: %q is narrower than %q of %s",
+						target.dir, name, callerConstraints[name], calleeConstraints[name], callee.dir))
+				}
+			}
+		}
+	}
+
+	if policy == nil {
+		return findings
+	}
+
+	for _, target := range targets {
+		if policy.RequiredVersion != "" && !equalConstraints(target.requiredVersion, policy.RequiredVersion) {
+			findings = append(findings, fmt.Sprintf("%s: %s: %q differs from the policy %q",
+				target.dir, terraformCoreName, target.requiredVersion, policy.RequiredVersion))
+		}
+
+		for _, provider := range target.providers {
+			policyProvider, ok := policy.RequiredProviders[provider.name]
+			if !ok {
+				continue
+			}
+
+			if policyProvider.Source != "" && provider.source != policyProvider.Source {
+				findings = append(findings, fmt.Sprintf("%s: %s: source %q differs from the policy %q",
+					target.dir, provider.name, provider.source, policyProvider.Source))
+			}
+
+			if policyProvider.Version != "" && !equalConstraints(provider.constraint, policyProvider.Version) {
+				findings = append(findings, fmt.Sprintf("%s: %s: %q differs from the policy %q",
+					target.dir, provider.name, provider.constraint, policyProvider.Version))
+			}
+		}
+	}
+
+	return findings
+}
+
+// sortedMapKeys returns the keys of a map in order.
+func sortedMapKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// equalConstraints reports whether two constraints are the same, ignoring formatting.
+func equalConstraints(a, b string) bool {
+	parsedA, errA := version.NewConstraint(a)
+	parsedB, errB := version.NewConstraint(b)
+
+	if errA != nil || errB != nil {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+
+	return parsedA.String() == parsedB.String()
+}
+
+// parseVersionsPolicy reads a versions policy from a JSON or YAML document.
+func parseVersionsPolicy(document string) (*VersionsPolicy, error) {
+	policy := &VersionsPolicy{}
+
+	decoder := yaml.NewDecoder(strings.NewReader(document))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(policy); err != nil {
+		return nil, Errorf("invalid versions policy: %v", err)
+	}
+
+	if policy.RequiredVersion != "" {
+		if _, err := version.NewConstraint(policy.RequiredVersion); err != nil {
+			return nil, Errorf("invalid required_version in the versions policy: %v", err)
+		}
+	}
+
+	for name, provider := range policy.RequiredProviders {
+		if provider == nil || provider.Version == "" {
+			return nil, Errorf("provider %q of the versions policy has no version", name)
+		}
+
+		if _, err := version.NewConstraint(provider.Version); err != nil {
+			return nil, Errorf("invalid version of provider %q in the versions policy: %v", name, err)
+		}
+
+		if provider.Source != "" {
+			if _, _, _, err := getProviderSourceParts(provider.Source); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// readVersionsPolicy reads the versions policy file, if given.
+func readVersionsPolicy(ctx context.Context, policyFile *dagger.File) (*VersionsPolicy, error) {
+	if policyFile == nil {
+		return nil, nil
+	}
+
+	document, err := policyFile.Contents(ctx)
+	if err != nil {
+		return nil, WrapError(err, "failed to read the versions policy")
+	}
+
+	return parseVersionsPolicy(document)
+}
+
+// ActionTerraformVersionsCheck checks that the required_version and required_providers of the
+// modules, their examples and their test targets are consistent.
+//
+// It reports constraints that no version satisfies, providers with conflicting sources, modules
+// that cannot be used together, and examples or test targets whose constraints conflict with, or
+// are narrower than, those of the modules they call. With a policy (see
+// ActionTerraformVersionsRewrite), every constraint must also match it. After WithAffectedModules,
+// only the affected modules, examples and test targets are reported.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - policy: A JSON or YAML versions policy (optional)
+//
+// Returns:
+//   - string: The constraints of every module, example and test target
+//   - error: An error listing the inconsistencies, if any
+func (m *Infra) ActionTerraformVersionsCheck(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// policy is a JSON or YAML versions policy, with required_version and required_providers.
+	// +optional
+	policy *dagger.File,
+) (string, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	versionsPolicy, err := readVersionsPolicy(ctx, policy)
+	if err != nil {
+		return "", err
+	}
+
+	targets, err := m.getTerraformVersionsTargets(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Every target is checked, so an affected module is compared with the ones it must agree with,
+	// but only the affected targets are reported.
+	findings := checkTerraformVersionsConsistency(targets, versionsPolicy)
+	reported := targets
+
+	if m.AffectedTargets != nil {
+		affectedDirs := m.AffectedTargets.getDirs()
+
+		reported = nil
+
+		for _, target := range targets {
+			if contains(affectedDirs, target.dir) {
+				reported = append(reported, target)
+			}
+		}
+
+		var affectedFindings []string
+
+		for _, finding := range findings {
+			if isFindingAffected(finding, affectedDirs) {
+				affectedFindings = append(affectedFindings, finding)
+			}
+		}
+
+		findings = affectedFindings
+	}
+
+	var report strings.Builder
+
+	table := tabwriter.NewWriter(&report, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DIRECTORY\tNAME\tSOURCE\tCONSTRAINT")
+
+	for _, target := range reported {
+		fmt.Fprintf(table, "%s\t%s\t\t%s\n", target.dir, terraformCoreName, target.requiredVersion)
+
+		for _, provider := range target.providers {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", target.dir, provider.name, provider.source, provider.constraint)
+		}
+	}
+
+	_ = table.Flush()
+
+	if len(findings) > 0 {
+		return "", Errorf("inconsistent version constraints:\n  - %s\n\n%s", strings.Join(findings, "\n  - "), report.String())
+	}
+
+	return report.String(), nil
+}
+
+// rewriteVersionsConstraints sets the required_version and the required_providers entries of the
+// terraform blocks of a .tf file to the policy. Providers the policy does not cover are kept, and
+// configuration_aliases are preserved.
+//
+// Parameters:
+//   - name: The name of the file, for error messages
+//   - contents: The contents of the file
+//   - policy: The versions policy
+//
+// Returns:
+//   - string: The rewritten contents
+//   - bool: Whether the file changed
+//   - error: An error if the file cannot be parsed
+func rewriteVersionsConstraints(name, contents string, policy *VersionsPolicy) (string, bool, error) {
+	file, diags := hclwrite.ParseConfig([]byte(contents), name, hcl.InitialPos)
+	if diags.HasErrors() {
+		return "", false, Errorf("failed to parse %s: %s", name, diags.Error())
+	}
+
+	syntaxFile, diags := hclsyntax.ParseConfig([]byte(contents), name, hcl.InitialPos)
+	if diags.HasErrors() {
+		return "", false, Errorf("failed to parse %s: %s", name, diags.Error())
+	}
+
+	// Aliases of the providers, by name, as written in the file.
+	aliases := map[string][]byte{}
+
+	for _, block := range syntaxFile.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "terraform" {
+			continue
+		}
+
+		for _, nested := range block.Body.Blocks {
+			if nested.Type != "required_providers" {
+				continue
+			}
+
+			for providerName, attr := range nested.Body.Attributes {
+				object, ok := attr.Expr.(*hclsyntax.ObjectConsExpr)
+				if !ok {
+					continue
+				}
+
+				for _, item := range object.Items {
+					if hcl.ExprAsKeyword(item.KeyExpr) == "configuration_aliases" {
+						aliases[providerName] = item.ValueExpr.Range().SliceBytes([]byte(contents))
+					}
+				}
+			}
+		}
+	}
+
+	for _, block := range file.Body().Blocks() {
+		if block.Type() != "terraform" {
+			continue
+		}
+
+		if policy.RequiredVersion != "" && block.Body().GetAttribute("required_version") != nil {
+			block.Body().SetAttributeValue("required_version", cty.StringVal(policy.RequiredVersion))
+		}
+
+		for _, nested := range block.Body().Blocks() {
+			if nested.Type() != "required_providers" {
+				continue
+			}
+
+			for providerName := range nested.Body().Attributes() {
+				policyProvider, ok := policy.RequiredProviders[providerName]
+				if !ok {
+					continue
+				}
+
+				source := policyProvider.Source
+				if source == "" {
+					source = getProviderSource(nested.Body().GetAttribute(providerName), providerName)
+				}
+
+				entry := fmt.Sprintf("%s = {\n  source = %q\n  version = %q\n", providerName, source, policyProvider.Version)
+				if alias, ok := aliases[providerName]; ok {
+					entry += fmt.Sprintf("  configuration_aliases = %s\n", alias)
+				}
+
+				parsed, diags := hclwrite.ParseConfig([]byte(entry+"}\n"), name, hcl.InitialPos)
+				if diags.HasErrors() {
+					return "", false, Errorf("failed to render provider %q of %s: %s", providerName, name, diags.Error())
+				}
+
+				nested.Body().SetAttributeRaw(providerName, parsed.Body().GetAttribute(providerName).Expr().BuildTokens(nil))
+			}
+		}
+	}
+
+	rewritten := string(hclwrite.Format(file.Bytes()))
+
+	return rewritten, rewritten != string(hclwrite.Format([]byte(contents))), nil
+}
+
+// getProviderSource returns the source of a required_providers entry, or the implied
+// hashicorp/<name> source.
+func getProviderSource(attr *hclwrite.Attribute, providerName string) string {
+	parsed, diags := hclsyntax.ParseExpression(attr.Expr().BuildTokens(nil).Bytes(), "", hcl.InitialPos)
+	if !diags.HasErrors() {
+		if pairs, diags := hcl.ExprMap(parsed); !diags.HasErrors() {
+			for _, pair := range pairs {
+				if hcl.ExprAsKeyword(pair.Key) != "source" {
+					continue
+				}
+
+				if value, diags := pair.Value.Value(nil); !diags.HasErrors() && value.Type() == cty.String && !value.IsNull() {
+					return value.AsString()
+				}
+			}
+		}
+	}
+
+	return "hashicorp/" + providerName
+}
+
+// ActionTerraformVersionsRewrite rewrites the required_version and required_providers of every
+// module, example and test target to a single policy, and returns the changed files as a
+// directory to export over the source.
+//
+// The policy is a JSON or YAML document:
+//
+//	required_version: ">= 1.12.0"
+//	required_providers:
+//	  random:
+//	    source: hashicorp/random
+//	    version: "~> 3.6"
+//
+// Only the constraints already declared are rewritten: a required_version is not added, and
+// providers the policy does not cover are kept. ActionTerraformVersionsCheck with the same policy
+// verifies the result.
+//
+// Parameters:
+//   - ctx: Context for managing the operation's lifecycle
+//   - policy: The JSON or YAML versions policy
+//
+// Returns:
+//   - *dagger.Directory: The rewritten files, at their path in the source directory
+//   - error: An error if the policy is invalid, or a file cannot be parsed
+func (m *Infra) ActionTerraformVersionsRewrite(
+	// Context is the context for managing the operation's lifecycle
+	// +optional
+	ctx context.Context,
+	// policy is a JSON or YAML versions policy, with required_version and required_providers.
+	policy *dagger.File,
+) (*dagger.Directory, error) {
+	ctx, cancel := m.withActionTimeout(ctx)
+	defer cancel()
+
+	if policy == nil {
+		return nil, NewError("failed to rewrite the version constraints, the policy is nil")
+	}
+
+	versionsPolicy, err := readVersionsPolicy(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := m.getTerraformVersionsTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten := dag.Directory()
+
+	for _, target := range targets {
+		files, err := m.readModuleTerraformFiles(ctx, target.dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range sortedMapKeys(files) {
+			contents, changed, err := rewriteVersionsConstraints(name, files[name], versionsPolicy)
+			if err != nil {
+				return nil, err
+			}
+
+			if changed {
+				rewritten = rewritten.WithNewFile(name, contents)
+			}
+		}
+	}
+
+	return rewritten, nil
+}
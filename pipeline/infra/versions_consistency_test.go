@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// formatVersionRange renders a range as "<lower> <upper>", e.g. ">=1.2.0 <2.0.0", for comparisons.
+func formatVersionRange(r *versionRange) string {
+	var bounds []string
+
+	if r.lower != nil {
+		operator := ">"
+		if r.lowerInclusive {
+			operator = ">="
+		}
+
+		bounds = append(bounds, operator+r.lower.String())
+	}
+
+	if r.upper != nil {
+		operator := "<"
+		if r.upperInclusive {
+			operator = "<="
+		}
+
+		bounds = append(bounds, operator+r.upper.String())
+	}
+
+	return strings.Join(bounds, " ")
+}
+
+// TestGetVersionRange verifies the range allowed by every kind of constraint, including the
+// pessimistic operator on one, two and three segments.
+func TestGetVersionRange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		constraint string
+		want       string
+		wantEmpty  bool
+		wantErr    bool
+	}{
+		{constraint: "", want: ""},
+		{constraint: ">= 1.2", want: ">=1.2.0"},
+		{constraint: "> 1.2.0, <= 1.9.0", want: ">1.2.0 <=1.9.0"},
+		{constraint: "1.5.0", want: ">=1.5.0 <=1.5.0"},
+		{constraint: "= 1.5.0", want: ">=1.5.0 <=1.5.0"},
+		{constraint: ">= 1.0, != 1.3.0, < 2.0", want: ">=1.0.0 <2.0.0"},
+		{constraint: "~> 1.2.3", want: ">=1.2.3 <1.3.0"},
+		{constraint: "~> 1.2", want: ">=1.2.0 <2.0.0"},
+		{constraint: "~> 1", want: ">=1.0.0 <2.0.0"},
+		{constraint: "~> 0.14.0", want: ">=0.14.0 <0.15.0"},
+		{constraint: ">= 1.3, ~> 1.5", want: ">=1.5.0 <2.0.0"},
+		{constraint: ">= 2.0, < 1.0", want: ">=2.0.0 <1.0.0", wantEmpty: true},
+		{constraint: "> 1.0, < 1.0", want: ">1.0.0 <1.0.0", wantEmpty: true},
+		{constraint: ">= one", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := getVersionRange(tt.constraint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getVersionRange(%q) error = %v, wantErr %v", tt.constraint, err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if formatVersionRange(got) != tt.want || got.isEmpty() != tt.wantEmpty {
+				t.Errorf("getVersionRange(%q) = %q (empty: %v), want %q (empty: %v)",
+					tt.constraint, formatVersionRange(got), got.isEmpty(), tt.want, tt.wantEmpty)
+			}
+		})
+	}
+}
+
+// TestVersionRangeIntersect verifies the intersection of ranges, and that disjoint ranges
+// intersect into an empty one.
+func TestVersionRangeIntersect(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b      string
+		want      string
+		wantEmpty bool
+	}{
+		{a: ">= 1.0", b: "< 2.0", want: ">=1.0.0 <2.0.0"},
+		{a: "~> 1.2", b: ">= 1.5", want: ">=1.5.0 <2.0.0"},
+		{a: "~> 1.2.0", b: "~> 1.2", want: ">=1.2.0 <1.3.0"},
+		{a: ">= 1.0, < 2.0", b: "> 1.0, <= 2.0", want: ">1.0.0 <2.0.0"},
+		{a: "", b: "~> 3.0", want: ">=3.0.0 <4.0.0"},
+		{a: "~> 1.0", b: "~> 2.0", want: ">=2.0.0 <2.0.0", wantEmpty: true},
+		{a: "<= 1.5.0", b: ">= 1.5.0", want: ">=1.5.0 <=1.5.0"},
+		{a: "< 1.5.0", b: ">= 1.5.0", want: ">=1.5.0 <1.5.0", wantEmpty: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" and "+tt.b, func(t *testing.T) {
+			t.Parallel()
+
+			a, errA := getVersionRange(tt.a)
+			b, errB := getVersionRange(tt.b)
+
+			if errA != nil || errB != nil {
+				t.Fatalf("getVersionRange() errors = %v, %v", errA, errB)
+			}
+
+			for _, got := range []*versionRange{a.intersect(b), b.intersect(a)} {
+				if formatVersionRange(got) != tt.want || got.isEmpty() != tt.wantEmpty {
+					t.Errorf("intersect() = %q (empty: %v), want %q (empty: %v)",
+						formatVersionRange(got), got.isEmpty(), tt.want, tt.wantEmpty)
+				}
+			}
+		})
+	}
+}
+
+// TestVersionRangeIsNarrowerThan verifies that only strict subsets are reported as narrower.
+func TestVersionRangeIsNarrowerThan(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		r, other string
+		want     bool
+	}{
+		{r: "~> 1.2.0", other: "~> 1.2", want: true},
+		{r: "~> 1.2", other: "~> 1.2.0", want: false},
+		{r: "~> 1.2", other: ">= 1.2, < 2.0", want: false},
+		{r: ">= 1.5", other: ">= 1.0", want: true},
+		{r: "> 1.0", other: ">= 1.0", want: true},
+		{r: "< 2.0", other: "<= 2.0", want: true},
+		{r: ">= 1.0", other: "", want: true},
+		{r: "", other: "", want: false},
+		{r: ">= 0.5, < 1.5", other: ">= 1.0, < 2.0", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.r+" than "+tt.other, func(t *testing.T) {
+			t.Parallel()
+
+			r, errR := getVersionRange(tt.r)
+			other, errOther := getVersionRange(tt.other)
+
+			if errR != nil || errOther != nil {
+				t.Fatalf("getVersionRange() errors = %v, %v", errR, errOther)
+			}
+
+			if got := r.isNarrowerThan(other); got != tt.want {
+				t.Errorf("isNarrowerThan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}